@@ -4,15 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/swarmnative/volume-s3/internal/controller"
+	"github.com/swarmnative/volume-s3/internal/proxy"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -22,40 +26,95 @@ func main() {
 	slog.SetDefault(logger)
 
 	cfg := controller.Config{
-		MinioEndpointsCSV:   getenv("VOLS3_ENDPOINTS", "http://s3.local:9000"),
-		S3Provider:          getenv("VOLS3_PROVIDER", ""),
-		S3Endpoint:          getenv("VOLS3_ENDPOINT", "http://s3.local:9000"),
-		RcloneRemote:        getenv("VOLS3_RCLONE_REMOTE", "S3:bucket"),
-		RcloneExtraArgs:     getenv("VOLS3_RCLONE_ARGS", ""),
-		Mountpoint:          getenv("VOLS3_MOUNTPOINT", "/mnt/s3"),
-		AccessKeyFile:       getenv("VOLS3_ACCESS_KEY_FILE", "/run/secrets/s3_access_key"),
-		SecretKeyFile:       getenv("VOLS3_SECRET_KEY_FILE", "/run/secrets/s3_secret_key"),
-		MounterImage:        getenv("VOLS3_RCLONE_IMAGE", getenv("VOLS3_DEFAULT_RCLONE_IMAGE", "rclone/rclone:latest")),
-		HelperImage:         getenv("VOLS3_NSENTER_HELPER_IMAGE", ""),
-		ReadyFile:           ".ready",
-		PollInterval:        15 * time.Second,
-		MounterUpdateMode:   getenv("VOLS3_RCLONE_UPDATE_MODE", defaultUpdateMode()),
-		MounterPullInterval: parseDurationOr("24h"),
-		UnmountOnExit:       getenv("VOLS3_UNMOUNT_ON_EXIT", "true") == "true",
-		AutoCreateBucket:    getenv("VOLS3_AUTOCREATE_BUCKET", "false") == "true",
-		AutoCreatePrefix:    getenv("VOLS3_AUTOCREATE_PREFIX", "false") == "true",
-		ReadOnly:            getenv("VOLS3_READ_ONLY", "false") == "true",
-		AllowOther:          getenv("VOLS3_ALLOW_OTHER", "false") == "true",
-		EnableProxy:         getenv("VOLS3_PROXY_ENABLE", "false") == "true",
-		LocalLBEnabled:      getenv("VOLS3_PROXY_LOCAL_LB", "false") == "true",
-		ProxyPort:           getenv("VOLS3_PROXY_PORT", "8081"),
-		ProxyNetwork:        getenv("VOLS3_PROXY_NETWORK", ""),
-		LabelPrefix:         getenv("VOLS3_LABEL_PREFIX", getenv("LABEL_PREFIX", "")),
-		LabelStrict:         getenv("VOLS3_LABEL_STRICT", "false") == "true",
-		StrictReady:         getenv("VOLS3_STRICT_READY", "false") == "true",
-		Preset:              getenv("VOLS3_PRESET", ""),
-		ReadServiceLabels:   getenv("VOLS3_READ_SERVICE_LABELS", "true") == "true",
-		AutoClaimFromMounts: getenv("VOLS3_AUT_CLAIM_FROM_MOUNTS", "false") == "true",
-		ClaimAllowlistRegex: getenv("VOLS3_CLAIM_ALLOWLIST_REGEX", ""),
-		ImageCleanupEnabled: getenv("VOLS3_IMAGE_CLEANUP_ENABLED", "true") == "true",
-		ImageRetentionDays:  getenvInt("VOLS3_IMAGE_RETENTION_DAYS", 14),
-		ImageKeepRecent:     getenvInt("VOLS3_IMAGE_KEEP_RECENT", 2),
-		ManagerDockerHost:   getenv("VOLS3_MANAGER_DOCKER_HOST", ""),
+		MinioEndpointsCSV:       getenv("VOLS3_ENDPOINTS", ""),
+		EndpointHealthPath:      getenv("VOLS3_ENDPOINTS_HEALTH_PATH", ""),
+		EndpointCheckInterval:   getenvDuration("VOLS3_ENDPOINTS_CHECK_INTERVAL", 10*time.Second),
+		S3Provider:              getenv("VOLS3_PROVIDER", ""),
+		S3Endpoint:              getenv("VOLS3_ENDPOINT", "http://s3.local:9000"),
+		S3Region:                getenv("VOLS3_REGION", "us-east-1"),
+		S3PathStyle:             getenv("VOLS3_PATH_STYLE", "true") == "true",
+		RcloneRemote:            getenv("VOLS3_RCLONE_REMOTE", "S3:bucket"),
+		Backend:                 getenv("VOLS3_BACKEND", "s3"),
+		BackendParams:           getenv("VOLS3_BACKEND_PARAMS", ""),
+		RcloneExtraArgs:         getenv("VOLS3_RCLONE_ARGS", ""),
+		RcloneRCAddr:            getenv("VOLS3_RCLONE_RC_ADDR", "127.0.0.1:5572"),
+		DrainTimeout:            getenvDuration("VOLS3_DRAIN_TIMEOUT", 2*time.Minute),
+		ProbeTimeout:            getenvDuration("VOLS3_PROBE_TIMEOUT", 5*time.Second),
+		Mountpoint:              getenv("VOLS3_MOUNTPOINT", "/mnt/s3"),
+		AccessKeyFile:           getenv("VOLS3_ACCESS_KEY_FILE", "/run/secrets/s3_access_key"),
+		SecretKeyFile:           getenv("VOLS3_SECRET_KEY_FILE", "/run/secrets/s3_secret_key"),
+		SessionTokenFile:        getenv("VOLS3_SESSION_TOKEN_FILE", ""),
+		CredentialCheckInterval: getenvDuration("VOLS3_CREDENTIALS_CHECK_INTERVAL", 30*time.Second),
+		STSEndpoint:             getenv("VOLS3_STS_ENDPOINT", ""),
+		STSRoleARN:              getenv("VOLS3_STS_ROLE_ARN", ""),
+		STSTokenFile:            getenv("VOLS3_STS_TOKEN_FILE", ""),
+		STSDuration:             getenvDuration("VOLS3_STS_DURATION", time.Hour),
+		STSCredentialsDir:       getenv("VOLS3_STS_CREDENTIALS_DIR", "/var/lib/volume-s3/sts"),
+		MounterImage:            getenv("VOLS3_RCLONE_IMAGE", getenv("VOLS3_DEFAULT_RCLONE_IMAGE", "rclone/rclone:latest")),
+		HelperImage:             getenv("VOLS3_NSENTER_HELPER_IMAGE", ""),
+		ReadyFile:               ".ready",
+		PollInterval:            15 * time.Second,
+		MounterUpdateMode:       getenv("VOLS3_RCLONE_UPDATE_MODE", defaultUpdateMode()),
+		MounterUpgradeStrategy:  getenv("VOLS3_RCLONE_UPGRADE_STRATEGY", "recreate"),
+		MounterStagingRoot:      getenv("VOLS3_RCLONE_STAGING_ROOT", "/mnt/s3-staging"),
+		MounterPullInterval:     parseDurationOr("24h"),
+		UnmountOnExit:           getenv("VOLS3_UNMOUNT_ON_EXIT", "true") == "true",
+		AutoCreateBucket:        getenv("VOLS3_AUTOCREATE_BUCKET", "false") == "true",
+		AutoCreatePrefix:        getenv("VOLS3_AUTOCREATE_PREFIX", "false") == "true",
+		ReadOnly:                getenv("VOLS3_READ_ONLY", "false") == "true",
+		AllowOther:              getenv("VOLS3_ALLOW_OTHER", "false") == "true",
+		EnableProxy:             getenv("VOLS3_PROXY_ENABLE", "false") == "true",
+		ProxyEngine:             getenv("VOLS3_PROXY_ENGINE", "haproxy"),
+		ProxyLocalServices:      getenv("VOLS3_PROXY_LOCAL_SERVICES", "minio-local"),
+		ProxyRemoteService:      getenv("VOLS3_PROXY_REMOTE_SERVICE", "minio-remote"),
+		ProxyBackendPort:        getenv("VOLS3_PROXY_BACKEND_PORT", "9000"),
+		ProxyHealthPath:         getenv("VOLS3_PROXY_HEALTH_PATH", "/minio/health/ready"),
+		ProxyBalance:            getenv("VOLS3_PROXY_BALANCE", "leastconn"),
+		ProxyTimeoutConnect:     getenvDuration("VOLS3_PROXY_TIMEOUT_CONNECT", 2*time.Second),
+		ProxyTimeoutClient:      getenvDuration("VOLS3_PROXY_TIMEOUT_CLIENT", 60*time.Second),
+		ProxyTimeoutServer:      getenvDuration("VOLS3_PROXY_TIMEOUT_SERVER", 60*time.Second),
+		ProxyRetries:            getenvInt("VOLS3_PROXY_RETRIES", 2),
+		ProxyLocalWeight:        getenvInt("VOLS3_PROXY_LOCAL_WEIGHT", 100),
+		ProxyRemoteWeight:       getenvInt("VOLS3_PROXY_REMOTE_WEIGHT", 10),
+		ProxyDrainServices:      getenv("VOLS3_PROXY_DRAIN_SERVICES", ""),
+		HAProxyBinary:           getenv("VOLS3_HAPROXY_BIN", "/usr/sbin/haproxy"),
+		HAProxyConfigFile:       getenv("VOLS3_HAPROXY_CONFIG", "/app/etc/haproxy/haproxy.cfg"),
+		HAProxySocket:           getenv("VOLS3_HAPROXY_SOCKET", "/app/var/run/haproxy.sock"),
+		LocalLBEnabled:          getenv("VOLS3_PROXY_LOCAL_LB", "false") == "true",
+		ProxyPort:               getenv("VOLS3_PROXY_PORT", "8081"),
+		ProxyNetwork:            getenv("VOLS3_PROXY_NETWORK", ""),
+		LabelPrefix:             getenv("VOLS3_LABEL_PREFIX", getenv("LABEL_PREFIX", "")),
+		LabelStrict:             getenv("VOLS3_LABEL_STRICT", "false") == "true",
+		StrictReady:             getenv("VOLS3_STRICT_READY", "false") == "true",
+		Preset:                  getenv("VOLS3_PRESET", ""),
+		ReadServiceLabels:       getenv("VOLS3_READ_SERVICE_LABELS", "true") == "true",
+		AutoClaimFromMounts:     getenv("VOLS3_AUT_CLAIM_FROM_MOUNTS", "false") == "true",
+		ClaimAllowlistRegex:     getenv("VOLS3_CLAIM_ALLOWLIST_REGEX", ""),
+		ImageCleanupEnabled:     getenv("VOLS3_IMAGE_CLEANUP_ENABLED", "true") == "true",
+		ImageRetentionDays:      getenvInt("VOLS3_IMAGE_RETENTION_DAYS", 14),
+		ImageKeepRecent:         getenvInt("VOLS3_IMAGE_KEEP_RECENT", 2),
+		ManagerDockerHost:       getenv("VOLS3_MANAGER_DOCKER_HOST", ""),
+		NodeAwareClaims:         getenv("VOLS3_NODE_AWARE_CLAIMS", "false") == "true",
+		LazyMount:               getenv("VOLS3_LAZY_MOUNT", "false") == "true",
+		IdleUnmountAfter:        getenvDuration("VOLS3_IDLE_UNMOUNT_AFTER", 10*time.Minute),
+		AutoWireServices:        getenv("VOLS3_AUTO_WIRE", "false") == "true",
+		AutoWireDryRun:          getenv("VOLS3_AUTO_WIRE_DRY_RUN", "true") == "true",
+		AutoWireConstraint:      getenv("VOLS3_AUTO_WIRE_CONSTRAINT", "node.labels.mount_s3 == true"),
+		ServiceStatusLabels:     getenv("VOLS3_SERVICE_STATUS_LABELS", "false") == "true",
+		NodeReadyLabel:          getenv("VOLS3_NODE_READY_LABEL", "false") == "true",
+		NodeReadyThreshold:      getenvInt("VOLS3_NODE_READY_THRESHOLD", 3),
+		VolumePluginEnabled:     getenv("VOLS3_PLUGIN_ENABLE", "false") == "true",
+		VolumePluginSocket:      getenv("VOLS3_PLUGIN_SOCKET", "/run/docker/plugins/volume-s3.sock"),
+		VolumePluginStateFile:   getenv("VOLS3_PLUGIN_STATE_FILE", "/var/lib/volume-s3/volumes.json"),
+		ReclaimEnabled:          getenv("VOLS3_RECLAIM_ENABLE", "false") == "true",
+		ReclaimDryRun:           getenv("VOLS3_RECLAIM_DRY_RUN", "true") == "true",
+		ReclaimGracePeriod:      getenvDuration("VOLS3_RECLAIM_GRACE_PERIOD", 24*time.Hour),
+		ReclaimRetention:        getenvDuration("VOLS3_RECLAIM_RETENTION", 7*24*time.Hour),
+		ClassCatalogFile:        getenv("VOLS3_CLASS_CATALOG", ""),
+		CredentialsDir:          getenv("VOLS3_CREDENTIALS_DIR", "/run/secrets"),
+		ReadOnlyMountRoot:       getenv("VOLS3_RO_MOUNTPOINT", "/mnt/s3-ro"),
+		MounterMode:             getenv("VOLS3_MOUNTER_MODE", "shared"),
+		CSIEndpoint:             getenv("VOLS3_CSI_ENDPOINT", os.Getenv("CSI_ENDPOINT")),
 	}
 
 	// --validate-config fast path
@@ -82,6 +141,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	// native proxy engine: in-process replacement for the HAProxy sidecar
+	var s3proxy *proxy.Proxy
+	var proxySrv *http.Server
+	if cfg.EnableProxy && cfg.ProxyEngine == "native" {
+		s3proxy, err = proxy.New(proxy.Config{
+			LocalServices:  proxy.ParseServices(cfg.ProxyLocalServices),
+			RemoteService:  cfg.ProxyRemoteService,
+			BackendPort:    cfg.ProxyBackendPort,
+			HealthPath:     cfg.ProxyHealthPath,
+			Balance:        cfg.ProxyBalance,
+			ConnectTimeout: cfg.ProxyTimeoutConnect,
+			ServerTimeout:  cfg.ProxyTimeoutServer,
+			ClientTimeout:  cfg.ProxyTimeoutClient,
+			Retries:        cfg.ProxyRetries,
+		})
+		if err != nil {
+			slog.Error("init proxy", "error", err)
+			os.Exit(1)
+		}
+		go s3proxy.Run(ctx)
+		proxySrv = s3proxy.Server(":" + cfg.ProxyPort)
+		go func() {
+			slog.Info("proxy listening", slog.String("addr", proxySrv.Addr))
+			if err := proxySrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("proxy server", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
 		if ctrl.Ready() == nil {
@@ -99,6 +188,19 @@ func main() {
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(ctrl.Snapshot())
 	})
+	mux.HandleFunc("GET /claims", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ctrl.Claims())
+	})
+	mux.HandleFunc("GET /claims/{id...}", func(w http.ResponseWriter, r *http.Request) {
+		st, ok := ctrl.Claim(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "claim not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(st)
+	})
 	mux.HandleFunc("/preflight", func(w http.ResponseWriter, r *http.Request) {
 		if err := ctrl.Preflight(); err != nil {
 			http.Error(w, err.Error(), http.StatusPreconditionFailed)
@@ -148,11 +250,131 @@ func main() {
 					"# HELP s3mounter_mounter_created_total Total mounter containers created\n" +
 					"# TYPE s3mounter_mounter_created_total counter\n" +
 					"s3mounter_mounter_created_total " + itoa(ctrl.Snapshot().MounterCreatedTotal) + "\n"))
+			if len(s.Endpoints) > 0 {
+				var b strings.Builder
+				b.WriteString("# HELP s3mounter_endpoint_up Whether the endpoint passed its last probe\n" +
+					"# TYPE s3mounter_endpoint_up gauge\n")
+				for _, e := range s.Endpoints {
+					b.WriteString("s3mounter_endpoint_up{endpoint=\"" + e.URL + "\"} " + bool01(e.Healthy) + "\n")
+				}
+				b.WriteString("# HELP s3mounter_endpoint_latency_milliseconds Last probe latency\n" +
+					"# TYPE s3mounter_endpoint_latency_milliseconds gauge\n")
+				for _, e := range s.Endpoints {
+					b.WriteString("s3mounter_endpoint_latency_milliseconds{endpoint=\"" + e.URL + "\"} " + itoa(e.LatencyMs) + "\n")
+				}
+				b.WriteString("# HELP s3mounter_endpoint_selected Whether the mounter uses this endpoint\n" +
+					"# TYPE s3mounter_endpoint_selected gauge\n")
+				for _, e := range s.Endpoints {
+					b.WriteString("s3mounter_endpoint_selected{endpoint=\"" + e.URL + "\"} " + bool01(e.Selected) + "\n")
+				}
+				_, _ = w.Write([]byte(b.String()))
+			}
+			_, _ = w.Write([]byte(
+				"# HELP s3mounter_credential_rotations_total Default credential rotations adopted\n" +
+					"# TYPE s3mounter_credential_rotations_total counter\n" +
+					"s3mounter_credential_rotations_total " + itoa(s.Credentials.RotationsTotal) + "\n" +
+					"# HELP s3mounter_credential_rotation_failures_total Rotated credentials rejected by the backend\n" +
+					"# TYPE s3mounter_credential_rotation_failures_total counter\n" +
+					"s3mounter_credential_rotation_failures_total " + itoa(s.Credentials.FailuresTotal) + "\n" +
+					"# HELP s3mounter_credential_active_since_timestamp Seconds since epoch the active credentials were adopted\n" +
+					"# TYPE s3mounter_credential_active_since_timestamp gauge\n" +
+					"s3mounter_credential_active_since_timestamp " + itoa(s.Credentials.ActiveSince.Unix()) + "\n"))
+			if st := s.Credentials.STS; st != nil {
+				_, _ = w.Write([]byte(
+					"# HELP s3mounter_sts_credentials_expiry_timestamp Seconds since epoch the current STS credentials expire\n" +
+						"# TYPE s3mounter_sts_credentials_expiry_timestamp gauge\n" +
+						"s3mounter_sts_credentials_expiry_timestamp " + itoa(st.Expiration.Unix()) + "\n" +
+						"# HELP s3mounter_sts_refreshes_total Successful STS credential refreshes\n" +
+						"# TYPE s3mounter_sts_refreshes_total counter\n" +
+						"s3mounter_sts_refreshes_total " + itoa(st.Refreshes) + "\n" +
+						"# HELP s3mounter_sts_refresh_failures_total Failed STS credential refreshes\n" +
+						"# TYPE s3mounter_sts_refresh_failures_total counter\n" +
+						"s3mounter_sts_refresh_failures_total " + itoa(st.Failures) + "\n"))
+			}
+			if lm := s.LazyMount; lm != nil {
+				_, _ = w.Write([]byte(
+					"# HELP s3mounter_lazy_mounted Whether the on-demand mounter is up\n" +
+						"# TYPE s3mounter_lazy_mounted gauge\n" +
+						"s3mounter_lazy_mounted " + bool01(lm.State != "unmounted") + "\n" +
+						"# HELP s3mounter_lazy_mount_demand Claims and containers currently needing the mount\n" +
+						"# TYPE s3mounter_lazy_mount_demand gauge\n" +
+						"s3mounter_lazy_mount_demand " + itoa(int64(lm.Demand)) + "\n"))
+			}
+			if nr := s.NodeReady; nr != nil {
+				_, _ = w.Write([]byte(
+					"# HELP s3mounter_node_ready_label Published volume-s3.ready node label (1 true, 0 false or unpublished)\n" +
+						"# TYPE s3mounter_node_ready_label gauge\n" +
+						"s3mounter_node_ready_label " + bool01(nr.Label == "true") + "\n"))
+			}
+			if len(s.Rclone) > 0 {
+				var b strings.Builder
+				metric := func(name, help, typ string, val func(controller.RcloneStats) string) {
+					b.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + typ + "\n")
+					for _, r := range s.Rclone {
+						b.WriteString(name + "{mounter=\"" + r.Mounter + "\",claim=\"" + r.Claim + "\"} " + val(r) + "\n")
+					}
+				}
+				metric("s3mounter_rclone_rc_up", "Whether the mounter answered its rc API in the last reconcile", "gauge",
+					func(r controller.RcloneStats) string { return bool01(r.Up) })
+				metric("s3mounter_rclone_transferred_bytes_total", "Bytes transferred by the mounter", "counter",
+					func(r controller.RcloneStats) string { return itoa(r.Bytes) })
+				metric("s3mounter_rclone_transfers_total", "Completed transfers", "counter",
+					func(r controller.RcloneStats) string { return itoa(r.Transfers) })
+				metric("s3mounter_rclone_errors_total", "Transfer errors", "counter",
+					func(r controller.RcloneStats) string { return itoa(r.Errors) })
+				metric("s3mounter_rclone_vfs_cache_bytes", "Bytes used by the VFS disk cache", "gauge",
+					func(r controller.RcloneStats) string { return itoa(r.CacheBytes) })
+				metric("s3mounter_rclone_vfs_cache_files", "Files in the VFS disk cache", "gauge",
+					func(r controller.RcloneStats) string { return itoa(r.CacheFiles) })
+				metric("s3mounter_rclone_vfs_uploads_in_progress", "VFS uploads in progress", "gauge",
+					func(r controller.RcloneStats) string { return itoa(r.UploadsInProgress) })
+				metric("s3mounter_rclone_vfs_uploads_queued", "VFS uploads waiting in the cache (not yet on S3)", "gauge",
+					func(r controller.RcloneStats) string { return itoa(r.UploadsQueued) })
+				metric("s3mounter_rclone_vfs_errored_files", "Cached files whose upload failed", "gauge",
+					func(r controller.RcloneStats) string { return itoa(r.CacheErroredFiles) })
+				metric("s3mounter_rclone_vfs_out_of_space", "Whether the VFS cache disk is out of space", "gauge",
+					func(r controller.RcloneStats) string { return bool01(r.CacheOutOfSpace) })
+				b.WriteString("# HELP s3mounter_rclone_info rclone version of the mounter\n# TYPE s3mounter_rclone_info gauge\n")
+				for _, r := range s.Rclone {
+					if r.Version != "" {
+						b.WriteString("s3mounter_rclone_info{mounter=\"" + r.Mounter + "\",version=\"" + r.Version + "\"} 1\n")
+					}
+				}
+				_, _ = w.Write([]byte(b.String()))
+			}
+			if s3proxy != nil {
+				st := s3proxy.Stats()
+				var b strings.Builder
+				metric := func(name, help, typ string, val func(proxy.BackendStats) string) {
+					b.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + typ + "\n")
+					for _, s := range st {
+						b.WriteString(name + "{service=\"" + s.Service + "\",backend=\"" + s.Address + "\"} " + val(s) + "\n")
+					}
+				}
+				metric("s3mounter_proxy_backend_up", "Whether the proxy backend passes health checks", "gauge",
+					func(s proxy.BackendStats) string { return bool01(s.Healthy) })
+				metric("s3mounter_proxy_backend_active_connections", "In-flight requests per proxy backend", "gauge",
+					func(s proxy.BackendStats) string { return itoa(s.ActiveConns) })
+				metric("s3mounter_proxy_backend_requests_total", "Requests forwarded per proxy backend", "counter",
+					func(s proxy.BackendStats) string { return itoa(s.Requests) })
+				metric("s3mounter_proxy_backend_failures_total", "Upstream connection errors per proxy backend", "counter",
+					func(s proxy.BackendStats) string { return itoa(s.Failures) })
+				_, _ = w.Write([]byte(b.String()))
+			}
 		})
 	}
+	mux.HandleFunc("/debug/haproxy.cfg", func(w http.ResponseWriter, r *http.Request) {
+		text := ctrl.HAProxyConfig()
+		if text == "" {
+			http.Error(w, "haproxy engine not active", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(text))
+	})
 	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(controller.ValidateConfig(cfg))
+		_ = json.NewEncoder(w).Encode(ctrl.Validate())
 	})
 
 	srv := &http.Server{Addr: ":8080", Handler: mux}
@@ -164,10 +386,58 @@ func main() {
 		}
 	}()
 
+	var pluginSrv *http.Server
+	if cfg.VolumePluginEnabled {
+		sock := cfg.VolumePluginSocket
+		_ = os.MkdirAll(filepath.Dir(sock), 0o755)
+		_ = os.Remove(sock)
+		ln, err := net.Listen("unix", sock)
+		if err != nil {
+			slog.Error("volume plugin listen", "socket", sock, "error", err)
+			os.Exit(1)
+		}
+		pluginSrv = &http.Server{Handler: controller.NewVolumePlugin(ctrl, cfg.VolumePluginStateFile).Handler()}
+		go func() {
+			slog.Info("volume plugin listening", slog.String("socket", sock))
+			if err := pluginSrv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				slog.Error("volume plugin server", "error", err)
+			}
+		}()
+	}
+
+	var csiSrv *grpc.Server
+	if ep := strings.TrimSpace(cfg.CSIEndpoint); ep != "" {
+		sock := strings.TrimPrefix(ep, "unix://")
+		_ = os.MkdirAll(filepath.Dir(sock), 0o755)
+		_ = os.Remove(sock)
+		ln, err := net.Listen("unix", sock)
+		if err != nil {
+			slog.Error("csi listen", "endpoint", ep, "error", err)
+			os.Exit(1)
+		}
+		csiSrv = grpc.NewServer()
+		controller.NewCSIServer(ctrl, getenv("VOLS3_VERSION", "dev")).Register(csiSrv)
+		go func() {
+			slog.Info("csi listening", slog.String("endpoint", ep))
+			if err := csiSrv.Serve(ln); err != nil {
+				slog.Error("csi server", "error", err)
+			}
+		}()
+	}
+
 	go ctrl.Run()
 
 	<-ctx.Done()
 	_ = srv.Shutdown(context.Background())
+	if proxySrv != nil {
+		_ = proxySrv.Shutdown(context.Background())
+	}
+	if pluginSrv != nil {
+		_ = pluginSrv.Shutdown(context.Background())
+	}
+	if csiSrv != nil {
+		csiSrv.GracefulStop()
+	}
 	// best-effort cleanup
 	ctrl.Cleanup()
 }
@@ -199,6 +469,17 @@ func getenvInt(k string, def int) int {
 	return def
 }
 
+func getenvDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	return def
+}
+
 func hasArg(flag string) bool {
 	for _, a := range os.Args[1:] {
 		if a == flag {
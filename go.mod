@@ -3,17 +3,32 @@ module github.com/swarmnative/volume-s3
 go 1.22
 
 require (
-    github.com/docker/docker v26.1.3+incompatible
-    github.com/docker/go-connections v0.4.0
-    github.com/docker/go-units v0.5.0
-    github.com/opencontainers/image-spec v1.1.0
-    github.com/moby/docker-image-spec v1.1.0
-    github.com/opencontainers/go-digest v1.0.0
-    github.com/pkg/errors v0.9.1
-    github.com/gogo/protobuf v1.3.2
-    github.com/distribution/reference v0.6.0
-    golang.org/x/net v0.25.0
-    go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.53.0
-    go.opentelemetry.io/otel v1.26.0
+	github.com/container-storage-interface/spec v1.9.0
+	github.com/distribution/reference v0.6.0
+	github.com/docker/docker v26.1.3+incompatible
+	github.com/docker/go-connections v0.4.0
+	github.com/docker/go-units v0.5.0
+	github.com/gogo/protobuf v1.3.2
+	github.com/moby/docker-image-spec v1.3.1
+	github.com/opencontainers/go-digest v1.0.0
+	github.com/opencontainers/image-spec v1.1.0
+	github.com/pkg/errors v0.9.1
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.53.0
+	go.opentelemetry.io/otel v1.28.0
+	golang.org/x/net v0.25.0
+	google.golang.org/grpc v1.64.0
+	google.golang.org/protobuf v1.34.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
+require (
+	github.com/felixge/httpsnoop v1.0.4 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/golang/protobuf v1.5.4 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
+	go.opentelemetry.io/otel/trace v1.28.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+)
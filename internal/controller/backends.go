@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/swarmnative/volume-s3/internal/s3"
+)
+
+// Storage backends. VOLS3_BACKEND selects the rclone backend of every remote
+// (default s3) and VOLS3_BACKEND_PARAMS adds rclone options as
+// comma-separated key=value pairs, exported as RCLONE_CONFIG_<REMOTE>_<KEY>.
+// The key files (and credential sets) map onto the backend's own user/secret
+// options, e.g. user/pass for WebDAV and SFTP; options rclone stores obscured
+// are obscured here. S3-only steps (native bucket/prefix creation, key
+// validation, STS, presets, endpoint failover) are skipped for the others:
+// bucket backends create buckets with rclone mkdir, path backends (webdav,
+// sftp) have no buckets and create the claim directory instead.
+
+type backendDef struct {
+	rcloneType  string
+	accessParam string     // option receiving the access key; "" ignores the key files
+	secretParam string     // option receiving the secret key
+	obscured    []string   // options rclone expects obscured (rclone obscure)
+	required    [][]string // each group needs one of its options
+	buckets     bool       // the first path segment is a bucket/container
+}
+
+var backends = map[string]backendDef{
+	"s3":        {rcloneType: "s3", accessParam: "access_key_id", secretParam: "secret_access_key", buckets: true},
+	"gcs":       {rcloneType: "google cloud storage", required: [][]string{{"service_account_file", "service_account_credentials", "env_auth"}}, buckets: true},
+	"azureblob": {rcloneType: "azureblob", accessParam: "account", secretParam: "key", required: [][]string{{"account", "sas_url", "env_auth"}}, buckets: true},
+	"swift":     {rcloneType: "swift", accessParam: "user", secretParam: "key", required: [][]string{{"auth", "env_auth"}}, buckets: true},
+	"webdav":    {rcloneType: "webdav", accessParam: "user", secretParam: "pass", obscured: []string{"pass"}, required: [][]string{{"url"}}},
+	"sftp":      {rcloneType: "sftp", accessParam: "user", secretParam: "pass", obscured: []string{"pass", "key_file_pass"}, required: [][]string{{"host"}}},
+}
+
+var backendParamKeyRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// backendName normalizes VOLS3_BACKEND; rclone's own type names are accepted.
+func backendName(b string) string {
+	b = strings.ToLower(strings.TrimSpace(b))
+	switch b {
+	case "":
+		return "s3"
+	case "google cloud storage", "gs":
+		return "gcs"
+	case "azure":
+		return "azureblob"
+	}
+	return b
+}
+
+func (c *Controller) backend() backendDef {
+	return backends[backendName(c.cfg.Backend)]
+}
+
+// s3Backend reports whether remotes are S3, where the native client applies.
+func (c *Controller) s3Backend() bool {
+	return backendName(c.cfg.Backend) == "s3"
+}
+
+// parseBackendParams parses "key=value,key2=value2".
+func parseBackendParams(s string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		k = strings.ToLower(strings.TrimSpace(k))
+		if !ok || !backendParamKeyRe.MatchString(k) {
+			return nil, fmt.Errorf("invalid backend param %q (want key=value)", kv)
+		}
+		if k == "type" {
+			return nil, fmt.Errorf("backend param %q: the type is set by the backend", kv)
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+// missingBackendParams lists the required option groups not satisfied by
+// params or, when keys are set, by the options the key files map onto.
+func missingBackendParams(def backendDef, params map[string]string, keys bool) []string {
+	have := func(k string) bool {
+		if params[k] != "" {
+			return true
+		}
+		return keys && (k == def.accessParam || k == def.secretParam)
+	}
+	var missing []string
+	for _, group := range def.required {
+		ok := false
+		for _, k := range group {
+			ok = ok || have(k)
+		}
+		if !ok {
+			missing = append(missing, strings.Join(group, "|"))
+		}
+	}
+	return missing
+}
+
+// remoteEnvPrefix is the env prefix rclone reads the config of remote from.
+func remoteEnvPrefix(remote string) string {
+	return "RCLONE_CONFIG_" + strings.ToUpper(strings.ReplaceAll(remote, "-", "_")) + "_"
+}
+
+// defaultRemoteName is the remote of cfg.RcloneRemote, e.g. S3 in S3:bucket.
+func (c *Controller) defaultRemoteName() string {
+	if name, _, ok := strings.Cut(c.cfg.RcloneRemote, ":"); ok && strings.TrimSpace(name) != "" {
+		return strings.TrimSpace(name)
+	}
+	return defaultRemote
+}
+
+// remoteEnv builds RCLONE_CONFIG_<REMOTE>_* for a remote of the configured
+// backend using the keys cr. Invalid params are reported by ValidateConfig
+// and left out here.
+func (c *Controller) remoteEnv(remote string, cr s3.Credentials) []string {
+	p := remoteEnvPrefix(remote)
+	def := c.backend()
+	env := []string{p + "TYPE=" + def.rcloneType}
+	if c.s3Backend() {
+		env = append(env, p+"ENDPOINT="+c.resolveEndpointForMounter())
+		if strings.TrimSpace(c.cfg.S3Provider) != "" {
+			env = append(env, p+"PROVIDER="+c.cfg.S3Provider)
+		}
+	}
+	if def.accessParam != "" && cr.AccessKey != "" {
+		env = append(env,
+			p+strings.ToUpper(def.accessParam)+"="+backendValue(def, def.accessParam, cr.AccessKey),
+			p+strings.ToUpper(def.secretParam)+"="+backendValue(def, def.secretParam, cr.SecretKey),
+		)
+	}
+	if c.s3Backend() && cr.SessionToken != "" {
+		env = append(env, p+"SESSION_TOKEN="+cr.SessionToken)
+	}
+	params, _ := parseBackendParams(c.cfg.BackendParams)
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, p+strings.ToUpper(k)+"="+backendValue(def, k, params[k]))
+	}
+	return env
+}
+
+// backendValue obscures v when rclone expects option k obscured.
+func backendValue(def backendDef, k, v string) string {
+	for _, o := range def.obscured {
+		if o == k {
+			return rcloneObscure(v)
+		}
+	}
+	return v
+}
+
+// rcloneObscureKey is the fixed key of rclone's config obscuring.
+var rcloneObscureKey = []byte{
+	0x9c, 0x93, 0x5b, 0x48, 0x73, 0x0a, 0x55, 0x4d,
+	0x6b, 0xfd, 0x7c, 0x63, 0xc8, 0x86, 0xa9, 0x2b,
+	0xd3, 0x90, 0x19, 0x8e, 0xb8, 0x12, 0x8a, 0xfb,
+	0xf4, 0xde, 0x16, 0x2b, 0x8b, 0x95, 0xf6, 0x38,
+}
+
+// rcloneObscure does what `rclone obscure` does (AES-CTR, base64url), but
+// derives the IV from the value instead of drawing it at random: the
+// mounter env, and so its spec hash, must not change between reconciles.
+func rcloneObscure(v string) string {
+	block, _ := aes.NewCipher(rcloneObscureKey)
+	sum := sha256.Sum256([]byte(v))
+	out := make([]byte, aes.BlockSize+len(v))
+	copy(out, sum[:aes.BlockSize])
+	cipher.NewCTR(block, out[:aes.BlockSize]).XORKeyStream(out[aes.BlockSize:], []byte(v))
+	return base64.RawURLEncoding.EncodeToString(out)
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// rcloneReveal is `rclone reveal`.
+func rcloneReveal(t *testing.T, s string) string {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) < aes.BlockSize {
+		t.Fatalf("obscured value %q: %v", s, err)
+	}
+	block, _ := aes.NewCipher(rcloneObscureKey)
+	out := make([]byte, len(b)-aes.BlockSize)
+	cipher.NewCTR(block, b[:aes.BlockSize]).XORKeyStream(out, b[aes.BlockSize:])
+	return string(out)
+}
+
+func TestRemoteEnvWebDAV(t *testing.T) {
+	t.Setenv("VOLS3_ACCESS_KEY", "alice")
+	t.Setenv("VOLS3_SECRET_KEY", "s3cret")
+	c := &Controller{cfg: Config{Backend: "webdav", BackendParams: "url=https://dav.example.com/remote.php/webdav, vendor=nextcloud", RcloneRemote: "dav:apps"}}
+
+	env := c.buildRcloneEnv()
+	got := map[string]string{}
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		got[k] = v
+	}
+	for k, want := range map[string]string{
+		"RCLONE_CONFIG_DAV_TYPE":   "webdav",
+		"RCLONE_CONFIG_DAV_URL":    "https://dav.example.com/remote.php/webdav",
+		"RCLONE_CONFIG_DAV_VENDOR": "nextcloud",
+		"RCLONE_CONFIG_DAV_USER":   "alice",
+	} {
+		if got[k] != want {
+			t.Fatalf("%s=%q, want %q (%v)", k, got[k], want, env)
+		}
+	}
+	if rcloneReveal(t, got["RCLONE_CONFIG_DAV_PASS"]) != "s3cret" {
+		t.Fatalf("pass not obscured reversibly: %q", got["RCLONE_CONFIG_DAV_PASS"])
+	}
+	if _, ok := got["RCLONE_CONFIG_DAV_ENDPOINT"]; ok {
+		t.Fatal("s3 endpoint set on a webdav remote")
+	}
+	// obscuring must not change the mounter spec between reconciles
+	if again := c.buildRcloneEnv(); strings.Join(again, "\n") != strings.Join(env, "\n") {
+		t.Fatal("env not stable")
+	}
+	if ms := c.claimMounterSpec(claimSpec{enabled: true, prefix: "team-a"}); ms.remote != "dav:apps/team-a" {
+		t.Fatalf("claim remote: %s", ms.remote)
+	}
+	if c.buildPresetArgs() != nil {
+		t.Fatal("s3 presets applied to webdav")
+	}
+}
+
+func TestBackendParamsAndValidation(t *testing.T) {
+	if _, err := parseBackendParams("host=sftp.local,bogus"); err == nil {
+		t.Fatal("param without value accepted")
+	}
+	if _, err := parseBackendParams("type=s3"); err == nil {
+		t.Fatal("type override accepted")
+	}
+	def := backends["sftp"]
+	if m := missingBackendParams(def, map[string]string{}, true); len(m) != 1 || m[0] != "host" {
+		t.Fatalf("missing: %v", m)
+	}
+	// swift keys come from the key files, the auth URL must be given
+	if m := missingBackendParams(backends["swift"], map[string]string{"auth": "https://keystone/v3"}, true); len(m) != 0 {
+		t.Fatalf("missing: %v", m)
+	}
+	if backendName("google cloud storage") != "gcs" || backendName("") != "s3" {
+		t.Fatal("backend aliases")
+	}
+
+	base := Config{Mountpoint: "/mnt/s3", RcloneRemote: "SFTP:data", MounterImage: "rclone/rclone", PollInterval: 1}
+	cfg := base
+	cfg.Backend = "sftp"
+	if vr := ValidateConfig(cfg); vr.OK || !strings.Contains(strings.Join(vr.Errors, ";"), "requires host") {
+		t.Fatalf("sftp without host: %+v", vr.Errors)
+	}
+	cfg.BackendParams = "host=sftp.local,user=svc"
+	cfg.STSEndpoint = "https://sts.local"
+	if vr := ValidateConfig(cfg); vr.OK || !strings.Contains(strings.Join(vr.Errors, ";"), "STS") {
+		t.Fatalf("sts with sftp: %+v", vr.Errors)
+	}
+	cfg = base
+	cfg.Backend = "ftp"
+	if vr := ValidateConfig(cfg); vr.OK {
+		t.Fatal("unknown backend accepted")
+	}
+}
@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Per-claim mounters: with MounterMode=per_claim every enabled claim gets its
+// own rclone container mounting <remote>:<bucket>/<prefix> at the claim path,
+// honouring the claim's access mode and volume-s3.args. Besides discovered
+// claims, the plugin volumes of this node and the CSI volumes staged here get
+// a mounter (registerNodeClaims); the shared mounter is never started.
+
+const claimMounterLabel = "swarmnative.mounter.claim"
+
+func (c *Controller) perClaimMounters() bool {
+	return strings.TrimSpace(c.cfg.MounterMode) == "per_claim"
+}
+
+// claimKey identifies a claim independently of which service declared it.
+func claimKey(cs claimSpec) string {
+	return strings.Trim(cs.bucket, "/") + "/" + strings.Trim(cs.prefix, "/")
+}
+
+// claimMounterName derives a container name from the node and the claim; a short
+// hash keeps names unique after sanitizing and truncation.
+func (c *Controller) claimMounterName(cs claimSpec) string {
+	key := claimKey(cs)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	slug := make([]rune, 0, len(key))
+	for _, r := range strings.ToLower(key) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			slug = append(slug, r)
+		} else {
+			slug = append(slug, '-')
+		}
+	}
+	s := strings.Trim(btoa(slug), "-")
+	if len(s) > 40 {
+		s = strings.Trim(s[len(s)-40:], "-")
+	}
+	return fmt.Sprintf("%s-%s-%08x", c.mounterName(), s, h.Sum32())
+}
+
+// claimRemote returns the rclone remote path for a claim, e.g. S3:bucket/prefix.
+// Claims without a bucket use the bucket of cfg.RcloneRemote; claims with
+// credentials use the remote of their credential set.
+func (c *Controller) claimRemote(cs claimSpec) string {
+	remote, path := c.cfg.RcloneRemote, ""
+	if i := strings.Index(remote, ":"); i >= 0 {
+		remote, path = remote[:i], remote[i+1:]
+	}
+	if cs.credentials != "" {
+		remote = credentialRemote(cs.credentials)
+	}
+	bucket := strings.Trim(cs.bucket, "/")
+	if bucket == "" {
+		bucket = strings.Trim(path, "/")
+	}
+	return fmt.Sprintf("%s:%s/%s", remote, bucket, strings.Trim(cs.prefix, "/"))
+}
+
+func (c *Controller) claimMounterSpec(cs claimSpec) mounterSpec {
+	args := parseArgs(c.cfg.RcloneExtraArgs)
+	if sc, ok := c.lookupClass(strings.TrimSpace(cs.class)); ok {
+		args = append(args, classMounterArgs(sc)...)
+	}
+	args = append(args, parseArgs(cs.args)...)
+	return mounterSpec{
+		name:        c.claimMounterName(cs),
+		remote:      c.claimRemote(cs),
+		mountpoint:  c.claimPath(cs),
+		readOnly:    c.cfg.ReadOnly || isReadOnlyClaim(cs),
+		extraArgs:   args,
+		labels:      map[string]string{claimMounterLabel: claimKey(cs)},
+		credentials: cs.credentials,
+	}
+}
+
+// registerNodeClaims adds the claims returned by fn (volumes in use on this
+// node outside discovery) to the per-claim mounter set; a later registration
+// under the same kind replaces the earlier one.
+func (c *Controller) registerNodeClaims(kind string, fn func() []claimSpec) {
+	c.holders.mu.Lock()
+	defer c.holders.mu.Unlock()
+	if c.holders.node == nil {
+		c.holders.node = map[string]func() []claimSpec{}
+	}
+	c.holders.node[kind] = fn
+}
+
+// nodeClaims lists the resolved claims of the registered node claim sources;
+// claims that do not resolve carry their error.
+func (c *Controller) nodeClaims() []claimSpec {
+	c.holders.mu.Lock()
+	fns := make([]func() []claimSpec, 0, len(c.holders.node))
+	for _, fn := range c.holders.node {
+		fns = append(fns, fn)
+	}
+	c.holders.mu.Unlock()
+	var out []claimSpec
+	for _, fn := range fns {
+		for _, s := range fn() {
+			if rs, err := c.resolveClaim(s); err != nil {
+				s.err = err
+			} else {
+				s = rs
+			}
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ensureClaimMounter creates the remote prefix and the mounter of one claim.
+func (c *Controller) ensureClaimMounter(cs claimSpec) error {
+	if err := c.ensureRemotePaths(cs); err != nil {
+		slog.Warn("claim ensure remote", "bucket", cs.bucket, "prefix", cs.prefix, "error", err)
+	}
+	return c.ensureMounterSpec(c.claimMounterSpec(cs))
+}
+
+// claimMountTimeout bounds the wait for a fresh claim mounter's FUSE mount.
+const claimMountTimeout = 20 * time.Second
+
+// waitMounted waits until path is a mountpoint, for callers that hand the
+// path out right away: before the mount appears it is a local directory.
+func (c *Controller) waitMounted(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !isMounted(path) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not mounted after %s", path, timeout)
+		}
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// ensureClaimMount starts the claim's own mounter and waits for its mount:
+// plugin mounts and CSI staging in per_claim mode.
+func (c *Controller) ensureClaimMount(cs claimSpec) error {
+	if err := c.ensureClaimMounter(cs); err != nil {
+		return err
+	}
+	return c.waitMounted(c.claimPath(cs), claimMountTimeout)
+}
+
+// ensureClaimMounters creates one mounter per enabled claim and removes claim
+// mounters on this node whose claim has disappeared.
+func (c *Controller) ensureClaimMounters() error {
+	specs, err := c.discoverClaims()
+	if err != nil {
+		return err
+	}
+	// node claims get mounters but stay out of the claim table
+	all := append(append([]claimSpec(nil), specs...), c.nodeClaims()...)
+	// desired keeps mounters; ensured are the claims their mounter is set up for
+	desired := map[string]struct{}{}
+	ensured := map[string]struct{}{}
+	errs := map[string]error{}
+	var firstErr error
+	for _, s := range all {
+		if !s.enabled || s.prefix == "" {
+			continue
+		}
+		key := claimKey(s)
+		// a rejected claim (e.g. a credentials file being rewritten) keeps
+		// its running mounter until the claim is gone
+		desired[key] = struct{}{}
+		if _, dup := ensured[key]; dup || s.err != nil {
+			continue
+		}
+		ensured[key] = struct{}{}
+		if err := c.ensureClaimMounter(s); err != nil {
+			slog.Warn("claim mounter", "claim", key, "error", err)
+			errs[key] = err
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	c.claimMountersDesired = len(ensured)
+	c.recordClaims(specs, errs)
+
+	args := filters.NewArgs()
+	args.Add("label", "swarmnative.mounter=managed")
+	args.Add("label", "swarmnative.mounter.node="+sanitizeHostname())
+	args.Add("label", claimMounterLabel)
+	conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		return err
+	}
+	// mounts staged by an earlier controller run still hold their mounter
+	info, _ := readMountInfo()
+	for _, ct := range conts {
+		if _, ok := desired[ct.Labels[claimMounterLabel]]; ok {
+			continue
+		}
+		if isRetired(firstName(ct.Names), ct.ID) {
+			// reapRetiredMounters removes it once released
+			continue
+		}
+		if mp := ct.Labels["swarmnative.mounter.path"]; mp != "" && countSubBinds(string(info), mp) > 0 {
+			slog.Debug("claim mounter still bound, kept", "claim", ct.Labels[claimMounterLabel], "path", mp)
+			continue
+		}
+		slog.Info("remove claim mounter", "claim", ct.Labels[claimMounterLabel], "id", ct.ID)
+		if ct.State == "running" {
+			c.drainMounter(c.ctx, ct.ID, strings.TrimPrefix(firstName(ct.Names), "/"), ct.Labels[claimMounterLabel], "claim removed")
+		}
+		_ = c.cli.ContainerRemove(c.ctx, ct.ID, container.RemoveOptions{Force: true})
+		if mp := ct.Labels["swarmnative.mounter.path"]; mp != "" {
+			if err := c.unmountIfMounted(mp); err != nil {
+				slog.Warn("claim mounter unmount", "path", mp, "error", err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// claimMountsReady checks that every desired claim mounter runs and that
+// each claim mount is mounted and passes the probe (a write unless read-only).
+func (c *Controller) claimMountsReady() error {
+	if !c.claimMountersRunning() {
+		return fmt.Errorf("claim mounters not running")
+	}
+	mounts, err := c.claimMounts()
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		if !isMounted(m.path) {
+			return fmt.Errorf("claim mount %s not mounted", m.path)
+		}
+		if err := c.probeMount(m.path, m.write).Err(); err != nil {
+			return fmt.Errorf("claim mount %s: %w", m.path, err)
+		}
+	}
+	return nil
+}
+
+// claimMount is the mount of a running claim mounter.
+type claimMount struct {
+	claim string
+	path  string
+	write bool
+}
+
+// claimMounts lists the mounts of the running claim mounters of this node;
+// retired mounters of staged upgrades no longer serve their path.
+func (c *Controller) claimMounts() ([]claimMount, error) {
+	args := filters.NewArgs()
+	args.Add("label", "swarmnative.mounter.node="+sanitizeHostname())
+	args.Add("label", claimMounterLabel)
+	args.Add("status", "running")
+	conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{Filters: args})
+	if err != nil {
+		return nil, err
+	}
+	var out []claimMount
+	for _, ct := range conts {
+		mp := ct.Labels["swarmnative.mounter.path"]
+		if mp == "" || isRetired(firstName(ct.Names), ct.ID) {
+			continue
+		}
+		out = append(out, claimMount{claim: ct.Labels[claimMounterLabel], path: mp, write: !strings.Contains(ct.Command, "--read-only")})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].path < out[j].path })
+	return out, nil
+}
+
+// claimMountersRunning reports whether every desired claim mounter is running.
+func (c *Controller) claimMountersRunning() bool {
+	args := filters.NewArgs()
+	args.Add("label", "swarmnative.mounter.node="+sanitizeHostname())
+	args.Add("label", claimMounterLabel)
+	args.Add("status", "running")
+	conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{Filters: args})
+	if err != nil {
+		return false
+	}
+	n := 0
+	for _, ct := range conts {
+		if !isRetired(firstName(ct.Names), ct.ID) {
+			n++
+		}
+	}
+	return n >= c.claimMountersDesired
+}
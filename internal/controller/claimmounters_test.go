@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestClaimPath_PerClaimBucket(t *testing.T) {
+	c := &Controller{cfg: Config{Mountpoint: "/mnt/s3", MounterMode: "per_claim", RcloneRemote: "S3:shared"}}
+	a := c.claimPath(claimSpec{bucket: "a", prefix: "data"})
+	b := c.claimPath(claimSpec{bucket: "b", prefix: "data"})
+	if a != "/mnt/s3/a/data" || b != "/mnt/s3/b/data" {
+		t.Fatalf("per-claim paths: %s %s", a, b)
+	}
+	if p := c.claimPath(claimSpec{prefix: "data"}); p != "/mnt/s3/shared/data" {
+		t.Fatalf("default bucket path: %s", p)
+	}
+	if cs := claimFromLabels(map[string]string{"volume-s3.bucket": "..", "volume-s3.prefix": "x"}); cs.err == nil {
+		t.Fatal("bucket escaping the mountpoint accepted")
+	}
+}
+
+// A rejected claim keeps its mounter; only a claim that is gone loses it.
+func TestEnsureClaimMounters_KeepsRejectedClaim(t *testing.T) {
+	var mu sync.Mutex
+	var removed []string
+	app := true
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			var out []types.Container
+			if strings.Contains(r.URL.Query().Get("filters"), claimMounterLabel) {
+				out = append(out, types.Container{ID: "m1", State: "running", Labels: map[string]string{claimMounterLabel: "data/teams/a"}})
+			} else if app {
+				out = append(out, types.Container{ID: "app", Names: []string{"/app"}, Labels: map[string]string{
+					"volume-s3.enabled": "true", "volume-s3.bucket": "data", "volume-s3.prefix": "teams/a", "volume-s3.class": "missing",
+				}})
+			}
+			_ = json.NewEncoder(w).Encode(out)
+		case r.Method == http.MethodDelete:
+			removed = append(removed, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{Mountpoint: t.TempDir(), MounterMode: "per_claim"}}
+
+	_ = c.ensureClaimMounters()
+	if len(removed) != 0 {
+		t.Fatalf("mounter of a rejected claim removed: %v", removed)
+	}
+	if st, ok := c.Claim("data/teams/a"); !ok || st.State != "Error" {
+		t.Fatalf("claim status: %+v %v", st, ok)
+	}
+	mu.Lock()
+	app = false
+	mu.Unlock()
+	_ = c.ensureClaimMounters()
+	if len(removed) != 1 || !strings.HasSuffix(removed[0], "/containers/m1") {
+		t.Fatalf("mounter of a gone claim kept: %v", removed)
+	}
+}
+
+// Plugin volumes in per_claim mode are served by their own mounter, never by
+// the shared one, and keep it while the volume exists.
+func TestVolumePlugin_PerClaimMounter(t *testing.T) {
+	created := map[string][]string{}
+	var calls []string
+	cli := fakeDocker(t, fakeUpgradeDaemon(created, &calls))
+	root := t.TempDir()
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{
+		Mountpoint: root, MounterMode: "per_claim", MounterImage: "rclone/rclone", RcloneRemote: "S3:b",
+	}}
+	p := NewVolumePlugin(c, "")
+	if err := p.create("vol1", map[string]string{"prefix": "teams/a"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func(f func() ([]byte, error)) { readMountInfo = f }(readMountInfo)
+	readMountInfo = func() ([]byte, error) {
+		return []byte("40 1 0:60 / " + root + "/b/teams/a rw - fuse.rclone S3:b/teams/a rw\n"), nil
+	}
+
+	mp, err := p.mount("vol1", "c1")
+	if err != nil || mp != root+"/b/teams/a" {
+		t.Fatalf("mount: %q %v", mp, err)
+	}
+	name := c.claimMounterName(claimSpec{prefix: "teams/a"})
+	if cmd := created[name]; len(cmd) < 3 || cmd[1] != "S3:b/teams/a" || cmd[2] != mp {
+		t.Fatalf("claim mounter: %v (created %v)", cmd, created)
+	}
+	if _, ok := created[c.mounterName()]; ok {
+		t.Fatal("shared mounter started in per_claim mode")
+	}
+
+	// the reconcile ensures the volume's mounter as well
+	delete(created, name)
+	if err := c.ensureClaimMounters(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := created[name]; !ok {
+		t.Fatalf("plugin volume mounter not ensured: %v", calls)
+	}
+	if n := len(c.nodeClaims()); n != 1 {
+		t.Fatalf("node claims: %d", n)
+	}
+}
+
+func TestCheckAndHealMount_PerClaim(t *testing.T) {
+	created := map[string][]string{}
+	var calls []string
+	daemon := fakeUpgradeDaemon(created, &calls)
+	// a file where the mount should be makes the probe fail like a dead mount
+	ok, dead := t.TempDir(), filepath.Join(t.TempDir(), "gone")
+	if err := os.WriteFile(dead, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/containers/json") {
+			mounter := func(id, name, claim, path string) types.Container {
+				return types.Container{ID: id, Names: []string{"/" + name}, State: "running",
+					Command: "rclone mount S3:b" + claim + " " + path,
+					Labels:  map[string]string{claimMounterLabel: claim, "swarmnative.mounter.path": path}}
+			}
+			_ = json.NewEncoder(w).Encode([]types.Container{
+				mounter("m1", "rclone-mounter-a", "/teams/a", ok),
+				mounter("m2", "rclone-mounter-b", "/teams/b", dead),
+				// a retired mounter no longer serves the path
+				mounter("m3", retiredName("rclone-mounter-a", "m3"), "/teams/a", filepath.Join(ok, "x")),
+			})
+			return
+		}
+		daemon(w, r)
+	})
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{
+		Mountpoint: t.TempDir(), MounterMode: "per_claim", HelperImage: "helper", RcloneRemote: "S3:b",
+	}}
+	c.recordClaims([]claimSpec{{enabled: true, prefix: "teams/a"}, {enabled: true, prefix: "teams/b"}}, nil)
+
+	if err := c.checkAndHealMount(); err != nil {
+		t.Fatalf("heal: %v", err)
+	}
+	got := created[c.helperName("umount-helper")]
+	if len(got) == 0 || got[len(got)-1] != dead {
+		t.Fatalf("dead claim mount not healed: %q", got)
+	}
+	probes := map[string]string{}
+	for _, p := range c.probesSnapshot() {
+		probes[p.Path] = p.Result
+	}
+	if probes[ok] != ProbeOK || probes[dead] == "" || probes[dead] == ProbeOK || len(probes) != 2 {
+		t.Fatalf("probes: %v", probes)
+	}
+	for _, st := range c.claimsSnapshot() {
+		if bad := st.Claim == "/teams/b"; bad != (st.State == "Error") {
+			t.Fatalf("claim %s: state %s %s", st.Claim, st.State, st.Error)
+		}
+	}
+}
+
+func TestReady_PerClaim(t *testing.T) {
+	var mu sync.Mutex
+	var listed []types.Container
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/containers/json") {
+			_ = json.NewEncoder(w).Encode(listed)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mp := t.TempDir()
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{Mountpoint: t.TempDir(), MounterMode: "per_claim", RcloneRemote: "S3:b"}}
+	c.claimMountersDesired = 1
+	defer func(f func() ([]byte, error)) { readMountInfo = f }(readMountInfo)
+	readMountInfo = func() ([]byte, error) { return nil, nil }
+
+	// the writable mountpoint directory alone does not make the node ready
+	if err := c.Ready(); err == nil {
+		t.Fatal("ready without claim mounters")
+	}
+	mu.Lock()
+	listed = []types.Container{{ID: "m1", Names: []string{"/rclone-mounter-a"}, State: "running",
+		Labels: map[string]string{claimMounterLabel: "/teams/a", "swarmnative.mounter.path": mp}}}
+	mu.Unlock()
+	if err := c.Ready(); err == nil || !strings.Contains(err.Error(), "not mounted") {
+		t.Fatalf("ready before the claim is mounted: %v", err)
+	}
+	readMountInfo = func() ([]byte, error) {
+		return []byte("50 1 0:52 / " + mp + " rw - fuse.rclone S3:b/teams/a rw\n"), nil
+	}
+	if err := c.Ready(); err != nil {
+		t.Fatalf("ready: %v", err)
+	}
+}
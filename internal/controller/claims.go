@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Claim table served by /status, /claims and /claims/{id}. It is rebuilt on
+// every reconcile from the discovered claims; first-seen time and the last
+// error survive across reconciles so a transient failure stays visible.
+// State is the claim phase, as on a Kubernetes PVC: Bound once provisioned,
+// Pending while the mount is not usable, Error when provisioning failed.
+
+// ClaimStatus reports a discovered claim and its provisioning state.
+type ClaimStatus struct {
+	// ID is <bucket>/<prefix> with the default bucket filled in
+	ID      string
+	Claim   string
+	Source  string
+	Sources []string `json:",omitempty"`
+	Bucket  string
+	Prefix  string
+	Class   string `json:",omitempty"`
+	Access  string `json:",omitempty"`
+	Reclaim string `json:",omitempty"`
+	Path    string
+	// State is Bound, Pending or Error; Message says why it is Pending
+	State   string
+	Message string `json:",omitempty"`
+	// RemoteMkdir is ok, failed or skipped (autocreate off, read-only, no bucket)
+	RemoteMkdir      string     `json:",omitempty"`
+	RemoteMkdirError string     `json:",omitempty"`
+	RemoteMkdirAt    *time.Time `json:",omitempty"`
+	Error            string     `json:",omitempty"`
+	LastError        string     `json:",omitempty"`
+	LastErrorAt      *time.Time `json:",omitempty"`
+	FirstSeen        time.Time
+	LastSeen         time.Time
+}
+
+type remoteMkdirResult struct {
+	result string
+	err    string
+	at     time.Time
+}
+
+type claimTable struct {
+	mu      sync.Mutex
+	entries map[string]*ClaimStatus
+	// ro bind mounts created by this controller: target -> claim key
+	roMounts map[string]string
+	// last remote mkdir outcome per claim key
+	remote map[string]remoteMkdirResult
+}
+
+// claimID is the external identifier of a claim (bucket always set).
+func (c *Controller) claimID(cs claimSpec) string {
+	bucket := strings.Trim(cs.bucket, "/")
+	if bucket == "" {
+		remote := c.cfg.RcloneRemote
+		if i := strings.Index(remote, ":"); i >= 0 {
+			bucket = strings.Trim(remote[i+1:], "/")
+		}
+	}
+	return bucket + "/" + strings.Trim(cs.prefix, "/")
+}
+
+// noteRemoteMkdir records the outcome of ensureRemotePaths for a claim.
+func (c *Controller) noteRemoteMkdir(cs claimSpec, result string, err error) {
+	r := remoteMkdirResult{result: result, at: time.Now().UTC()}
+	if err != nil {
+		r.err = err.Error()
+	}
+	c.claims.mu.Lock()
+	if c.claims.remote == nil {
+		c.claims.remote = map[string]remoteMkdirResult{}
+	}
+	c.claims.remote[claimKey(cs)] = r
+	c.claims.mu.Unlock()
+}
+
+// recordClaims replaces the claim table with the claims seen in this reconcile.
+func (c *Controller) recordClaims(specs []claimSpec, errs map[string]error) {
+	now := time.Now().UTC()
+	c.claims.mu.Lock()
+	defer c.claims.mu.Unlock()
+	prev := c.claims.entries
+	entries := map[string]*ClaimStatus{}
+	for _, s := range specs {
+		if !s.enabled || s.prefix == "" {
+			continue
+		}
+		key := claimKey(s)
+		if st, dup := entries[key]; dup {
+			st.Sources = append(st.Sources, s.source)
+			continue
+		}
+		st := &ClaimStatus{
+			ID:        c.claimID(s),
+			Claim:     key,
+			Source:    s.source,
+			Sources:   []string{s.source},
+			Bucket:    s.bucket,
+			Prefix:    s.prefix,
+			Class:     s.class,
+			Access:    s.access,
+			Reclaim:   s.reclaim,
+			Path:      c.claimEffectivePath(s),
+			State:     "Bound",
+			FirstSeen: now,
+			LastSeen:  now,
+		}
+		if p, ok := prev[key]; ok {
+			st.FirstSeen = p.FirstSeen
+			st.LastError, st.LastErrorAt = p.LastError, p.LastErrorAt
+		}
+		if r, ok := c.claims.remote[key]; ok {
+			at := r.at
+			st.RemoteMkdir, st.RemoteMkdirError, st.RemoteMkdirAt = r.result, r.err, &at
+		}
+		if s.err != nil {
+			st.Error = s.err.Error()
+		} else if err := errs[key]; err != nil {
+			st.Error = err.Error()
+		}
+		if st.Error != "" {
+			st.State = "Error"
+			at := now
+			st.LastError, st.LastErrorAt = st.Error, &at
+		}
+		entries[key] = st
+	}
+	c.claims.entries = entries
+	for key := range c.claims.remote {
+		if _, ok := entries[key]; !ok {
+			delete(c.claims.remote, key)
+		}
+	}
+}
+
+// markClaimError sets the error of a claim already recorded in this
+// reconcile, such as a hung mount found by healing.
+func (c *Controller) markClaimError(key string, err error) {
+	now := time.Now().UTC()
+	c.claims.mu.Lock()
+	defer c.claims.mu.Unlock()
+	st, ok := c.claims.entries[key]
+	if !ok {
+		return
+	}
+	st.State, st.Error = "Error", err.Error()
+	st.LastError, st.LastErrorAt = st.Error, &now
+}
+
+// recordPendingClaims records claims that could not be provisioned yet
+// because the mount is not usable.
+func (c *Controller) recordPendingClaims(specs []claimSpec, reason error) {
+	c.recordClaims(specs, nil)
+	c.claims.mu.Lock()
+	defer c.claims.mu.Unlock()
+	for _, st := range c.claims.entries {
+		if st.State == "Bound" {
+			st.State, st.Message = "Pending", reason.Error()
+		}
+	}
+}
+
+func (c *Controller) claimsSnapshot() []ClaimStatus {
+	c.claims.mu.Lock()
+	defer c.claims.mu.Unlock()
+	out := make([]ClaimStatus, 0, len(c.claims.entries))
+	for _, st := range c.claims.entries {
+		cp := *st
+		cp.Sources = append([]string(nil), st.Sources...)
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Claims returns the claims discovered by the last reconcile.
+func (c *Controller) Claims() []ClaimStatus {
+	return c.claimsSnapshot()
+}
+
+// Claim returns one claim by ID (<bucket>/<prefix>).
+func (c *Controller) Claim(id string) (ClaimStatus, bool) {
+	id = strings.Trim(id, "/")
+	for _, st := range c.claimsSnapshot() {
+		if st.ID == id {
+			return st, true
+		}
+	}
+	return ClaimStatus{}, false
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecordClaims(t *testing.T) {
+	c := &Controller{cfg: Config{Mountpoint: "/mnt/s3", RcloneRemote: "S3:shared"}}
+	a := claimSpec{enabled: true, prefix: "teams/a", class: "fast", source: "service:a"}
+	b := claimSpec{enabled: true, bucket: "other", prefix: "b", reclaim: "Delete", source: "service:b"}
+	dup := a
+	dup.source = "container:a.1"
+
+	c.noteRemoteMkdir(b, "failed", errors.New("denied"))
+	c.recordClaims([]claimSpec{a, b, dup}, map[string]error{claimKey(b): errors.New("mkdir failed")})
+	got := c.Claims()
+	if len(got) != 2 || got[0].ID != "other/b" || got[1].ID != "shared/teams/a" {
+		t.Fatalf("unexpected claims: %+v", got)
+	}
+	if len(got[1].Sources) != 2 || got[1].Class != "fast" || got[1].Path != "/mnt/s3/teams/a" {
+		t.Fatalf("unexpected claim a: %+v", got[1])
+	}
+	if got[0].RemoteMkdir != "failed" || got[0].RemoteMkdirError != "denied" || got[0].Error != "mkdir failed" {
+		t.Fatalf("unexpected claim b: %+v", got[0])
+	}
+	first := got[0].FirstSeen
+
+	// next reconcile succeeds: error clears, last error and first-seen survive
+	c.recordClaims([]claimSpec{b}, nil)
+	st, ok := c.Claim("/other/b")
+	if !ok || st.Error != "" || st.LastError != "mkdir failed" || st.LastErrorAt == nil || !st.FirstSeen.Equal(first) {
+		t.Fatalf("unexpected claim after recovery: %+v ok=%v", st, ok)
+	}
+	if _, ok := c.Claim("shared/teams/a"); ok {
+		t.Fatal("vanished claim still listed")
+	}
+	if st.State != "Bound" {
+		t.Fatalf("state: %s", st.State)
+	}
+
+	// mount not writable: claims wait
+	c.recordPendingClaims([]claimSpec{b}, errors.New("mount not writable"))
+	if st, _ := c.Claim("other/b"); st.State != "Pending" || st.Message != "mount not writable" {
+		t.Fatalf("pending claim: %+v", st)
+	}
+}
+
+func TestCheckClaimPrefix(t *testing.T) {
+	for _, p := range []string{"", "a", "teams/app-A/data_1", "v1.2/x"} {
+		if err := checkClaimPrefix(p); err != nil {
+			t.Errorf("%q rejected: %v", p, err)
+		}
+	}
+	for _, p := range []string{"a b", "a;reboot", "x/'$(id)'", "a/../b", ".", "a//b", ".trash/x", ".csi-volumes"} {
+		if err := checkClaimPrefix(p); err == nil {
+			t.Errorf("%q accepted", p)
+		}
+	}
+	cs := claimFromLabels(map[string]string{"volume-s3.enabled": "true", "volume-s3.prefix": "a;b"})
+	if _, err := (&Controller{}).resolveClaim(cs); err == nil {
+		t.Fatal("unsafe label prefix resolved")
+	}
+	if _, err := claimFromVolumeOptions("x y", nil); err == nil {
+		t.Fatal("unsafe volume name accepted as prefix")
+	}
+}
@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Storage class catalog. volume-s3.class names an entry of a YAML/JSON file
+// (typically a Swarm config) describing backend endpoint, default bucket,
+// rclone flags, VFS cache mode and S3 storage class / SSE settings:
+//
+//	classes:
+//	  archive:
+//	    bucket: cold-data
+//	    storageClass: GLACIER_IR
+//	    cacheMode: minimal
+//	    sse: aws:kms
+//	    sseKmsKeyId: arn:aws:kms:...
+//
+// Bucket and credentials apply to every claim; mount settings (endpoint, args, cache, storage
+// class, SSE) need per-claim mounters because the shared mounter has one config.
+// A class endpoint is rejected with the shared mounter: the claim would be
+// mounted from the default endpoint.
+
+// StorageClass is one named entry of the class catalog.
+type StorageClass struct {
+	Endpoint     string `yaml:"endpoint" json:"endpoint,omitempty"`
+	Bucket       string `yaml:"bucket" json:"bucket,omitempty"`
+	Args         string `yaml:"args" json:"args,omitempty"`
+	CacheMode    string `yaml:"cacheMode" json:"cacheMode,omitempty"` // off|minimal|writes|full
+	StorageClass string `yaml:"storageClass" json:"storageClass,omitempty"`
+	SSE          string `yaml:"sse" json:"sse,omitempty"` // AES256 | aws:kms
+	SSEKMSKeyID  string `yaml:"sseKmsKeyId" json:"sseKmsKeyId,omitempty"`
+	Credentials  string `yaml:"credentials" json:"credentials,omitempty"` // secret set, see credentials.go
+}
+
+type classCatalogFile struct {
+	Classes map[string]StorageClass `yaml:"classes"`
+}
+
+var classNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// LoadClassCatalog reads and validates a class catalog file.
+func LoadClassCatalog(path string) (map[string]StorageClass, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f classCatalogFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse class catalog: %w", err)
+	}
+	var errs []string
+	for name, sc := range f.Classes {
+		if !classNameRe.MatchString(name) {
+			errs = append(errs, fmt.Sprintf("class %q: invalid name", name))
+		}
+		if sc.Endpoint != "" {
+			if u, err := url.Parse(sc.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+				errs = append(errs, fmt.Sprintf("class %q: endpoint must be a valid URL", name))
+			}
+		}
+		switch sc.CacheMode {
+		case "", "off", "minimal", "writes", "full":
+		default:
+			errs = append(errs, fmt.Sprintf("class %q: cacheMode must be one of off|minimal|writes|full", name))
+		}
+		switch sc.SSE {
+		case "", "AES256", "aws:kms":
+		default:
+			errs = append(errs, fmt.Sprintf("class %q: sse must be AES256 or aws:kms", name))
+		}
+		if sc.Credentials != "" && !validCredentialName(sc.Credentials) {
+			errs = append(errs, fmt.Sprintf("class %q: invalid credentials name", name))
+		}
+		if sc.SSEKMSKeyID != "" && sc.SSE != "aws:kms" {
+			errs = append(errs, fmt.Sprintf("class %q: sseKmsKeyId requires sse=aws:kms", name))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	if f.Classes == nil {
+		f.Classes = map[string]StorageClass{}
+	}
+	return f.Classes, nil
+}
+
+// reloadClassCatalog (re)loads the catalog when the file changed; a broken file
+// keeps the previous catalog.
+func (c *Controller) reloadClassCatalog() {
+	path := strings.TrimSpace(c.cfg.ClassCatalogFile)
+	if path == "" {
+		return
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("class catalog", "file", path, "error", err)
+		c.setClassCatalogErr(err)
+		return
+	}
+	c.classMu.RLock()
+	unchanged := c.classes != nil && st.ModTime().Equal(c.classesMod)
+	c.classMu.RUnlock()
+	if unchanged {
+		return
+	}
+	classes, err := LoadClassCatalog(path)
+	if err != nil {
+		slog.Error("class catalog", "file", path, "error", err)
+		c.setClassCatalogErr(err)
+		return
+	}
+	c.classMu.Lock()
+	c.classes = classes
+	c.classesMod = st.ModTime()
+	c.classErr = ""
+	c.classMu.Unlock()
+	slog.Info("class catalog loaded", "file", path, "classes", len(classes))
+}
+
+func (c *Controller) setClassCatalogErr(err error) {
+	c.classMu.Lock()
+	c.classErr = err.Error()
+	c.classMu.Unlock()
+}
+
+// validateClasses reports the last catalog load error and the claims naming
+// classes the catalog does not have.
+func (c *Controller) validateClasses() []string {
+	var errs []string
+	c.classMu.RLock()
+	if c.classErr != "" {
+		errs = append(errs, fmt.Sprintf("class catalog: %s", c.classErr))
+	}
+	c.classMu.RUnlock()
+	for _, st := range c.claimsSnapshot() {
+		if st.Class == "" {
+			continue
+		}
+		if _, ok := c.lookupClass(st.Class); !ok {
+			errs = append(errs, fmt.Sprintf("claim %s: unknown storage class %q", st.ID, st.Class))
+		}
+	}
+	return errs
+}
+
+func (c *Controller) lookupClass(name string) (StorageClass, bool) {
+	c.classMu.RLock()
+	defer c.classMu.RUnlock()
+	sc, ok := c.classes[name]
+	return sc, ok
+}
+
+// resolveClass validates the claim's class and applies class defaults.
+func (c *Controller) resolveClass(cs claimSpec) (claimSpec, error) {
+	name := strings.TrimSpace(cs.class)
+	if name == "" {
+		return cs, nil
+	}
+	sc, ok := c.lookupClass(name)
+	if !ok {
+		return cs, fmt.Errorf("unknown storage class %q", name)
+	}
+	if sc.Endpoint != "" && !c.perClaimMounters() {
+		return cs, fmt.Errorf("class %q endpoint requires VOLS3_MOUNTER_MODE=per_claim", name)
+	}
+	if strings.TrimSpace(cs.bucket) == "" {
+		cs.bucket = sc.Bucket
+	}
+	if cs.credentials == "" {
+		cs.credentials = sc.Credentials
+	}
+	return cs, nil
+}
+
+// classMounterArgs renders rclone flags for a class; claim args come after and win.
+func classMounterArgs(sc StorageClass) []string {
+	var args []string
+	if sc.Endpoint != "" {
+		args = append(args, "--s3-endpoint="+sc.Endpoint)
+	}
+	if sc.CacheMode != "" {
+		args = append(args, "--vfs-cache-mode="+sc.CacheMode)
+	}
+	if sc.StorageClass != "" {
+		args = append(args, "--s3-storage-class="+sc.StorageClass)
+	}
+	if sc.SSE != "" {
+		args = append(args, "--s3-server-side-encryption="+sc.SSE)
+	}
+	if sc.SSEKMSKeyID != "" {
+		args = append(args, "--s3-sse-kms-key-id="+sc.SSEKMSKeyID)
+	}
+	return append(args, parseArgs(sc.Args)...)
+}
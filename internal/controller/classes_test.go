@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeCatalog(t *testing.T, body string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "classes.yaml")
+	if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadClassCatalog(t *testing.T) {
+	p := writeCatalog(t, `
+classes:
+  archive:
+    bucket: cold
+    cacheMode: minimal
+    storageClass: GLACIER_IR
+    sse: aws:kms
+    sseKmsKeyId: key-1
+`)
+	classes, err := LoadClassCatalog(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := classes["archive"]
+	if sc.Bucket != "cold" || sc.StorageClass != "GLACIER_IR" {
+		t.Fatalf("unexpected class: %#v", sc)
+	}
+	args := strings.Join(classMounterArgs(sc), " ")
+	for _, want := range []string{"--vfs-cache-mode=minimal", "--s3-storage-class=GLACIER_IR", "--s3-server-side-encryption=aws:kms", "--s3-sse-kms-key-id=key-1"} {
+		if !strings.Contains(args, want) {
+			t.Fatalf("missing %s in %q", want, args)
+		}
+	}
+
+	bad := writeCatalog(t, "classes:\n  x:\n    cacheMode: huge\n    sseKmsKeyId: k\n")
+	if _, err := LoadClassCatalog(bad); err == nil || !strings.Contains(err.Error(), "cacheMode") || !strings.Contains(err.Error(), "sseKmsKeyId") {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+}
+
+func TestResolveClass(t *testing.T) {
+	c := &Controller{cfg: Config{ClassCatalogFile: writeCatalog(t, "classes:\n  fast:\n    bucket: hot\n")}}
+	c.reloadClassCatalog()
+	cs, err := c.resolveClass(claimSpec{prefix: "a", class: "fast"})
+	if err != nil || cs.bucket != "hot" {
+		t.Fatalf("resolve: %#v %v", cs, err)
+	}
+	cs, _ = c.resolveClass(claimSpec{prefix: "a", class: "fast", bucket: "own"})
+	if cs.bucket != "own" {
+		t.Fatalf("claim bucket should win, got %q", cs.bucket)
+	}
+	if _, err := c.resolveClass(claimSpec{prefix: "a", class: "missing"}); err == nil {
+		t.Fatal("expected unknown class error")
+	}
+}
+
+func TestValidateClasses(t *testing.T) {
+	path := writeCatalog(t, "classes:\n  fast:\n    bucket: hot\n  remote:\n    endpoint: http://other:9000\n")
+	cfg := Config{S3Endpoint: "http://s3", Mountpoint: "/mnt/s3", MounterImage: "rclone/rclone", ClassCatalogFile: path}
+	if vr := ValidateConfig(cfg); vr.OK || !strings.Contains(strings.Join(vr.Errors, ";"), `class "remote" endpoint requires`) {
+		t.Fatalf("shared mode class endpoint: %+v", vr)
+	}
+	cfg.MounterMode = "per_claim"
+	if vr := ValidateConfig(cfg); !vr.OK {
+		t.Fatalf("per_claim: %+v", vr.Errors)
+	}
+
+	c := &Controller{cfg: Config{S3Endpoint: "http://s3", Mountpoint: "/mnt/s3", MounterImage: "rclone/rclone", ClassCatalogFile: path}}
+	c.reloadClassCatalog()
+	if _, err := c.resolveClass(claimSpec{prefix: "a", class: "remote"}); err == nil {
+		t.Fatal("class endpoint accepted with the shared mounter")
+	}
+	c.recordClaims([]claimSpec{
+		{enabled: true, bucket: "b", prefix: "a", class: "fast"},
+		{enabled: true, bucket: "b", prefix: "x", class: "missing"},
+	}, nil)
+	vr := c.Validate()
+	if vr.OK || !strings.Contains(strings.Join(vr.Errors, ";"), `claim b/x: unknown storage class "missing"`) {
+		t.Fatalf("unknown class reference: %+v", vr.Errors)
+	}
+
+	// a broken catalog keeps the previous classes and is reported until fixed
+	if err := os.WriteFile(path, []byte("classes:\n  x:\n    cacheMode: huge\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c.classesMod = time.Time{}
+	c.reloadClassCatalog()
+	if _, ok := c.lookupClass("fast"); !ok {
+		t.Fatal("previous catalog dropped")
+	}
+	if vr := c.Validate(); !strings.Contains(strings.Join(vr.Errors, ";"), "class catalog: ") {
+		t.Fatalf("catalog error: %+v", vr.Errors)
+	}
+}
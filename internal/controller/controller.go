@@ -14,6 +14,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"errors"
 
@@ -23,6 +24,10 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/swarmnative/volume-s3/internal/s3"
 )
 
 type Config struct {
@@ -30,22 +35,54 @@ type Config struct {
 	S3Provider          string
 	S3Endpoint          string
 	RcloneRemote        string
+	Backend             string // rclone backend: s3 | gcs | azureblob | swift | webdav | sftp
+	BackendParams       string // extra rclone options, csv key=value
 	RcloneExtraArgs     string
+	RcloneRCAddr        string // mounter rc listen address (loopback); "" disables rc
+	DrainTimeout        time.Duration // max wait for VFS uploads before removing a mounter; <=0 disables
+	ProbeTimeout        time.Duration // deadline of a single mount probe
 	Mountpoint          string
 	AccessKeyFile       string
 	SecretKeyFile       string
+	SessionTokenFile    string        // optional; read like the key files
+	CredentialCheckInterval time.Duration // how often the key files are re-read for rotation
+	STSEndpoint         string        // empty disables STS temporary credentials
+	STSRoleARN          string
+	STSTokenFile        string        // web identity token; empty uses AssumeRole with the key files
+	STSDuration         time.Duration // requested lifetime of temporary credentials
+	STSCredentialsDir   string        // host dir shared with the mounter (credential_process)
 	MounterImage        string
 	HelperImage         string
 	ReadyFile           string
 	PollInterval        time.Duration
 	MounterUpdateMode   string // never | periodic | on_change
 	MounterPullInterval time.Duration
+	MounterUpgradeStrategy string // recreate | staged
+	MounterStagingRoot     string // staged: where new mounters are verified before the switch
 	UnmountOnExit       bool
 	AutoCreateBucket    bool
 	AutoCreatePrefix    bool
 	ReadOnly            bool
 	AllowOther          bool
 	EnableProxy         bool
+	ProxyEngine         string // haproxy (child process with generated config) | native (in-process)
+	// Proxy backends and tunables (both engines)
+	ProxyLocalServices  string // csv, resolved as tasks.<service>
+	ProxyRemoteService  string // backup service
+	ProxyBackendPort    string
+	ProxyHealthPath     string
+	ProxyBalance        string // leastconn | roundrobin
+	ProxyTimeoutConnect time.Duration
+	ProxyTimeoutClient  time.Duration
+	ProxyTimeoutServer  time.Duration
+	ProxyRetries        int
+	// HAProxy engine: server weights, drained services (csv) and file locations
+	ProxyLocalWeight   int
+	ProxyRemoteWeight  int
+	ProxyDrainServices string
+	HAProxyBinary      string
+	HAProxyConfigFile  string
+	HAProxySocket      string
 	LocalLBEnabled      bool
 	ProxyPort           string
 	ProxyNetwork        string
@@ -63,6 +100,45 @@ type Config struct {
 	ImageKeepRecent     int
 	// Optional remote manager Docker host for reading Service specs from workers
 	ManagerDockerHost   string
+	// Only provision service claims with tasks scheduled on this node
+	NodeAwareClaims     bool
+	// Create the shared mounter on demand and remove it after IdleUnmountAfter without claims
+	LazyMount        bool
+	IdleUnmountAfter time.Duration
+	// Add claim binds and placement constraints to labelled services (managers only)
+	AutoWireServices   bool
+	AutoWireDryRun     bool
+	AutoWireConstraint string
+	// Write claim status labels (volume-s3.status*) back to services
+	ServiceStatusLabels bool
+	// Publish Ready() as node label volume-s3.ready after NodeReadyThreshold agreeing reconciles
+	NodeReadyLabel     bool
+	NodeReadyThreshold int
+	// Docker volume plugin (driver: volume-s3)
+	VolumePluginEnabled   bool
+	VolumePluginSocket    string
+	VolumePluginStateFile string
+	// Reclaim of volume-s3.reclaim=Delete claims (trash after grace, purge after retention)
+	ReclaimEnabled     bool
+	ReclaimDryRun      bool
+	ReclaimGracePeriod time.Duration
+	ReclaimRetention   time.Duration
+	// Storage class catalog (YAML/JSON) referenced by volume-s3.class
+	ClassCatalogFile string
+	// Directory holding <name>_access_key/<name>_secret_key credential sets
+	CredentialsDir string
+	// VOLS3_ENDPOINTS failover: optional health path and probe interval
+	EndpointHealthPath    string
+	EndpointCheckInterval time.Duration
+	// S3 region for request signing and path-style addressing of the native client
+	S3Region    string
+	S3PathStyle bool
+	// Root for read-only bind mounts of access=ro claims (shared mounter only)
+	ReadOnlyMountRoot string
+	// Mounter topology: shared (one node-wide mounter) | per_claim (one mounter per claim)
+	MounterMode string
+	// CSI plugin endpoint for Swarm cluster volumes (e.g. unix:///run/csi/csi.sock); empty disables
+	CSIEndpoint string
 }
 
 type Controller struct {
@@ -70,8 +146,8 @@ type Controller struct {
 	cli           *client.Client
 	managerCli    *client.Client
 	cfg           Config
-	lastImagePull time.Time
-	lastImageID   string
+	// mounter image pulls and creations, shared with the CSI node server
+	image imageState
 	// metrics
 	reconcileTotal      int64
 	reconcileErrors     int64
@@ -82,11 +158,58 @@ type Controller struct {
 	healSuccessTotal    int64
 	orphanCleanupTotal  int64
 	lastHealSuccessUnix int64
-	mounterCreatedTotal int64
+	claimMountersDesired int
 	// events
 	eventCh chan struct{}
 	// cache
 	selfImageRef string
+	// serializes mounter create/recreate across the reconcile loop and plugin servers
+	mounterMu sync.Mutex
+	// reclaim tracking for volume-s3.reclaim=Delete claims
+	reclaimMu sync.Mutex
+	reclaims  map[string]*reclaimEntry
+	// remote .trash roots of per-claim reclaims, purged by timestamp
+	trashRoots map[string]struct{}
+	// every service claim of the last complete view, any reclaim policy
+	serviceClaims []claimSpec
+	// live claims of plugin and CSI volumes
+	holders claimHolders
+	// claims seen in the last reconcile and ro bind mounts
+	claims claimTable
+	// VOLS3_ENDPOINTS probe results and selection
+	endpointMu       sync.Mutex
+	endpoints        []EndpointStatus
+	selectedEndpoint string
+	// active default credentials and rotation history
+	creds credState
+	// temporary credentials from STSEndpoint
+	sts stsState
+	// Swarm node ID of the local daemon
+	node nodeState
+	// on-demand shared mounter (LazyMount)
+	lazy lazyState
+	// service versions planned by automatic service wiring
+	wiring wiringState
+	// service status label writes (event loop guard)
+	svcStatus serviceStatusState
+	// published volume-s3.ready node label
+	ready nodeReadyState
+	// last mount probe per path
+	probes probeState
+	// mounters being drained and recent drain results
+	drain drainState
+	// old mounters kept after a staged upgrade while still in use
+	retired retiredState
+	// rclone rc stats per running mounter
+	rcMu    sync.Mutex
+	rcStats []RcloneStats
+	// generated HAProxy config and child process
+	haproxy haproxyManager
+	// storage class catalog
+	classMu    sync.RWMutex
+	classes    map[string]StorageClass
+	classesMod time.Time
+	classErr   string // last catalog load error
 }
 
 func New(ctx context.Context, cfg Config) (*Controller, error) {
@@ -102,13 +225,28 @@ func New(ctx context.Context, cfg Config) (*Controller, error) {
 			slog.Warn("manager docker host client init failed", "host", cfg.ManagerDockerHost, "error", err)
 		}
 	}
-	return &Controller{ctx: ctx, cli: cli, managerCli: mcli, cfg: cfg, eventCh: make(chan struct{}, 1)}, nil
+	c := &Controller{ctx: ctx, cli: cli, managerCli: mcli, cfg: cfg, eventCh: make(chan struct{}, 1)}
+	c.reloadClassCatalog()
+	return c, nil
 }
 
 func (c *Controller) Run() {
 	ticker := time.NewTicker(c.cfg.PollInterval)
 	defer ticker.Stop()
 	go c.watchDockerEvents()
+	go c.runCredentialWatch()
+	if c.stsEnabled() {
+		// the first keys are needed before any mounter starts
+		if _, err := c.refreshSTS(); err != nil {
+			slog.Error("sts credentials", "endpoint", c.cfg.STSEndpoint, "error", err)
+		}
+		go c.runSTSRefresh()
+	}
+	if len(c.endpointList()) > 0 {
+		c.checkEndpoints()
+		go c.runEndpointChecks()
+	}
+	c.loadReadOnlyBinds()
 	for {
 		start := time.Now()
 		if err := c.reconcile(); err != nil {
@@ -137,7 +275,11 @@ func (c *Controller) watchDockerEvents() {
 		select {
 		case <-c.ctx.Done():
 			return
-		case <-msgs:
+		case m := <-msgs:
+			// our own status label writes must not trigger another reconcile
+			if c.ownServiceEvent(m) {
+				continue
+			}
 			select {
 			case c.eventCh <- struct{}{}:
 			default:
@@ -158,17 +300,20 @@ func (c *Controller) timeoutCtx(d time.Duration) (context.Context, context.Cance
 }
 
 func (c *Controller) Ready() error {
-	// mountpoint exists
-	if err := os.MkdirAll(c.cfg.Mountpoint, 0o755); err != nil {
-		return err
+	if c.isDraining(c.mounterName()) {
+		return fmt.Errorf("mounter draining")
 	}
-	// in read-only mode, skip write probe
-	if !c.cfg.ReadOnly {
-		test := filepath.Join(c.cfg.Mountpoint, c.cfg.ReadyFile)
-		if err := os.WriteFile(test, []byte(time.Now().Format(time.RFC3339)), 0o644); err != nil {
+	// nothing on this node needs the on-demand mount right now
+	if c.lazyUnmounted() {
+		return nil
+	}
+	if c.perClaimMounters() {
+		// the mountpoint is a plain directory; the claim mounts serve the data
+		if err := c.claimMountsReady(); err != nil {
 			return err
 		}
-		_ = os.Remove(test)
+	} else if err := c.sharedMountReady(); err != nil {
+		return err
 	}
 	// optional strict remote check
 	if c.cfg.StrictReady {
@@ -188,10 +333,33 @@ func (c *Controller) Ready() error {
 	return nil
 }
 
+// sharedMountReady checks that the shared mountpoint exists and, unless
+// read-only, takes a write.
+func (c *Controller) sharedMountReady() error {
+	p := c.probePath(c.cfg.Mountpoint, func() error {
+		if err := os.MkdirAll(c.cfg.Mountpoint, 0o755); err != nil {
+			return err
+		}
+		if c.cfg.ReadOnly {
+			return nil
+		}
+		test := filepath.Join(c.cfg.Mountpoint, c.cfg.ReadyFile)
+		if err := os.WriteFile(test, []byte(time.Now().Format(time.RFC3339)), 0o644); err != nil {
+			return err
+		}
+		return os.Remove(test)
+	})
+	return p.Err()
+}
+
 func (c *Controller) reconcile() error {
 	c.reconcileTotal++
-	// Ensure mountpoint directory exists
-	_ = os.MkdirAll(c.cfg.Mountpoint, 0o755)
+	c.reloadClassCatalog()
+	if err := c.reconcileHAProxy(); err != nil {
+		slog.Error("haproxy", "error", err)
+	}
+	// Ensure mountpoint directory exists (bounded: the mount may be hung)
+	_ = c.probePath(c.cfg.Mountpoint, func() error { return os.MkdirAll(c.cfg.Mountpoint, 0o755) })
 
 	// Try to ensure rshared on host (best-effort)
 	if err := c.ensureRShared(); err != nil {
@@ -210,27 +378,67 @@ func (c *Controller) reconcile() error {
 		}
 	}
 
-	// Ensure mounter container exists
-	if err := c.ensureMounter(); err != nil {
+	// Ensure mounter container(s) exist; an idle on-demand mounter is down
+	var specs []claimSpec
+	mounted := true
+	if c.perClaimMounters() {
+		if err := c.ensureClaimMounters(); err != nil {
+			return err
+		}
+	} else if c.lazyMount() {
+		var err error
+		if specs, err = c.discoverClaims(); err != nil {
+			return err
+		}
+		if mounted, err = c.reconcileLazyMounter(specs); err != nil {
+			return err
+		}
+	} else if err := c.ensureMounter(); err != nil {
 		return err
 	}
 
-	// If mount is stuck, try cleanup (best-effort)
-	if err := c.checkAndHealMount(); err != nil {
-		slog.Warn("heal mount", "error", err)
-	} else {
-		c.healAttemptsTotal++
-		if testRW(c.cfg.Mountpoint) == nil {
-			c.healSuccessTotal++
-			c.lastHealSuccessUnix = time.Now().Unix()
+	// Old mounters of staged upgrades go once nothing uses them
+	if c.stagedUpgrades() {
+		c.reapRetiredMounters()
+	}
+
+	// If mount is stuck, try cleanup (best-effort); without a mounter the
+	// mountpoint is a plain directory with nothing to heal
+	if mounted {
+		if err := c.checkAndHealMount(); err != nil {
+			slog.Warn("heal mount", "error", err)
+		} else {
+			c.healAttemptsTotal++
+			if c.probeMount(c.cfg.Mountpoint, !c.cfg.ReadOnly).Result == ProbeOK {
+				c.healSuccessTotal++
+				c.lastHealSuccessUnix = time.Now().Unix()
+			}
 		}
 	}
 
+	c.collectRcloneStats()
+
 	// Declarative claim provisioning: create requested prefixes under mountpoint
-	if err := c.provisionClaims(); err != nil {
-		slog.Warn("provision claims", "error", err)
+	// (per-claim mounters already mount each prefix on its own)
+	if !c.perClaimMounters() && mounted {
+		if err := c.provisionClaims(specs); err != nil {
+			slog.Warn("provision claims", "error", err)
+		}
 	}
 
+	// Trash/purge data of released reclaim=Delete claims (one node only);
+	// while the on-demand mounter is down the prefixes would all look gone
+	if mounted && c.reclaimOwner() {
+		c.processReclaims()
+	}
+
+	// Add claim binds and placement to labelled services (manager only)
+	c.wireServices()
+	// Report claim state on the services (manager API)
+	c.writeServiceStatus()
+	// Publish mount readiness as node label (manager API)
+	c.publishNodeReady()
+
 	// Emit status to logs
 	c.logStatus()
 	// Cleanup orphaned rclone containers (best-effort)
@@ -265,18 +473,48 @@ func (c *Controller) ensureImagePresent(img string) error {
 	return nil
 }
 
+// mounterSpec describes one rclone mounter container: which remote it mounts,
+// where, and with which extra flags.
+type mounterSpec struct {
+	name       string
+	remote     string
+	mountpoint string
+	readOnly   bool
+	extraArgs  []string
+	labels     map[string]string
+	// credentials, when set, replaces the default remote env with that set's remote
+	credentials string
+}
+
+// sharedMounterSpec is the node-wide mounter for cfg.RcloneRemote at cfg.Mountpoint.
+func (c *Controller) sharedMounterSpec() mounterSpec {
+	return mounterSpec{
+		name:       c.mounterName(),
+		remote:     c.cfg.RcloneRemote,
+		mountpoint: c.cfg.Mountpoint,
+		readOnly:   c.cfg.ReadOnly,
+		extraArgs:  parseArgs(c.cfg.RcloneExtraArgs),
+	}
+}
+
 func (c *Controller) ensureMounter() error {
-	name := c.mounterName()
+	return c.ensureMounterSpec(c.sharedMounterSpec())
+}
+
+func (c *Controller) ensureMounterSpec(ms mounterSpec) error {
+	c.mounterMu.Lock()
+	defer c.mounterMu.Unlock()
+	name := ms.name
 	// find by name
 	args := filters.NewArgs()
-	args.Add("name", name)
+	args.Add("name", "^/"+name+"$")
 	ctx, cancel := c.timeoutCtx(10 * time.Second)
 	defer cancel()
 	conts, err := c.cli.ContainerList(ctx, container.ListOptions{All: true, Filters: args})
 	if err != nil {
 		return err
 	}
-	// If exists and image changed (after pull) or endpoint drifted, recreate
+	// If exists and image changed (after pull) or the spec drifted, recreate
 	desiredImageID := c.cachedImageID()
 	if len(conts) > 0 {
 		id := conts[0].ID
@@ -285,27 +523,48 @@ func (c *Controller) ensureMounter() error {
 		icancel()
 		if err == nil {
 			if desiredImageID != "" && inspect.Image != desiredImageID {
+				if inspect.State != nil && inspect.State.Running {
+					if c.stagedUpgrades() {
+						err := c.stagedUpgrade(ms, inspect, "image changed")
+						if err == nil {
+							return nil
+						}
+						slog.Warn("staged upgrade failed, recreating", "mounter", name, "error", err)
+					}
+					c.drainMounter(c.ctx, id, name, ms.labels[claimMounterLabel], "image changed")
+				}
 				rctx, rcancel := c.timeoutCtx(10 * time.Second)
 				_ = c.cli.ContainerRemove(rctx, id, container.RemoveOptions{Force: true})
 				rcancel()
 			} else if inspect.State != nil && inspect.State.Running {
-				// Endpoint drift detection
-				desired := strings.TrimSpace(c.resolveEndpointForMounter())
+				// Spec drift detection (args, env incl. endpoint and keys, binds, ...)
+				desired, view, err := c.mounterSpecHash(ms)
+				if err != nil {
+					return err
+				}
 				current := ""
 				if inspect.Config != nil {
-					for _, e := range inspect.Config.Env {
-						if strings.HasPrefix(e, "RCLONE_CONFIG_S3_ENDPOINT=") {
-							current = strings.TrimPrefix(e, "RCLONE_CONFIG_S3_ENDPOINT=")
-							break
+					current = inspect.Config.Labels[mounterSpecHashLabel]
+				}
+				if current != desired {
+					changes := diffSpecViews(runningSpecView(inspect, view), view)
+					if current == "" {
+						changes = append(changes, "spec-hash label missing")
+					}
+					slog.Warn("mounter spec changed", "mounter", name, "from", current, "to", desired, "changes", changes)
+					if c.stagedUpgrades() {
+						err := c.stagedUpgrade(ms, inspect, "spec changed")
+						if err == nil {
+							return nil
 						}
+						slog.Warn("staged upgrade failed, recreating", "mounter", name, "error", err)
 					}
-				}
-				if desired != "" && current != "" && !strings.EqualFold(desired, current) {
+					c.drainMounter(c.ctx, id, name, ms.labels[claimMounterLabel], "spec changed")
 					rctx, rcancel := c.timeoutCtx(10 * time.Second)
 					_ = c.cli.ContainerRemove(rctx, id, container.RemoveOptions{Force: true})
 					rcancel()
 				} else {
-					return nil
+					return c.restoreStagedBind(ms, inspect)
 				}
 			} else {
 				sctx, scancel := c.timeoutCtx(10 * time.Second)
@@ -321,18 +580,31 @@ func (c *Controller) ensureMounter() error {
 		}
 	}
 
+	// ro binds of the shared mount would pin the old FUSE connection
+	if ms.name == c.mounterName() {
+		c.releaseReadOnlyBinds(nil)
+	}
 	// Before creating a fresh mounter, ensure no stale mount remains
-	if err := c.unmountIfMounted(); err != nil {
-		slog.Warn("pre-create unmount failed", "error", err)
+	if err := c.unmountIfMounted(ms.mountpoint); err != nil {
+		slog.Warn("pre-create unmount failed", "path", ms.mountpoint, "error", err)
 	}
+	_ = os.MkdirAll(ms.mountpoint, 0o755)
+	return c.createMounter(ms)
+}
 
-	// read secrets
-	_, _ = os.ReadFile(c.cfg.AccessKeyFile)
-	_, _ = os.ReadFile(c.cfg.SecretKeyFile)
-
+// mounterContainer builds the container definition of the mounter for ms.
+func (c *Controller) mounterContainer(ms mounterSpec) (*container.Config, *container.HostConfig, *network.NetworkingConfig, error) {
 	env := c.buildRcloneEnv()
+	if ms.credentials != "" {
+		// only the claim's own remote; the default key is not handed out
+		ce, err := c.credentialEnv(ms.credentials)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		env = ce
+	}
 
-	cmd := []string{"mount", c.cfg.RcloneRemote, c.cfg.Mountpoint}
+	cmd := []string{"mount", ms.remote, ms.mountpoint}
 	// access model
 	if c.cfg.AllowOther {
 		cmd = append(cmd, "--allow-other")
@@ -343,12 +615,11 @@ func (c *Controller) ensureMounter() error {
 	cmd = append(cmd, "--vfs-cache-mode=writes", "--dir-cache-time=12h", "--allow-non-empty")
 	// presets first
 	cmd = append(cmd, c.buildPresetArgs()...)
-	if c.cfg.ReadOnly {
+	cmd = append(cmd, c.rcArgs()...)
+	if ms.readOnly {
 		cmd = append(cmd, "--read-only")
 	}
-	if strings.TrimSpace(c.cfg.RcloneExtraArgs) != "" {
-		cmd = append(cmd, parseArgs(c.cfg.RcloneExtraArgs)...)
-	}
+	cmd = append(cmd, ms.extraArgs...)
 
 	// Networking: attach to overlay network when provided (for controller overlay IP access)
 	var netCfg *network.NetworkingConfig
@@ -360,41 +631,66 @@ func (c *Controller) ensureMounter() error {
 		netCfg = &network.NetworkingConfig{}
 	}
 
+	labels := map[string]string{
+		"swarmnative.mounter":      "managed",
+		"swarmnative.mounter.node": sanitizeHostname(),
+		"swarmnative.mounter.path": ms.mountpoint,
+	}
+	for k, v := range ms.labels {
+		labels[k] = v
+	}
+	cfg := &container.Config{
+		Image:  c.cfg.MounterImage,
+		Env:    env,
+		Cmd:    cmd,
+		Labels: labels,
+	}
+	hostCfg := &container.HostConfig{
+		Privileged:  false,
+		CapAdd:      []string{"SYS_ADMIN"},
+		NetworkMode: c.selfNetworkMode(),
+		RestartPolicy: container.RestartPolicy{
+			Name: "always",
+		},
+		Binds: []string{
+			"/dev/fuse:/dev/fuse",
+			fmt.Sprintf("%s:%s:rshared", ms.mountpoint, ms.mountpoint),
+		},
+		SecurityOpt: []string{"apparmor=unconfined", "seccomp=unconfined"},
+		Resources: container.Resources{
+			Devices: []container.DeviceMapping{{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "mrw"}},
+		},
+	}
+	if c.stsEnabled() && ms.credentials == "" {
+		hostCfg.Binds = append(hostCfg.Binds, fmt.Sprintf("%s:%s:ro", c.cfg.STSCredentialsDir, c.cfg.STSCredentialsDir))
+	}
+	return cfg, hostCfg, netCfg, nil
+}
+
+// createMounter creates and starts the mounter container for ms.
+func (c *Controller) createMounter(ms mounterSpec) error {
+	cfg, hostCfg, netCfg, err := c.mounterContainer(ms)
+	if err != nil {
+		return err
+	}
+	// staged mounters are hashed as the live spec they stand in for
+	logical := ms
+	if p := ms.labels["swarmnative.mounter.path"]; p != "" {
+		logical.mountpoint = p
+	}
+	hash, _, err := c.mounterSpecHash(logical)
+	if err != nil {
+		return err
+	}
+	cfg.Labels[mounterSpecHashLabel] = hash
+
 	// ensure mounter image exists
 	if err := c.ensureImagePresent(c.cfg.MounterImage); err != nil {
 		return fmt.Errorf("ensure mounter image: %w", err)
 	}
 
 	cctx, ccancel := c.timeoutCtx(20 * time.Second)
-	resp, err := c.cli.ContainerCreate(cctx,
-		&container.Config{
-			Image: c.cfg.MounterImage,
-			Env:   env,
-			Cmd:   cmd,
-			Labels: map[string]string{
-				"swarmnative.mounter": "managed",
-			},
-		},
-		&container.HostConfig{
-			Privileged:  false,
-			CapAdd:      []string{"SYS_ADMIN"},
-			NetworkMode: c.selfNetworkMode(),
-			RestartPolicy: container.RestartPolicy{
-				Name: "always",
-			},
-			Binds: []string{
-				"/dev/fuse:/dev/fuse",
-				fmt.Sprintf("%s:%s:rshared", c.cfg.Mountpoint, c.cfg.Mountpoint),
-			},
-			SecurityOpt: []string{"apparmor=unconfined", "seccomp=unconfined"},
-			Resources: container.Resources{
-				Devices: []container.DeviceMapping{{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "mrw"}},
-			},
-		},
-		netCfg,
-		nil,
-		name,
-	)
+	resp, err := c.cli.ContainerCreate(cctx, cfg, hostCfg, netCfg, nil, ms.name)
 	ccancel()
 	if err != nil {
 		return fmt.Errorf("create mounter: %w", err)
@@ -405,45 +701,31 @@ func (c *Controller) ensureMounter() error {
 		return fmt.Errorf("start mounter: %w", err)
 	}
 	scancel2()
-	c.mounterCreatedTotal++
+	c.image.mu.Lock()
+	c.image.created++
+	c.image.mu.Unlock()
 	return nil
 }
 
 // isMounted checks whether a path is currently a mountpoint (best-effort by reading /proc/self/mountinfo)
 func isMounted(path string) bool {
-	b, err := os.ReadFile("/proc/self/mountinfo")
-	if err != nil { return false }
-	lines := strings.Split(string(b), "\n")
-	for _, ln := range lines {
-		if ln == "" { continue }
-		fields := strings.Fields(ln)
-		if len(fields) >= 5 {
-			if fields[4] == path { return true }
-		}
-	}
-	return false
+	_, ok := lookupMount(path)
+	return ok
 }
 
-// unmountIfMounted lazily unmounts the configured mountpoint when it is currently mounted.
-func (c *Controller) unmountIfMounted() error {
-	if !isMounted(c.cfg.Mountpoint) {
+// lazyUnmountSh lazily unmounts the path given as $1 in the host namespace.
+const lazyUnmountSh = `(nsenter -t 1 -m -- fusermount -uz "$1" || true); (nsenter -t 1 -m -- umount -l "$1" || true)`
+
+// unmountIfMounted lazily unmounts the given mountpoint when it is currently mounted.
+func (c *Controller) unmountIfMounted(path string) error {
+	if !isMounted(path) {
 		return nil
 	}
-	sh := fmt.Sprintf("(nsenter -t 1 -m -- fusermount -uz %[1]s || true); (nsenter -t 1 -m -- umount -l %[1]s || true)", c.cfg.Mountpoint)
-	if err := c.ensureImagePresent(c.helperImageRef()); err != nil { return err }
-	cont, err := c.cli.ContainerCreate(c.ctx,
-		&container.Config{Image: c.helperImageRef(), Cmd: []string{"sh", "-c", sh}},
-		&container.HostConfig{Privileged: true, PidMode: "host", Binds: []string{fmt.Sprintf("%s:%s", c.cfg.Mountpoint, c.cfg.Mountpoint)}},
-		&network.NetworkingConfig{}, nil, c.helperName("preunmount"))
-	if err != nil { return err }
-	defer func() { _ = c.cli.ContainerRemove(c.ctx, cont.ID, container.RemoveOptions{Force: true}) }()
-	_ = c.cli.ContainerStart(c.ctx, cont.ID, container.StartOptions{})
-	time.Sleep(1 * time.Second)
-	return nil
+	return c.runHostHelper("preunmount", "sh", "-c", lazyUnmountSh, "sh", path)
 }
 
 func (c *Controller) pullMounterImageIfDue() error {
-	if time.Since(c.lastImagePull) < c.cfg.MounterPullInterval {
+	if time.Since(c.lastImagePull()) < c.cfg.MounterPullInterval {
 		return nil
 	}
 	ictx, icancel := c.timeoutCtx(60 * time.Second)
@@ -454,9 +736,9 @@ func (c *Controller) pullMounterImageIfDue() error {
 	}
 	defer rc.Close()
 	_, _ = io.Copy(io.Discard, rc)
-	c.lastImagePull = time.Now()
+	c.notePull("")
 	if ii, _, err := c.cli.ImageInspectWithRaw(ictx, c.cfg.MounterImage); err == nil {
-		c.lastImageID = ii.ID
+		c.notePull(ii.ID)
 	}
 	icancel()
 	return nil
@@ -474,7 +756,7 @@ func (c *Controller) pullMounterImageIfChanged() error {
 	}
 	defer rc.Close()
 	_, _ = io.Copy(io.Discard, rc)
-	c.lastImagePull = time.Now()
+	c.notePull("")
 	// Inspect new id
 	if ii, _, err := c.cli.ImageInspectWithRaw(ipctx, c.cfg.MounterImage); err == nil {
 		if current != "" && ii.ID == current {
@@ -482,23 +764,61 @@ func (c *Controller) pullMounterImageIfChanged() error {
 			ipcancel()
 			return nil
 		}
-		c.lastImageID = ii.ID
+		c.notePull(ii.ID)
 	}
 	ipcancel()
 	return nil
 }
 
 func (c *Controller) cachedImageID() string {
-	if c.lastImageID != "" {
-		return c.lastImageID
+	c.image.mu.Lock()
+	id := c.image.id
+	c.image.mu.Unlock()
+	if id != "" {
+		return id
 	}
 	if ii, _, err := c.cli.ImageInspectWithRaw(c.ctx, c.cfg.MounterImage); err == nil {
-		c.lastImageID = ii.ID
-		return c.lastImageID
+		c.image.mu.Lock()
+		defer c.image.mu.Unlock()
+		if c.image.id == "" {
+			c.image.id = ii.ID
+		}
+		return c.image.id
 	}
 	return ""
 }
 
+// imageState is the mounter image last pulled and the mounters created from
+// it; ensureMounter runs from the reconcile loop and the CSI/plugin servers.
+type imageState struct {
+	mu      sync.Mutex
+	pulled  time.Time
+	id      string
+	created int64
+}
+
+// notePull records a finished pull and, when known, the pulled image ID.
+func (c *Controller) notePull(id string) {
+	c.image.mu.Lock()
+	defer c.image.mu.Unlock()
+	c.image.pulled = time.Now()
+	if id != "" {
+		c.image.id = id
+	}
+}
+
+func (c *Controller) lastImagePull() time.Time {
+	c.image.mu.Lock()
+	defer c.image.mu.Unlock()
+	return c.image.pulled
+}
+
+func (c *Controller) mounterCreatedTotal() int64 {
+	c.image.mu.Lock()
+	defer c.image.mu.Unlock()
+	return c.image.created
+}
+
 func (c *Controller) ensureRShared() error {
 	// Use host namespace via nsenter available in main image (util-linux preinstalled)
 	sh := fmt.Sprintf("nsenter -t 1 -m -- mount --make-rshared %s || mount --make-rshared %s", c.cfg.Mountpoint, c.cfg.Mountpoint)
@@ -520,26 +840,55 @@ func (c *Controller) ensureRShared() error {
 }
 
 func (c *Controller) checkAndHealMount() error {
-	// If mountpoint exists but not usable, try lazy unmount via helper
-	if err := testRW(c.cfg.Mountpoint); err == nil {
-		return nil
-	}
-	sh := fmt.Sprintf("(nsenter -t 1 -m -- fusermount -uz %[1]s || true); (nsenter -t 1 -m -- umount -l %[1]s || true)", c.cfg.Mountpoint)
-	// ensure helper image exists
-	if err := c.ensureImagePresent(c.helperImageRef()); err != nil {
+	if !c.perClaimMounters() {
+		_, err := c.healMount(c.cfg.Mountpoint, !c.cfg.ReadOnly)
 		return err
 	}
-	cont, err := c.cli.ContainerCreate(c.ctx,
-		&container.Config{Image: c.helperImageRef(), Cmd: []string{"sh", "-c", sh}},
-		&container.HostConfig{Privileged: true, PidMode: "host", Binds: []string{fmt.Sprintf("%s:%s", c.cfg.Mountpoint, c.cfg.Mountpoint)}},
-		&network.NetworkingConfig{}, nil, c.helperName("umount-helper"))
+	// every claim mounter has a FUSE connection of its own; the mountpoint
+	// itself is a plain directory
+	mounts, err := c.claimMounts()
 	if err != nil {
 		return err
 	}
-	defer func() { _ = c.cli.ContainerRemove(c.ctx, cont.ID, container.RemoveOptions{Force: true}) }()
-	_ = c.cli.ContainerStart(c.ctx, cont.ID, container.StartOptions{})
-	time.Sleep(1 * time.Second)
-	return nil
+	var firstErr error
+	for _, m := range mounts {
+		p, err := c.healMount(m.path, m.write)
+		if p.Result != ProbeOK {
+			c.markClaimError(m.claim, fmt.Errorf("mount %s: %s", p.Result, p.Error))
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", m.path, err)
+		}
+	}
+	return firstErr
+}
+
+// healMount probes path and, when the mount is dead or hung, aborts its FUSE
+// connection and lazily unmounts it. It returns the probe that decided.
+func (c *Controller) healMount(path string, write bool) (MountProbe, error) {
+	// If mountpoint exists but not usable, try lazy unmount via helper
+	p := c.probeMount(path, write)
+	switch p.Result {
+	case ProbeOK:
+		return p, nil
+	case ProbeReadOnly:
+		// the mount works, it just refuses writes
+		return p, p.Err()
+	}
+	// the helper binds nothing: a bind of the hung mountpoint would block its
+	// start; the path is passed as an argument
+	sh := lazyUnmountSh
+	// a hung connection keeps the unmount (and anyone touching it) blocked;
+	// abort it first
+	connID := ""
+	if me, ok := lookupMount(path); ok && p.hung() {
+		connID = fuseConnID(me)
+	}
+	if connID != "" {
+		sh = fuseAbortSh(connID) + sh
+	}
+	slog.Warn("healing mount", "path", path, "probe", p.Result, "error", p.Error, "fuse_conn", connID)
+	return p, c.runHostHelper("umount-helper", "sh", "-c", sh, "sh", path)
 }
 
 func parseArgs(s string) []string {
@@ -564,19 +913,26 @@ func (c *Controller) logStatus() {
 	// container state
 	name := c.mounterName()
 	args := filters.NewArgs()
-	args.Add("name", name)
+	args.Add("name", "^/"+name+"$")
 	conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{All: true, Filters: args})
 	running := false
-	if err == nil && len(conts) > 0 {
+	if c.perClaimMounters() {
+		running = c.claimMountersRunning()
+	} else if err == nil && len(conts) > 0 {
 		id := conts[0].ID
 		if inspect, err := c.cli.ContainerInspect(c.ctx, id); err == nil && inspect.State != nil {
 			running = inspect.State.Running
 		}
 	}
-	mountOK := testRW(c.cfg.Mountpoint) == nil
+	var mountOK bool
+	if c.perClaimMounters() {
+		mountOK = c.claimMountsReady() == nil
+	} else {
+		mountOK = !c.lazyUnmounted() && c.probeMount(c.cfg.Mountpoint, true).Result == ProbeOK
+	}
 	c.lastMounterRunning = running
 	c.lastMountWritable = mountOK
-	slog.Info("status", "mounter_running", running, "mount_writable", mountOK, "last_image_pull", c.lastImagePull.Format(time.RFC3339))
+	slog.Info("status", "mounter_running", running, "mount_writable", mountOK, "last_image_pull", c.lastImagePull().Format(time.RFC3339))
 }
 
 // --- Declarative volume (prefix) provisioning via service/container labels ---
@@ -598,6 +954,8 @@ func (c *Controller) parseLabels(labels map[string]string) map[string]string {
 		"volume-s3.reclaim": {},
 		"volume-s3.access":  {},
 		"volume-s3.args":    {},
+		"volume-s3.credentials": {},
+		"volume-s3.target":  {},
 	}
 	values := map[string]struct {
 		v       string
@@ -612,6 +970,10 @@ func (c *Controller) parseLabels(labels map[string]string) map[string]string {
 			prefix = k[:i]
 			base = k[i+1:]
 		}
+		// status labels are written by the controller itself
+		if isStatusLabel(base) {
+			continue
+		}
 		if _, ok := allowed[base]; !ok {
 			if c.cfg.LabelStrict {
 				slog.Error("unknown label key", "key", k)
@@ -669,30 +1031,144 @@ type claimSpec struct {
 	class   string
 	reclaim string // Retain|Delete
 	access  string // rw|ro
-	args    string // extra rclone args suggestion (not enforced per-service)
+	args    string // extra rclone args (applied only by per-claim mounters)
+	source  string // container:<name> | service:<name>
+	err     error  // set when the claim is rejected (e.g. unknown class)
+	// credentials names a secret set with its own rclone remote (per-claim mounters only)
+	credentials string
 }
 
-func (c *Controller) provisionClaims() error {
-	// ensure mount is writable first
-	if err := testRW(c.cfg.Mountpoint); err != nil {
-		return err
+// claimFromLabels maps canonical volume-s3.* keys (as returned by parseLabels)
+// onto a claimSpec.
+func claimFromLabels(m map[string]string) claimSpec {
+	var cs claimSpec
+	if v, ok := m["volume-s3.enabled"]; ok {
+		cs.enabled = strings.EqualFold(v, "true")
+	}
+	cs.bucket = m["volume-s3.bucket"]
+	cs.prefix = strings.Trim(m["volume-s3.prefix"], "/")
+	cs.class = m["volume-s3.class"]
+	cs.reclaim = m["volume-s3.reclaim"]
+	cs.access = m["volume-s3.access"]
+	cs.args = m["volume-s3.args"]
+	cs.credentials = strings.TrimSpace(m["volume-s3.credentials"])
+	cs.err = checkClaimPrefix(cs.prefix)
+	if cs.err == nil {
+		cs.err = checkClaimBucket(cs.bucket)
+	}
+	return cs
+}
+
+var claimPrefixRe = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+
+// checkClaimPrefix only admits prefixes made of letters, digits, '.', '_', '-'
+// and '/' separated segments: prefixes end up in host paths and mount helper
+// arguments. "." and ".." segments and the controller's own directories under
+// the mount are rejected.
+func checkClaimPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
 	}
+	if !claimPrefixRe.MatchString(prefix) {
+		return fmt.Errorf("prefix %q: only letters, digits, '.', '_', '-' and '/' are allowed", prefix)
+	}
+	for i, seg := range strings.Split(prefix, "/") {
+		if seg == "." || seg == ".." {
+			return fmt.Errorf("prefix %q: %q segments are not allowed", prefix, seg)
+		}
+		if i == 0 && (seg == trashDir || seg == csiVolumesDir) {
+			return fmt.Errorf("prefix %q: %s is reserved", prefix, seg)
+		}
+	}
+	return nil
+}
+
+// checkClaimBucket applies the prefix charset to bucket names, which are part
+// of per-claim mount paths.
+func checkClaimBucket(bucket string) error {
+	bucket = strings.Trim(bucket, "/")
+	if bucket == "" {
+		return nil
+	}
+	if !claimPrefixRe.MatchString(bucket) {
+		return fmt.Errorf("bucket %q: only letters, digits, '.', '_', '-' and '/' are allowed", bucket)
+	}
+	for _, seg := range strings.Split(bucket, "/") {
+		if seg == "." || seg == ".." {
+			return fmt.Errorf("bucket %q: %q segments are not allowed", bucket, seg)
+		}
+	}
+	return nil
+}
+
+// discoverClaims collects claims from running containers and, when enabled,
+// from Swarm service labels.
+func (c *Controller) discoverClaims() ([]claimSpec, error) {
 	conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{All: false})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	specs := c.collectClaimSpecs(conts)
+	specs := c.resolveClaims(c.collectClaimSpecs(conts))
 
 	// Prefer service-defined claims as well
 	if c.cfg.ReadServiceLabels {
-		if svSpecs, err := c.collectServiceClaimSpecs(); err != nil {
+		if svSpecs, scoped, err := c.collectServiceClaimSpecs(); err != nil {
 			slog.Warn("collect service claims", "error", err)
-		} else if len(svSpecs) > 0 {
+		} else {
+			// reclaim tracks resolved claims: the class may set the bucket.
+			// Only a complete service view may release claims for reclaim
+			svSpecs = c.resolveClaims(svSpecs)
+			if !scoped {
+				c.observeServiceClaims(svSpecs)
+			} else if c.cfg.ReclaimEnabled {
+				if all, err := c.serviceClaimSpecs(types.ServiceListOptions{}); err != nil {
+					slog.Warn("collect service claims for reclaim", "error", err)
+				} else {
+					c.observeServiceClaims(c.resolveClaims(all))
+				}
+			}
 			specs = append(specs, svSpecs...)
 		}
 	}
 	for _, s := range specs {
-		if !s.enabled || s.prefix == "" {
+		if s.err != nil {
+			slog.Warn("claim rejected", "source", s.source, "class", s.class, "error", s.err)
+		}
+	}
+	return specs, nil
+}
+
+// resolveClaims applies storage class defaults; claims naming unknown classes
+// or unusable credentials are rejected and carry the error.
+func (c *Controller) resolveClaims(specs []claimSpec) []claimSpec {
+	for i := range specs {
+		if rs, err := c.resolveClaim(specs[i]); err != nil {
+			specs[i].err = err
+		} else {
+			specs[i] = rs
+		}
+	}
+	return specs
+}
+
+// provisionClaims creates the claim directories under the shared mount. specs
+// are the claims already discovered in this reconcile; nil discovers them.
+func (c *Controller) provisionClaims(specs []claimSpec) error {
+	if specs == nil {
+		var err error
+		if specs, err = c.discoverClaims(); err != nil {
+			return err
+		}
+	}
+	// ensure mount is writable first; until then the claims are pending
+	if err := c.probeMount(c.cfg.Mountpoint, true).Err(); err != nil {
+		c.recordPendingClaims(specs, fmt.Errorf("mount not writable: %w", err))
+		return err
+	}
+	errs := map[string]error{}
+	activeRO := map[string]struct{}{}
+	for _, s := range specs {
+		if !s.enabled || s.prefix == "" || s.err != nil {
 			continue
 		}
 		// Ensure remote bucket/prefix exists if configured
@@ -700,42 +1176,58 @@ func (c *Controller) provisionClaims() error {
 			slog.Warn("claim ensure remote", "bucket", s.bucket, "prefix", s.prefix, "error", err)
 		}
 		// Create prefix directory under mount (idempotent)
-		p := filepath.Join(c.cfg.Mountpoint, filepath.Clean("/"+s.prefix))
+		p := c.claimPath(s)
 		if err := os.MkdirAll(p, 0o755); err != nil {
 			slog.Warn("claim mkdir", "path", p, "error", err)
+			errs[claimKey(s)] = err
+			continue
+		}
+		// Read-only claims are served from a ro bind of the prefix
+		if isReadOnlyClaim(s) {
+			activeRO[claimKey(s)] = struct{}{}
+			if err := c.ensureReadOnlyBind(s); err != nil {
+				slog.Warn("claim ro bind", "path", c.claimEffectivePath(s), "error", err)
+				errs[claimKey(s)] = err
+			}
 		}
 	}
+	c.releaseReadOnlyBinds(activeRO)
+	c.recordClaims(specs, errs)
 	return nil
 }
 
+// claimPath returns the local path of a claim under the mountpoint. Per-claim
+// mounters mount any bucket, so their paths carry it (<bucket>/<prefix>, the
+// default bucket filled in); the shared mount only serves its own bucket.
+func (c *Controller) claimPath(s claimSpec) string {
+	if c.perClaimMounters() {
+		return filepath.Join(c.cfg.Mountpoint, filepath.Clean("/"+c.claimID(s)))
+	}
+	return filepath.Join(c.cfg.Mountpoint, filepath.Clean("/"+s.prefix))
+}
+
+// reclaimClaim applies the claim's reclaim policy once it is released:
+// Retain (default) keeps the data, Delete moves the prefix to the trash where
+// it is purged after the reclaim retention.
+func (c *Controller) reclaimClaim(s claimSpec) error {
+	if !isDeleteClaim(s) || strings.Trim(s.prefix, "/") == "" {
+		return nil
+	}
+	_, err := c.trashClaim(s, time.Now())
+	return err
+}
+
 func (c *Controller) collectClaimSpecs(conts []types.Container) []claimSpec {
 	var out []claimSpec
 	for _, ct := range conts {
 		if len(ct.Labels) == 0 {
 			continue
 		}
-		var cs claimSpec
-		m := c.parseLabels(ct.Labels)
-		if v, ok := m["s3.enabled"]; ok {
-			cs.enabled = strings.EqualFold(v, "true")
-		}
-		if v, ok := m["s3.bucket"]; ok {
-			cs.bucket = v
-		}
-		if v, ok := m["s3.prefix"]; ok {
-			cs.prefix = strings.Trim(v, "/")
-		}
-		if v, ok := m["s3.class"]; ok {
-			cs.class = v
-		}
-		if v, ok := m["s3.reclaim"]; ok {
-			cs.reclaim = v
-		}
-		if v, ok := m["s3.access"]; ok {
-			cs.access = v
-		}
-		if v, ok := m["s3.args"]; ok {
-			cs.args = v
+		cs := claimFromLabels(c.parseLabels(ct.Labels))
+		if len(ct.Names) > 0 {
+			cs.source = "container:" + strings.TrimPrefix(ct.Names[0], "/")
+		} else {
+			cs.source = "container:" + ct.ID
 		}
 		if cs.enabled {
 			out = append(out, cs)
@@ -744,16 +1236,26 @@ func (c *Controller) collectClaimSpecs(conts []types.Container) []claimSpec {
 	return out
 }
 
-// collectServiceClaimSpecs builds claim specs from Swarm Service labels (preferred),
-// and optionally infers prefixes from ServiceSpec.Mounts when enabled and no explicit prefix.
-func (c *Controller) collectServiceClaimSpecs() ([]claimSpec, error) {
+// collectServiceClaimSpecs returns the claims of services with tasks on this
+// node, or of all services when discovery is not node-scoped.
+func (c *Controller) collectServiceClaimSpecs() (specs []claimSpec, scoped bool, err error) {
+	f, scoped, err := c.localServiceFilter()
+	if err != nil {
+		return nil, scoped, err
+	}
+	if scoped && f.Len() == 0 {
+		return nil, true, nil
+	}
+	specs, err = c.serviceClaimSpecs(types.ServiceListOptions{Filters: f})
+	return specs, scoped, err
+}
+
+// serviceClaimSpecs builds claim specs from the labels of the Swarm services
+// matching opts, and optionally infers prefixes from ServiceSpec.Mounts when
+// enabled and no explicit prefix.
+func (c *Controller) serviceClaimSpecs(opts types.ServiceListOptions) ([]claimSpec, error) {
     var out []claimSpec
-    // Prefer manager client if configured
-    cliRef := c.cli
-    if c.managerCli != nil {
-        cliRef = c.managerCli
-    }
-    svcs, err := cliRef.ServiceList(c.ctx, types.ServiceListOptions{})
+    svcs, err := c.swarmCli().ServiceList(c.ctx, opts)
     if err != nil {
         return nil, err
     }
@@ -767,17 +1269,8 @@ func (c *Controller) collectServiceClaimSpecs() ([]claimSpec, error) {
         }
     }
     for _, svc := range svcs {
-        m := c.parseLabels(svc.Spec.Labels)
-        var cs claimSpec
-        if v, ok := m["s3.enabled"]; ok {
-            cs.enabled = strings.EqualFold(v, "true")
-        }
-        if v, ok := m["s3.bucket"]; ok { cs.bucket = v }
-        if v, ok := m["s3.prefix"]; ok { cs.prefix = strings.Trim(v, "/") }
-        if v, ok := m["s3.class"]; ok { cs.class = v }
-        if v, ok := m["s3.reclaim"]; ok { cs.reclaim = v }
-        if v, ok := m["s3.access"]; ok { cs.access = v }
-        if v, ok := m["s3.args"]; ok { cs.args = v }
+        cs := claimFromLabels(c.parseLabels(svc.Spec.Labels))
+        cs.source = "service:" + svc.Spec.Name
 
         // If enabled and no explicit prefix, infer from mounts under our mountpoint
         if cs.enabled && cs.prefix == "" && c.cfg.AutoClaimFromMounts {
@@ -809,67 +1302,107 @@ func (c *Controller) collectServiceClaimSpecs() ([]claimSpec, error) {
 }
 
 func (c *Controller) buildRcloneEnv() []string {
-	// credentials: env overrides file
-	access := strings.TrimSpace(os.Getenv("VOLS3_ACCESS_KEY"))
-	secret := strings.TrimSpace(os.Getenv("VOLS3_SECRET_KEY"))
-	token := strings.TrimSpace(os.Getenv("VOLS3_SESSION_TOKEN"))
-	if access == "" {
-		if b, err := os.ReadFile(c.cfg.AccessKeyFile); err == nil {
-			access = strings.TrimSpace(string(b))
-		}
-	}
-	if secret == "" {
-		if b, err := os.ReadFile(c.cfg.SecretKeyFile); err == nil {
-			secret = strings.TrimSpace(string(b))
-		}
+	if c.stsEnabled() {
+		// keys come from the credential_process file, see sts.go
+		return append(c.remoteEnv(c.defaultRemoteName(), s3.Credentials{}), c.stsEnv()...)
 	}
-	env := []string{
-		"RCLONE_CONFIG_S3_TYPE=s3",
-		fmt.Sprintf("RCLONE_CONFIG_S3_ACCESS_KEY_ID=%s", access),
-		fmt.Sprintf("RCLONE_CONFIG_S3_SECRET_ACCESS_KEY=%s", secret),
-		fmt.Sprintf("RCLONE_CONFIG_S3_ENDPOINT=%s", c.resolveEndpointForMounter()),
-	}
-	if token != "" {
-		env = append(env, fmt.Sprintf("RCLONE_CONFIG_S3_SESSION_TOKEN=%s", token))
-	}
-	if strings.TrimSpace(c.cfg.S3Provider) != "" {
-		env = append(env, fmt.Sprintf("RCLONE_CONFIG_S3_PROVIDER=%s", c.cfg.S3Provider))
-	}
-	return env
+	return c.remoteEnv(c.defaultRemoteName(), c.defaultCredentials())
 }
 
 func (c *Controller) ensureRemotePaths(s claimSpec) error {
 	// Only act when configured
 	if !(c.cfg.AutoCreateBucket || c.cfg.AutoCreatePrefix) {
+		c.noteRemoteMkdir(s, "skipped", nil)
 		return nil
 	}
 	// read-only mode skips remote creation
 	if c.cfg.ReadOnly {
+		c.noteRemoteMkdir(s, "skipped", nil)
 		return nil
 	}
 	// require bucket name for remote operations
 	if strings.TrimSpace(s.bucket) == "" {
+		c.noteRemoteMkdir(s, "skipped", nil)
+		return nil
+	}
+	if !c.s3Backend() {
+		c.ensureRemotePathsRclone(s)
 		return nil
 	}
-	// mkdir bucket
+	cl, err := c.s3ClientFor(s)
+	if err != nil {
+		slog.Warn("claim s3 client", "bucket", s.bucket, "error", err)
+		c.noteRemoteMkdir(s, "failed", err)
+		return nil
+	}
+	ctx, cancel := c.timeoutCtx(30 * time.Second)
+	defer cancel()
+	// the prefix outcome decides; a bucket error alone counts when only buckets are created
+	var mkErr error
 	if c.cfg.AutoCreateBucket {
-		if err := c.runRcloneCmd([]string{"mkdir", fmt.Sprintf("S3:%s", s.bucket)}); err != nil {
-			// ignore errors like already exists
-			slog.Warn("mkdir bucket", "bucket", s.bucket, "error", err)
+		if ok, err := cl.HeadBucket(ctx, s.bucket); err != nil {
+			slog.Warn("head bucket", "bucket", s.bucket, "error", err)
+			mkErr = err
+		} else if !ok {
+			if err := cl.CreateBucket(ctx, s.bucket); err != nil {
+				slog.Warn("mkdir bucket", "bucket", s.bucket, "error", err)
+				mkErr = err
+			} else {
+				slog.Info("bucket created", "bucket", s.bucket)
+			}
 		}
 	}
-	if c.cfg.AutoCreatePrefix && strings.TrimSpace(s.prefix) != "" {
-		remotePath := fmt.Sprintf("S3:%s/%s", s.bucket, strings.Trim(s.prefix, "/"))
-		if err := c.runRcloneCmd([]string{"mkdir", remotePath}); err != nil {
-			slog.Warn("mkdir prefix", "path", remotePath, "error", err)
+	if c.cfg.AutoCreatePrefix && strings.Trim(s.prefix, "/") != "" {
+		mkErr = ensurePrefixMarker(ctx, cl, s.bucket, strings.Trim(s.prefix, "/"))
+		if mkErr != nil {
+			slog.Warn("mkdir prefix", "bucket", s.bucket, "prefix", s.prefix, "error", mkErr)
 		}
 	}
+	if mkErr != nil {
+		c.noteRemoteMkdir(s, "failed", mkErr)
+	} else {
+		c.noteRemoteMkdir(s, "ok", nil)
+	}
 	return nil
 }
 
+// ensureRemotePathsRclone creates claim paths on non-S3 backends with rclone
+// mkdir: the bucket (container) on bucket backends, the claim directory on
+// path backends, which have neither buckets nor object prefixes.
+func (c *Controller) ensureRemotePathsRclone(s claimSpec) {
+	var target string
+	if c.backend().buckets {
+		if c.cfg.AutoCreateBucket {
+			target = c.claimRemoteName(s) + ":" + strings.Trim(s.bucket, "/")
+		}
+	} else if c.cfg.AutoCreatePrefix && strings.Trim(s.prefix, "/") != "" {
+		target = c.claimRemote(s)
+	}
+	if target == "" {
+		c.noteRemoteMkdir(s, "skipped", nil)
+		return
+	}
+	if err := c.runRcloneCmd([]string{"mkdir", target}); err != nil {
+		slog.Warn("rclone mkdir", "target", target, "error", err)
+		c.noteRemoteMkdir(s, "failed", err)
+		return
+	}
+	c.noteRemoteMkdir(s, "ok", nil)
+}
+
 func (c *Controller) runRcloneCmd(cmd []string) error {
+	_, err := c.runRcloneOutput(cmd)
+	return err
+}
+
+// runRcloneOutput runs a one-shot rclone command in a throwaway container and
+// returns its stdout; a non-zero exit code is reported as an error.
+func (c *Controller) runRcloneOutput(cmd []string) (string, error) {
 	name := c.helperName("rclone-run")
-	env := c.buildRcloneEnv()
+	env, err := c.rcloneEnvFor(cmd)
+	if err != nil {
+		return "", err
+	}
 	// Ensure helper can reach the S3 endpoint: attach to overlay network if provided
 	var netCfg *network.NetworkingConfig
 	if strings.TrimSpace(c.cfg.ProxyNetwork) != "" {
@@ -881,25 +1414,37 @@ func (c *Controller) runRcloneCmd(cmd []string) error {
 	}
 	// ensure mounter image exists (used to run rclone cmd)
 	if err := c.ensureImagePresent(c.cfg.MounterImage); err != nil {
-		return err
+		return "", err
 	}
 	cont, err := c.cli.ContainerCreate(c.ctx,
 		&container.Config{Image: c.cfg.MounterImage, Env: env, Cmd: cmd},
 		&container.HostConfig{NetworkMode: c.selfNetworkMode()},
 		netCfg, nil, name)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer func() { _ = c.cli.ContainerRemove(context.Background(), cont.ID, container.RemoveOptions{Force: true}) }()
 	if err := c.cli.ContainerStart(c.ctx, cont.ID, container.StartOptions{}); err != nil {
-		return err
+		return "", err
 	}
 	// wait for completion
-	_, errCh := c.cli.ContainerWait(c.ctx, cont.ID, container.WaitConditionNotRunning)
-	if err := <-errCh; err != nil {
-		return err
+	statusCh, errCh := c.cli.ContainerWait(c.ctx, cont.ID, container.WaitConditionNotRunning)
+	var exitCode int64
+	select {
+	case err := <-errCh:
+		return "", err
+	case st := <-statusCh:
+		exitCode = st.StatusCode
 	}
-	return nil
+	var stdout, stderr strings.Builder
+	if rc, err := c.cli.ContainerLogs(c.ctx, cont.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true}); err == nil {
+		_, _ = stdcopy.StdCopy(&stdout, &stderr, rc)
+		_ = rc.Close()
+	}
+	if exitCode != 0 {
+		return stdout.String(), fmt.Errorf("rclone %s exited %d: %s", cmd[0], exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
 }
 
 // cleanupOrphanedMounters removes exited/created rclone mounter containers that
@@ -941,9 +1486,24 @@ type MetricsSnapshot struct {
 	OrphanCleanupTotal  int64
 	ReconcileDurationMs int64
 	MounterCreatedTotal int64
+	ClaimMounters       int
+	SwarmNodeID         string
+	Reclaims            []ReclaimStatus
+	Claims              []ClaimStatus
+	Endpoints           []EndpointStatus
+	SelectedEndpoint    string
+	Rclone              []RcloneStats
+	Draining            []string
+	Drains              []DrainStatus
+	Probes              []MountProbe
+	Credentials         CredentialStatus
+	LazyMount           *LazyMountStatus `json:",omitempty"`
+	Wiring              []ServiceWiringStatus `json:",omitempty"`
+	NodeReady           *NodeReadyStatus      `json:",omitempty"`
 }
 
 func (c *Controller) Snapshot() MetricsSnapshot {
+	draining, drains := c.drainSnapshot()
 	return MetricsSnapshot{
 		ReconcileTotal:      c.reconcileTotal,
 		ReconcileErrors:     c.reconcileErrors,
@@ -954,24 +1514,68 @@ func (c *Controller) Snapshot() MetricsSnapshot {
 		LastHealSuccessUnix: c.lastHealSuccessUnix,
 		OrphanCleanupTotal:  c.orphanCleanupTotal,
 		ReconcileDurationMs: c.lastReconcileMs,
-		MounterCreatedTotal: c.mounterCreatedTotal,
+		MounterCreatedTotal: c.mounterCreatedTotal(),
+		ClaimMounters:       c.claimMountersDesired,
+		SwarmNodeID:         c.knownNodeID(),
+		Reclaims:            c.reclaimSnapshot(),
+		Claims:              c.claimsSnapshot(),
+		Endpoints:           c.endpointsSnapshot(),
+		SelectedEndpoint:    c.currentEndpoint(),
+		Rclone:              c.rcloneStatsSnapshot(),
+		Draining:            draining,
+		Drains:              drains,
+		Probes:              c.probesSnapshot(),
+		Credentials:         c.credentialStatus(),
+		LazyMount:           c.lazyMountStatus(),
+		Wiring:              c.wiringSnapshot(),
+		NodeReady:           c.nodeReadyStatus(),
 	}
 }
 
 // Cleanup attempts to lazy-unmount and remove the mounter container on shutdown
 func (c *Controller) Cleanup() {
+	// the proxy goes last: the mounter may still flush through it
+	defer c.stopHAProxy()
 	if !c.cfg.UnmountOnExit {
 		return
 	}
+	// keep new tasks off this node before the mount goes away
+	c.withdrawNodeReady()
 	// lazy unmount via helper
 	_ = c.checkAndHealMount()
-	// stop & remove mounter if exists
+	// stop & remove this node's mounters (shared and per-claim)
 	args := filters.NewArgs()
-	args.Add("name", c.mounterName())
-	conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{All: true, Filters: args})
-	if err == nil && len(conts) > 0 {
-		id := conts[0].ID
-		_ = c.cli.ContainerRemove(context.Background(), id, container.RemoveOptions{Force: true})
+	args.Add("label", "swarmnative.mounter=managed")
+	args.Add("label", "swarmnative.mounter.node="+sanitizeHostname())
+	conts, err := c.cli.ContainerList(context.Background(), container.ListOptions{All: true, Filters: args})
+	if err == nil {
+		// let queued uploads finish first, all mounters in parallel
+		var wg sync.WaitGroup
+		for _, ct := range conts {
+			if ct.State != "running" {
+				continue
+			}
+			wg.Add(1)
+			go func(ct types.Container) {
+				defer wg.Done()
+				c.drainMounter(context.Background(), ct.ID, strings.TrimPrefix(firstName(ct.Names), "/"), ct.Labels[claimMounterLabel], "shutdown")
+			}(ct)
+		}
+		wg.Wait()
+		for _, ct := range conts {
+			_ = c.cli.ContainerRemove(context.Background(), ct.ID, container.RemoveOptions{Force: true})
+			if mp := ct.Labels["swarmnative.mounter.path"]; mp != "" && mp != c.cfg.Mountpoint {
+				_ = c.unmountIfMounted(mp)
+			}
+		}
+	}
+	// drop ro binds of access=ro claims
+	c.releaseReadOnlyBinds(nil)
+	// legacy mounters created before node labels existed
+	args = filters.NewArgs()
+	args.Add("name", "^/"+c.mounterName()+"$")
+	if conts, err := c.cli.ContainerList(context.Background(), container.ListOptions{All: true, Filters: args}); err == nil && len(conts) > 0 {
+		_ = c.cli.ContainerRemove(context.Background(), conts[0].ID, container.RemoveOptions{Force: true})
 	}
 }
 
@@ -989,15 +1593,15 @@ func (c *Controller) Preflight() error {
 		errs = append(errs, fmt.Sprintf("docker ping failed: %v", err))
 	}
 	// Credentials resolved
-	env := c.buildRcloneEnv()
-	hasAK := false
-	hasSK := false
-	for _, e := range env {
-		if strings.HasPrefix(e, "RCLONE_CONFIG_S3_ACCESS_KEY_ID=") { hasAK = true }
-		if strings.HasPrefix(e, "RCLONE_CONFIG_S3_SECRET_ACCESS_KEY=") { hasSK = true }
-	}
-	if !hasAK || !hasSK {
-		errs = append(errs, "missing access/secret credentials (set VOLS3_ACCESS_KEY/SECRET_KEY or mount secret files)")
+	if c.s3Backend() && !c.stsEnabled() {
+		if cr := c.defaultCredentials(); cr.AccessKey == "" || cr.SecretKey == "" {
+			errs = append(errs, "missing access/secret credentials (set VOLS3_ACCESS_KEY/SECRET_KEY or mount secret files)")
+		}
+	} else if !c.s3Backend() {
+		params, _ := parseBackendParams(c.cfg.BackendParams)
+		if missing := missingBackendParams(c.backend(), params, c.defaultCredentials().AccessKey != ""); len(missing) > 0 {
+			errs = append(errs, fmt.Sprintf("backend %s is missing %s", backendName(c.cfg.Backend), strings.Join(missing, ", ")))
+		}
 	}
 	// Helper image nsenter availability (best-effort)
 	name := c.helperName("nsenter-check")
@@ -1070,6 +1674,9 @@ func (c *Controller) resolveEndpointForMounter() string {
 	if c.cfg.EnableProxy && c.cfg.LocalLBEnabled && strings.TrimSpace(c.cfg.ProxyNetwork) != "" {
 		return fmt.Sprintf("http://%s:%s", c.localAlias(), strings.TrimSpace(c.cfg.ProxyPort))
 	}
+	if ep := c.currentEndpoint(); ep != "" {
+		return ep
+	}
 	return c.cfg.S3Endpoint
 }
 
@@ -1148,16 +1755,27 @@ func ValidateConfig(cfg Config) ValidationResult {
 	if strings.TrimSpace(cfg.Mountpoint) == "" {
 		errs = append(errs, "mountpoint is required")
 	}
-	if strings.TrimSpace(cfg.S3Endpoint) == "" {
-		errs = append(errs, "S3 endpoint is required")
-	} else {
-		if u, err := url.Parse(cfg.S3Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+	// other backends take their endpoint from VOLS3_BACKEND_PARAMS
+	if backendName(cfg.Backend) == "s3" {
+		if strings.TrimSpace(cfg.S3Endpoint) == "" {
+			errs = append(errs, "S3 endpoint is required")
+		} else if u, err := url.Parse(cfg.S3Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
 			errs = append(errs, "S3 endpoint must be a valid URL (e.g. http(s)://host:port)")
 		}
 	}
 	if strings.TrimSpace(cfg.MounterImage) == "" {
 		errs = append(errs, "mounter image is required")
 	}
+	if eps := (&Controller{cfg: cfg}).endpointList(); len(eps) > 0 {
+		for _, ep := range eps {
+			if !validEndpointURL(ep) {
+				errs = append(errs, fmt.Sprintf("endpoint %q in VOLS3_ENDPOINTS must be a valid URL", ep))
+			}
+		}
+		if cfg.EnableProxy {
+			warns = append(warns, "VOLS3_ENDPOINTS failover is ignored while the proxy is enabled")
+		}
+	}
 	// Treat zero PollInterval as "use default" and do not error
 	if cfg.PollInterval < 0 {
 		errs = append(errs, "poll interval must be >= 0")
@@ -1170,17 +1788,157 @@ func ValidateConfig(cfg Config) ValidationResult {
 			errs = append(errs, "mounter update mode must be one of never|periodic|on_change")
 		}
 	}
+	switch strings.TrimSpace(cfg.MounterUpgradeStrategy) {
+	case "", "recreate":
+	case "staged":
+		if strings.TrimSpace(cfg.MounterStagingRoot) == "" {
+			errs = append(errs, "staged mounter upgrades require a staging root")
+		} else if _, err := os.Stat(cfg.MounterStagingRoot); err != nil {
+			warns = append(warns, fmt.Sprintf("staging root not accessible (bind it rshared like the mountpoint): %v", err))
+		}
+	default:
+		errs = append(errs, "mounter upgrade strategy must be one of recreate|staged")
+	}
 	if _, err := os.Stat(cfg.AccessKeyFile); err != nil {
 		warns = append(warns, fmt.Sprintf("access key file not readable: %v", err))
 	}
 	if _, err := os.Stat(cfg.SecretKeyFile); err != nil {
 		warns = append(warns, fmt.Sprintf("secret key file not readable: %v", err))
 	}
+	if def, ok := backends[backendName(cfg.Backend)]; !ok {
+		errs = append(errs, "backend must be one of s3|gcs|azureblob|swift|webdav|sftp")
+	} else if params, err := parseBackendParams(cfg.BackendParams); err != nil {
+		errs = append(errs, err.Error())
+	} else if backendName(cfg.Backend) != "s3" {
+		_, akErr := os.Stat(cfg.AccessKeyFile)
+		keys := akErr == nil || os.Getenv("VOLS3_ACCESS_KEY") != ""
+		if missing := missingBackendParams(def, params, keys); len(missing) > 0 {
+			errs = append(errs, fmt.Sprintf("backend %s requires %s (VOLS3_BACKEND_PARAMS)", backendName(cfg.Backend), strings.Join(missing, ", ")))
+		}
+		if strings.TrimSpace(cfg.STSEndpoint) != "" {
+			errs = append(errs, "STS credentials require the s3 backend")
+		}
+		if cfg.EnableProxy || len((&Controller{cfg: cfg}).endpointList()) > 0 {
+			warns = append(warns, "the S3 proxy and VOLS3_ENDPOINTS failover do not apply to non-s3 backends")
+		}
+		if !def.buckets && cfg.AutoCreateBucket {
+			warns = append(warns, fmt.Sprintf("backend %s has no buckets: auto-create bucket is ignored", backendName(cfg.Backend)))
+		}
+	}
+	if strings.TrimSpace(cfg.STSEndpoint) != "" {
+		if !validEndpointURL(cfg.STSEndpoint) {
+			errs = append(errs, "STS endpoint must be a valid URL")
+		}
+		if strings.TrimSpace(cfg.STSCredentialsDir) == "" {
+			errs = append(errs, "STS requires a credentials dir shared with the mounter")
+		}
+		if cfg.STSDuration > 0 && cfg.STSDuration < 15*time.Minute {
+			warns = append(warns, "STS duration below 15m is rejected by most STS servers")
+		}
+		if f := strings.TrimSpace(cfg.STSTokenFile); f != "" {
+			if _, err := os.Stat(f); err != nil {
+				warns = append(warns, fmt.Sprintf("STS token file not readable: %v", err))
+			}
+		}
+	}
 	if strings.TrimSpace(cfg.ProxyPort) != "" {
 		if _, err := strconv.Atoi(cfg.ProxyPort); err != nil {
 			errs = append(errs, "proxy port must be a number")
 		}
 	}
+	if cfg.EnableProxy {
+		switch strings.TrimSpace(cfg.ProxyEngine) {
+		case "", "haproxy", "native":
+		default:
+			errs = append(errs, "proxy engine must be haproxy or native")
+		}
+		switch cfg.ProxyBalance {
+		case "", "leastconn", "roundrobin":
+		default:
+			errs = append(errs, "proxy balance must be leastconn or roundrobin")
+		}
+		if strings.TrimSpace(cfg.ProxyLocalServices) == "" {
+			errs = append(errs, "proxy local services are required when the proxy is enabled")
+		}
+		if _, err := strconv.Atoi(cfg.ProxyBackendPort); err != nil {
+			errs = append(errs, "proxy backend port must be a number")
+		}
+		if cfg.ProxyEngine != "native" && strings.TrimSpace(cfg.HAProxyConfigFile) == "" {
+			errs = append(errs, "haproxy config file is required for the haproxy engine")
+		}
+	}
+	if cfg.VolumePluginEnabled && strings.TrimSpace(cfg.VolumePluginSocket) == "" {
+		errs = append(errs, "volume plugin socket is required when volume plugin is enabled")
+	}
+	if cfg.ReclaimEnabled {
+		if cfg.ReclaimGracePeriod <= 0 || cfg.ReclaimRetention <= 0 {
+			errs = append(errs, "reclaim grace period and retention must be > 0")
+		}
+		if !cfg.ReadServiceLabels {
+			warns = append(warns, "reclaim only tracks service claims: enable service label reading")
+		}
+		if !cfg.ReclaimDryRun {
+			warns = append(warns, "reclaim is live: released Delete claims will be moved to .trash and purged")
+		}
+	}
+	if f := strings.TrimSpace(cfg.ClassCatalogFile); f != "" {
+		if classes, err := LoadClassCatalog(f); err != nil {
+			errs = append(errs, fmt.Sprintf("class catalog: %v", err))
+		} else {
+			tmp := &Controller{cfg: cfg}
+			for name, sc := range classes {
+				if cfg.MounterMode != "per_claim" && sc.Endpoint != "" {
+					errs = append(errs, fmt.Sprintf("class %q endpoint requires VOLS3_MOUNTER_MODE=per_claim", name))
+				} else if cfg.MounterMode != "per_claim" && len(classMounterArgs(sc)) > 0 {
+					warns = append(warns, fmt.Sprintf("class %q mount settings only apply with per_claim mounters", name))
+				}
+				if sc.Credentials == "" {
+					continue
+				}
+				if cfg.MounterMode != "per_claim" {
+					errs = append(errs, fmt.Sprintf("class %q credentials require VOLS3_MOUNTER_MODE=per_claim", name))
+				} else if _, err := tmp.credentialEnv(sc.Credentials); err != nil {
+					warns = append(warns, fmt.Sprintf("class %q: %v", name, err))
+				}
+			}
+		}
+	}
+	switch strings.TrimSpace(cfg.MounterMode) {
+	case "", "shared", "per_claim":
+	default:
+		errs = append(errs, "mounter mode must be one of shared|per_claim")
+	}
+	if cfg.NodeAwareClaims && strings.TrimSpace(cfg.ManagerDockerHost) == "" {
+		warns = append(warns, "node-aware claims list tasks via the manager API: on workers set a manager docker host or service claims are dropped")
+	}
+	if cfg.LazyMount {
+		if cfg.IdleUnmountAfter <= 0 {
+			errs = append(errs, "idle unmount period must be > 0 with lazy mounting")
+		}
+		if cfg.MounterMode == "per_claim" {
+			warns = append(warns, "lazy mounting only applies to the shared mounter; per_claim mounters already follow their claims")
+		}
+		if cfg.ReadServiceLabels && !cfg.NodeAwareClaims {
+			warns = append(warns, "lazy mounting without node-aware claims: any service claim in the cluster keeps the mount up")
+		}
+	}
+	if cfg.AutoWireServices {
+		if !cfg.ReadServiceLabels {
+			warns = append(warns, "service wiring without service label reading: wired services are not provisioned")
+		}
+		if !cfg.AutoWireDryRun {
+			warns = append(warns, "service wiring is live: labelled services will be updated (and their tasks restarted)")
+		}
+	}
+	if cfg.NodeReadyLabel && cfg.NodeReadyThreshold < 1 {
+		errs = append(errs, "node ready threshold must be >= 1")
+	}
+	if cfg.ServiceStatusLabels && !cfg.ReadServiceLabels {
+		warns = append(warns, "service status labels without service label reading: no service claims to report")
+	}
+	if ep := strings.TrimSpace(cfg.CSIEndpoint); ep != "" && !strings.HasPrefix(ep, "unix://") {
+		errs = append(errs, "CSI endpoint must be a unix:// socket")
+	}
 	if cfg.ReadOnly && (cfg.AutoCreateBucket || cfg.AutoCreatePrefix) {
 		warns = append(warns, "read-only mode: auto-create bucket/prefix is ignored")
 	}
@@ -1189,10 +1947,15 @@ func ValidateConfig(cfg Config) ValidationResult {
 		"s3_endpoint":           cfg.S3Endpoint,
 		"s3_provider":           cfg.S3Provider,
 		"rclone_remote":         cfg.RcloneRemote,
+		"backend":               backendName(cfg.Backend),
+		"rclone_rc_addr":        cfg.RcloneRCAddr,
+		"drain_timeout":         cfg.DrainTimeout.String(),
+		"probe_timeout":         cfg.ProbeTimeout.String(),
 		"mounter_image":         cfg.MounterImage,
 		"helper_image":          cfg.HelperImage,
 		"poll_interval":         cfg.PollInterval.String(),
 		"mounter_update_mode":   cfg.MounterUpdateMode,
+		"mounter_upgrade":       cfg.MounterUpgradeStrategy,
 		"mounter_pull_interval": cfg.MounterPullInterval.String(),
 		"unmount_on_exit":       fmt.Sprintf("%t", cfg.UnmountOnExit),
 		"auto_create_bucket":    fmt.Sprintf("%t", cfg.AutoCreateBucket),
@@ -1200,17 +1963,62 @@ func ValidateConfig(cfg Config) ValidationResult {
 		"read_only":             fmt.Sprintf("%t", cfg.ReadOnly),
 		"allow_other":           fmt.Sprintf("%t", cfg.AllowOther),
 		"enable_proxy":          fmt.Sprintf("%t", cfg.EnableProxy),
+		"proxy_engine":          cfg.ProxyEngine,
+		"proxy_local_services":  cfg.ProxyLocalServices,
+		"proxy_remote_service":  cfg.ProxyRemoteService,
+		"proxy_balance":         cfg.ProxyBalance,
+		"proxy_drain_services":  cfg.ProxyDrainServices,
+		"haproxy_config_file":   cfg.HAProxyConfigFile,
 		"local_lb_enabled":      fmt.Sprintf("%t", cfg.LocalLBEnabled),
 		"proxy_port":            cfg.ProxyPort,
 		"proxy_network":         cfg.ProxyNetwork,
 		"label_prefix":          cfg.LabelPrefix,
 		"access_key_file":       cfg.AccessKeyFile,
 		"secret_key_file":       cfg.SecretKeyFile,
+		"session_token_file":    cfg.SessionTokenFile,
+		"credentials_check":     cfg.CredentialCheckInterval.String(),
+		"sts_endpoint":          cfg.STSEndpoint,
+		"volume_plugin_enabled": fmt.Sprintf("%t", cfg.VolumePluginEnabled),
+		"volume_plugin_socket":  cfg.VolumePluginSocket,
+		"csi_endpoint":          cfg.CSIEndpoint,
+		"mounter_mode":          cfg.MounterMode,
+		"lazy_mount":            fmt.Sprintf("%t", cfg.LazyMount),
+		"idle_unmount_after":    cfg.IdleUnmountAfter.String(),
+		"auto_wire_services":    fmt.Sprintf("%t", cfg.AutoWireServices),
+		"auto_wire_dry_run":     fmt.Sprintf("%t", cfg.AutoWireDryRun),
+		"service_status_labels": fmt.Sprintf("%t", cfg.ServiceStatusLabels),
+		"node_ready_label":      fmt.Sprintf("%t", cfg.NodeReadyLabel),
+		"node_ready_threshold":  fmt.Sprintf("%d", cfg.NodeReadyThreshold),
+		"ro_mount_root":         cfg.ReadOnlyMountRoot,
+		"class_catalog_file":    cfg.ClassCatalogFile,
+		"credentials_dir":       cfg.CredentialsDir,
+		"endpoints":             cfg.MinioEndpointsCSV,
+		"s3_region":             cfg.S3Region,
+		"s3_path_style":         fmt.Sprintf("%t", cfg.S3PathStyle),
+		"reclaim_enabled":       fmt.Sprintf("%t", cfg.ReclaimEnabled),
+		"reclaim_dry_run":       fmt.Sprintf("%t", cfg.ReclaimDryRun),
+		"reclaim_grace_period":  cfg.ReclaimGracePeriod.String(),
+		"reclaim_retention":     cfg.ReclaimRetention.String(),
 	}
 	return ValidationResult{OK: len(errs) == 0, Errors: errs, Warnings: warns, Summary: sum}
 }
 
+// Validate is ValidateConfig plus the live state it cannot see: class catalog
+// reload errors and claims naming unknown classes.
+func (c *Controller) Validate() ValidationResult {
+	vr := ValidateConfig(c.cfg)
+	if errs := c.validateClasses(); len(errs) > 0 {
+		vr.Errors = append(vr.Errors, errs...)
+		vr.OK = false
+	}
+	return vr
+}
+
 func (c *Controller) buildPresetArgs() []string {
+	// presets are s3 flags
+	if !c.s3Backend() {
+		return nil
+	}
 	p := strings.ToLower(strings.TrimSpace(c.cfg.Preset))
 	switch p {
 	case "aws":
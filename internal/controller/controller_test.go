@@ -38,3 +38,32 @@ func TestValidateConfig_Minimal(t *testing.T) {
 		t.Fatalf("expected OK, got: %#v", vr)
 	}
 }
+
+func TestClaimMounterSpec(t *testing.T) {
+	c := &Controller{cfg: Config{RcloneRemote: "S3:shared", Mountpoint: "/mnt/s3", RcloneExtraArgs: "--dir-cache-time=1h"}}
+	ms := c.claimMounterSpec(claimSpec{enabled: true, prefix: "teams/a", access: "ro", args: "--vfs-cache-mode=full"})
+	if ms.remote != "S3:shared/teams/a" || ms.mountpoint != "/mnt/s3/teams/a" || !ms.readOnly {
+		t.Fatalf("unexpected spec: %#v", ms)
+	}
+	if len(ms.extraArgs) != 2 || ms.extraArgs[1] != "--vfs-cache-mode=full" {
+		t.Fatalf("claim args not applied: %#v", ms.extraArgs)
+	}
+	other := c.claimMounterSpec(claimSpec{enabled: true, bucket: "b2", prefix: "teams/a"})
+	if other.remote != "S3:b2/teams/a" || other.name == ms.name {
+		t.Fatalf("claims in different buckets must not share a mounter: %#v", other)
+	}
+}
+
+func TestClaimEffectivePath_ReadOnly(t *testing.T) {
+	c := &Controller{cfg: Config{Mountpoint: "/mnt/s3", ReadOnlyMountRoot: "/mnt/s3-ro"}}
+	if p := c.claimEffectivePath(claimSpec{prefix: "a/b", access: "ro"}); p != "/mnt/s3-ro/a/b" {
+		t.Fatalf("ro claim path: %s", p)
+	}
+	if p := c.claimEffectivePath(claimSpec{prefix: "a/b", access: "rw"}); p != "/mnt/s3/a/b" {
+		t.Fatalf("rw claim path: %s", p)
+	}
+	c.cfg.MounterMode = "per_claim"
+	if p := c.claimEffectivePath(claimSpec{prefix: "a/b", access: "ro"}); p != "/mnt/s3/a/b" {
+		t.Fatalf("per-claim ro mounter serves the claim path directly: %s", p)
+	}
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/swarmnative/volume-s3/internal/s3"
+)
+
+// Per-claim credentials. volume-s3.credentials (or a class's credentials)
+// names a set of Swarm secrets under CredentialsDir:
+//
+//	<name>_access_key, <name>_secret_key, optional <name>_session_token
+//
+// Each set becomes its own rclone remote <NAME> (RCLONE_CONFIG_<NAME>_*). A
+// claim mounter only receives the env of its own set, so a leaked app mount
+// cannot reach buckets granted to other credentials. The shared mounter has a
+// single identity, hence credentials require per_claim mounters.
+
+var credentialNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_]*$`)
+
+// defaultRemote is the remote configured from VOLS3_ACCESS_KEY(_FILE) when
+// VOLS3_RCLONE_REMOTE names none.
+const defaultRemote = "S3"
+
+func validCredentialName(name string) bool {
+	return credentialNameRe.MatchString(name) && !strings.EqualFold(name, defaultRemote)
+}
+
+// credentialRemote is the rclone remote name of a credential set.
+func credentialRemote(name string) string {
+	return strings.ToUpper(name)
+}
+
+// claimRemoteName is the rclone remote the claim is accessed through.
+func (c *Controller) claimRemoteName(cs claimSpec) string {
+	if cs.credentials != "" {
+		return credentialRemote(cs.credentials)
+	}
+	return c.defaultRemoteName()
+}
+
+func (c *Controller) credentialFile(name, kind string) string {
+	return filepath.Join(c.cfg.CredentialsDir, name+"_"+kind)
+}
+
+// credentialSet reads the keys of a credential set.
+func (c *Controller) credentialSet(name string) (s3.Credentials, error) {
+	if !validCredentialName(name) {
+		return s3.Credentials{}, fmt.Errorf("invalid credentials name %q", name)
+	}
+	read := func(kind string, required bool) (string, error) {
+		b, err := os.ReadFile(c.credentialFile(name, kind))
+		if err != nil {
+			if !required && os.IsNotExist(err) {
+				return "", nil
+			}
+			return "", fmt.Errorf("credentials %q: %w", name, err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	var cr s3.Credentials
+	var err error
+	if cr.AccessKey, err = read("access_key", true); err != nil {
+		return cr, err
+	}
+	if cr.SecretKey, err = read("secret_key", true); err != nil {
+		return cr, err
+	}
+	if cr.SessionToken, err = read("session_token", false); err != nil {
+		return cr, err
+	}
+	return cr, nil
+}
+
+// credentialEnv builds the rclone env for the remote of a credential set.
+func (c *Controller) credentialEnv(name string) ([]string, error) {
+	cr, err := c.credentialSet(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.remoteEnv(credentialRemote(name), cr), nil
+}
+
+// checkClaimCredentials rejects claims whose credentials cannot be honoured.
+func (c *Controller) checkClaimCredentials(cs claimSpec) error {
+	if cs.credentials == "" {
+		return nil
+	}
+	if !c.perClaimMounters() {
+		return fmt.Errorf("credentials %q require VOLS3_MOUNTER_MODE=per_claim", cs.credentials)
+	}
+	if strings.EqualFold(credentialRemote(cs.credentials), c.defaultRemoteName()) {
+		return fmt.Errorf("credentials %q clash with the default remote", cs.credentials)
+	}
+	_, err := c.credentialEnv(cs.credentials)
+	return err
+}
+
+// resolveClaim applies class defaults and checks the claim's credentials.
+func (c *Controller) resolveClaim(cs claimSpec) (claimSpec, error) {
+	if cs.err != nil {
+		return cs, cs.err
+	}
+	cs, err := c.resolveClass(cs)
+	if err != nil {
+		return cs, err
+	}
+	return cs, c.checkClaimCredentials(cs)
+}
+
+// rcloneEnvFor returns the env for a one-shot rclone command: the default
+// remote plus every credential remote referenced by the command arguments.
+func (c *Controller) rcloneEnvFor(cmd []string) ([]string, error) {
+	env := c.buildRcloneEnv()
+	seen := map[string]struct{}{}
+	for _, a := range cmd {
+		i := strings.Index(a, ":")
+		if i <= 0 || strings.HasPrefix(a, "-") {
+			continue
+		}
+		name := strings.ToLower(a[:i])
+		if _, ok := seen[name]; ok || !validCredentialName(name) || strings.EqualFold(name, c.defaultRemoteName()) {
+			continue
+		}
+		seen[name] = struct{}{}
+		ce, err := c.credentialEnv(name)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, ce...)
+	}
+	return env, nil
+}
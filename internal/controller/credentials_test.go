@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialEnv(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "team_a_access_key"), []byte("AK\n"), 0o600)
+	_ = os.WriteFile(filepath.Join(dir, "team_a_secret_key"), []byte("SK\n"), 0o600)
+	c := &Controller{cfg: Config{CredentialsDir: dir, S3Endpoint: "http://s3:9000", MounterMode: "per_claim", RcloneRemote: "S3:shared"}}
+
+	env, err := c.credentialEnv("team_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(env, "\n")
+	for _, want := range []string{"RCLONE_CONFIG_TEAM_A_TYPE=s3", "RCLONE_CONFIG_TEAM_A_ACCESS_KEY_ID=AK", "RCLONE_CONFIG_TEAM_A_SECRET_ACCESS_KEY=SK", "RCLONE_CONFIG_TEAM_A_ENDPOINT=http://s3:9000"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("missing %s in %v", want, env)
+		}
+	}
+	if _, err := c.credentialEnv("team_b"); err == nil {
+		t.Fatal("expected error for missing secret files")
+	}
+	if _, err := c.credentialEnv("s3"); err == nil {
+		t.Fatal("default remote name must be reserved")
+	}
+
+	cs := claimSpec{enabled: true, bucket: "a", prefix: "data", credentials: "team_a"}
+	if err := c.checkClaimCredentials(cs); err != nil {
+		t.Fatal(err)
+	}
+	ms := c.claimMounterSpec(cs)
+	if ms.remote != "TEAM_A:a/data" || ms.credentials != "team_a" {
+		t.Fatalf("unexpected mounter spec: %+v", ms)
+	}
+
+	c.cfg.MounterMode = "shared"
+	if err := c.checkClaimCredentials(cs); err == nil {
+		t.Fatal("credentials must be rejected with the shared mounter")
+	}
+}
@@ -0,0 +1,506 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// CSIDriverName is reported by GetPluginInfo and must match the Swarm plugin name.
+const CSIDriverName = "volume-s3"
+
+// CSIServer implements the CSI Identity, Controller and Node services for
+// Swarm cluster volumes. Volumes are claims under the shared rclone mount; the
+// volume ID carries the claim itself. Only the name of each created volume is
+// recorded (csiVolumesDir), so a name is never reused for another claim.
+type CSIServer struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	csi.UnimplementedNodeServer
+	c       *Controller
+	version string
+	// created volumes by name; mirrors the records on the shared mount
+	mu      sync.Mutex
+	volumes map[string]csiVolumeRecord
+	// volume IDs staged on this node by staging path
+	staged map[string]string
+}
+
+// csiVolumesDir holds one record per created volume name under the shared
+// mount, so every manager answering CreateVolume sees the same names.
+const csiVolumesDir = ".csi-volumes"
+
+type csiVolumeRecord struct {
+	ID       string `json:"id"`
+	Capacity int64  `json:"capacity,omitempty"`
+}
+
+// NewCSIServer creates the CSI services backed by the controller.
+func NewCSIServer(c *Controller, version string) *CSIServer {
+	s := &CSIServer{c: c, version: version, staged: map[string]string{}}
+	c.registerClaimHolder("csi", s.liveClaims)
+	c.registerNodeClaims("csi", s.stagedClaims)
+	return s
+}
+
+// stagedClaims lists the claims of the volumes staged on this node.
+func (s *CSIServer) stagedClaims() []claimSpec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []claimSpec
+	for path, id := range s.staged {
+		if cs, err := claimFromCSIVolumeID(id); err == nil {
+			cs.source = "csi-staged:" + path
+			out = append(out, cs)
+		}
+	}
+	return out
+}
+
+func (s *CSIServer) setStaged(path, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == "" {
+		delete(s.staged, path)
+		return
+	}
+	s.staged[path] = id
+}
+
+// Register attaches all CSI services to the gRPC server.
+func (s *CSIServer) Register(g *grpc.Server) {
+	csi.RegisterIdentityServer(g, s)
+	csi.RegisterControllerServer(g, s)
+	csi.RegisterNodeServer(g, s)
+}
+
+// csiVolumeID encodes a claim as a stable volume ID (bucket/prefix/access/reclaim).
+func csiVolumeID(cs claimSpec) string {
+	v := url.Values{}
+	v.Set("prefix", cs.prefix)
+	if cs.bucket != "" {
+		v.Set("bucket", cs.bucket)
+	}
+	if cs.class != "" {
+		v.Set("class", cs.class)
+	}
+	if cs.access != "" {
+		v.Set("access", cs.access)
+	}
+	if cs.reclaim != "" {
+		v.Set("reclaim", cs.reclaim)
+	}
+	if cs.credentials != "" {
+		v.Set("credentials", cs.credentials)
+	}
+	return v.Encode()
+}
+
+func claimFromCSIVolumeID(id string) (claimSpec, error) {
+	v, err := url.ParseQuery(id)
+	if err != nil || strings.Trim(v.Get("prefix"), "/") == "" {
+		return claimSpec{}, fmt.Errorf("invalid volume id %q", id)
+	}
+	opts := map[string]string{}
+	for _, k := range []string{"bucket", "prefix", "class", "access", "reclaim", "credentials"} {
+		if x := v.Get(k); x != "" {
+			opts[k] = x
+		}
+	}
+	return claimFromVolumeOptions("", opts)
+}
+
+func validateCSICapabilities(caps []*csi.VolumeCapability) error {
+	if len(caps) == 0 {
+		return status.Error(codes.InvalidArgument, "volume capabilities are required")
+	}
+	for _, vc := range caps {
+		if vc.GetBlock() != nil {
+			return status.Error(codes.InvalidArgument, "block access is not supported")
+		}
+		if vc.GetMount() == nil {
+			return status.Error(codes.InvalidArgument, "mount access type is required")
+		}
+		if vc.GetAccessMode() == nil {
+			return status.Error(codes.InvalidArgument, "access mode is required")
+		}
+	}
+	return nil
+}
+
+func readOnlyCSIMode(vc *csi.VolumeCapability) bool {
+	switch vc.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
+// --- Identity ---
+
+func (s *CSIServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: CSIDriverName, VendorVersion: s.version}, nil
+}
+
+func (s *CSIServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: []*csi.PluginCapability{{
+		Type: &csi.PluginCapability_Service_{Service: &csi.PluginCapability_Service{Type: csi.PluginCapability_Service_CONTROLLER_SERVICE}},
+	}}}, nil
+}
+
+func (s *CSIServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(s.c.Ready() == nil)}, nil
+}
+
+// --- Controller ---
+
+func (s *CSIServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: []*csi.ControllerServiceCapability{{
+		Type: &csi.ControllerServiceCapability_Rpc{Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME}},
+	}}}, nil
+}
+
+func (s *CSIServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if strings.TrimSpace(req.GetName()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	if err := validateCSICapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, err
+	}
+	capacity, err := csiCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+	cs, err := claimFromVolumeOptions(req.GetName(), req.GetParameters())
+	if err == nil {
+		cs, err = s.c.resolveClaim(cs)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.c.ensureRemotePaths(cs); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "ensure remote: %v", err)
+	}
+	rec, err := s.recordVolume(req.GetName(), csiVolumeRecord{ID: csiVolumeID(cs), Capacity: capacity}, req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("csi create volume", "name", req.GetName(), "bucket", cs.bucket, "prefix", cs.prefix)
+	return &csi.CreateVolumeResponse{Volume: &csi.Volume{
+		VolumeId:      rec.ID,
+		CapacityBytes: rec.Capacity,
+		VolumeContext: map[string]string{"prefix": cs.prefix, "bucket": cs.bucket, "access": cs.access},
+	}}, nil
+}
+
+// csiCapacity checks a capacity range. Object storage has no size limit, so a
+// volume reports the required bytes (0, unknown, when none are required).
+func csiCapacity(r *csi.CapacityRange) (int64, error) {
+	req, limit := r.GetRequiredBytes(), r.GetLimitBytes()
+	if req < 0 || limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, "capacity range must not be negative")
+	}
+	if limit > 0 && req > limit {
+		return 0, status.Errorf(codes.InvalidArgument, "required bytes %d exceed limit bytes %d", req, limit)
+	}
+	return req, nil
+}
+
+// recordVolume registers a created volume name. Creating an existing name is
+// idempotent when the claim matches and its capacity fits the range, anything
+// else is AlreadyExists. Records go to the shared mount when it is writable.
+func (s *CSIServer) recordVolume(name string, rec csiVolumeRecord, r *csi.CapacityRange) (csiVolumeRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadVolumes()
+	file := filepath.Join(s.c.cfg.Mountpoint, csiVolumesDir, url.PathEscape(name))
+	if prev, ok := s.volumes[name]; ok {
+		if prev.ID != rec.ID {
+			return prev, status.Errorf(codes.AlreadyExists, "volume %s exists with other parameters", name)
+		}
+		if prev.Capacity < r.GetRequiredBytes() || (r.GetLimitBytes() > 0 && prev.Capacity > r.GetLimitBytes()) {
+			return prev, status.Errorf(codes.AlreadyExists, "volume %s exists with capacity %d", name, prev.Capacity)
+		}
+		return prev, nil
+	}
+	b, err := json.Marshal(rec)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(file), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(file, b, 0o644)
+	}
+	if err != nil {
+		slog.Warn("csi volume record", "name", name, "error", err)
+	}
+	s.volumes[name] = rec
+	return rec, nil
+}
+
+// loadVolumes reads the records on the shared mount into s.volumes; they are
+// authoritative whenever they can be read, other managers may have changed
+// them. Callers hold s.mu.
+func (s *CSIServer) loadVolumes() {
+	if s.volumes == nil {
+		s.volumes = map[string]csiVolumeRecord{}
+	}
+	dir := filepath.Join(s.c.cfg.Mountpoint, csiVolumesDir)
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	s.volumes = map[string]csiVolumeRecord{}
+	for _, e := range ents {
+		name, err := url.PathUnescape(e.Name())
+		if err != nil {
+			continue
+		}
+		var rec csiVolumeRecord
+		if b, err := os.ReadFile(filepath.Join(dir, e.Name())); err == nil && json.Unmarshal(b, &rec) == nil && rec.ID != "" {
+			s.volumes[name] = rec
+		}
+	}
+}
+
+// forgetVolume drops the name records of a deleted volume ID and returns them.
+func (s *CSIServer) forgetVolume(id string) map[string]csiVolumeRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadVolumes()
+	names := map[string]csiVolumeRecord{}
+	for name, rec := range s.volumes {
+		if rec.ID != id {
+			continue
+		}
+		names[name] = rec
+		delete(s.volumes, name)
+		file := filepath.Join(s.c.cfg.Mountpoint, csiVolumesDir, url.PathEscape(name))
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("csi volume record", "name", name, "error", err)
+		}
+	}
+	return names
+}
+
+// restoreVolumes puts back the records of a volume whose deletion failed.
+func (s *CSIServer) restoreVolumes(names map[string]csiVolumeRecord, id string) {
+	for name, rec := range names {
+		if _, err := s.recordVolume(name, rec, nil); err != nil {
+			slog.Warn("csi volume record", "name", name, "id", id, "error", err)
+		}
+	}
+}
+
+// liveClaims lists the claims of the recorded CSI volumes.
+func (s *CSIServer) liveClaims() []claimSpec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadVolumes()
+	var out []claimSpec
+	for name, rec := range s.volumes {
+		if cs, err := claimFromCSIVolumeID(rec.ID); err == nil {
+			cs.source = "csi:" + name
+			out = append(out, cs)
+		}
+	}
+	return out
+}
+
+func (s *CSIServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+	cs, err := claimFromCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		// unknown IDs are treated as already deleted
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	// the volume's own records must not hold its prefix
+	names := s.forgetVolume(req.GetVolumeId())
+	if err := s.c.reclaimClaim(cs); err != nil {
+		s.restoreVolumes(names, req.GetVolumeId())
+		return nil, status.Errorf(codes.Internal, "reclaim: %v", err)
+	}
+	slog.Info("csi delete volume", "prefix", cs.prefix, "reclaim", cs.reclaim)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (s *CSIServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+	if _, err := claimFromCSIVolumeID(req.GetVolumeId()); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err := validateCSICapabilities(req.GetVolumeCapabilities()); err != nil {
+		if status.Code(err) == codes.InvalidArgument && len(req.GetVolumeCapabilities()) > 0 {
+			return &csi.ValidateVolumeCapabilitiesResponse{Message: status.Convert(err).Message()}, nil
+		}
+		return nil, err
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+		VolumeCapabilities: req.GetVolumeCapabilities(),
+	}}, nil
+}
+
+// --- Node ---
+
+func (s *CSIServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: sanitizeHostname()}, nil
+}
+
+func (s *CSIServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: []*csi.NodeServiceCapability{{
+		Type: &csi.NodeServiceCapability_Rpc{Rpc: &csi.NodeServiceCapability_RPC{Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME}},
+	}}}, nil
+}
+
+// NodeStageVolume makes sure the mounter serving the claim is up, then binds
+// the claim directory onto the staging path. In per_claim mode that is the
+// claim's own mounter, which stays up while the volume is staged here.
+func (s *CSIServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetStagingTargetPath() == "" || req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume id, staging path and capability are required")
+	}
+	cs, err := claimFromCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if cs, err = s.c.resolveClaim(cs); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if s.c.claimDraining(cs) {
+		return nil, status.Error(codes.Unavailable, "mounter is draining")
+	}
+	src := s.c.claimPath(cs)
+	if s.c.perClaimMounters() {
+		s.setStaged(req.GetStagingTargetPath(), req.GetVolumeId())
+		if err := s.c.ensureClaimMount(cs); err != nil {
+			s.setStaged(req.GetStagingTargetPath(), "")
+			return nil, status.Errorf(codes.Unavailable, "claim mounter: %v", err)
+		}
+	} else {
+		if err := s.c.ensureMounter(); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "ensure mounter: %v", err)
+		}
+		// until the FUSE mount is up the mountpoint is a local directory
+		if err := s.c.waitMounted(s.c.cfg.Mountpoint, claimMountTimeout); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "mount not ready: %v", err)
+		}
+	}
+	probe, write := s.c.cfg.Mountpoint, !s.c.cfg.ReadOnly
+	if s.c.perClaimMounters() {
+		probe, write = src, write && !isReadOnlyClaim(cs)
+	}
+	if write {
+		if err := s.c.probeMount(probe, true).Err(); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "mount not ready: %v", err)
+		}
+	}
+	if err := os.MkdirAll(src, 0o755); err != nil {
+		return nil, status.Errorf(codes.Internal, "mkdir claim: %v", err)
+	}
+	if err := s.bindMount(src, req.GetStagingTargetPath(), false); err != nil {
+		return nil, status.Errorf(codes.Internal, "stage: %v", err)
+	}
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (s *CSIServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and staging path are required")
+	}
+	if err := s.unmountPath(req.GetStagingTargetPath(), false); err != nil {
+		return nil, status.Errorf(codes.Internal, "unstage: %v", err)
+	}
+	// the claim mounter goes with the next reconcile
+	s.setStaged(req.GetStagingTargetPath(), "")
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (s *CSIServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetTargetPath() == "" || req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume id, target path and capability are required")
+	}
+	if req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.FailedPrecondition, "staging path is required")
+	}
+	cs, err := claimFromCSIVolumeID(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	ro := req.GetReadonly() || readOnlyCSIMode(req.GetVolumeCapability()) || strings.EqualFold(cs.access, "ro")
+	if err := s.bindMount(req.GetStagingTargetPath(), req.GetTargetPath(), ro); err != nil {
+		return nil, status.Errorf(codes.Internal, "publish: %v", err)
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (s *CSIServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and target path are required")
+	}
+	if err := s.unmountPath(req.GetTargetPath(), true); err != nil {
+		return nil, status.Errorf(codes.Internal, "unpublish: %v", err)
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// csiBindSh binds $1 at $2 in the host mount namespace, read-only when $3 is
+// "ro"; an existing mount at $2 is kept. Paths are positional arguments, never
+// part of the script.
+const csiBindSh = `mounted() { awk -v p="$1" '$5 == p { f = 1 } END { exit !f }' /proc/self/mountinfo; }
+mkdir -p "$2" || exit 1
+if mounted "$2"; then exit 0; fi
+mount --bind "$1" "$2" || exit 1
+if [ "$3" = ro ]; then mount -o remount,bind,ro "$2" || { umount "$2"; exit 1; }; fi
+exit 0`
+
+// csiUnbindSh unmounts $1 in the host mount namespace when it is a mount
+// point and removes the directory when $2 is "rmdir".
+const csiUnbindSh = `if awk -v p="$1" '$5 == p { f = 1 } END { exit !f }' /proc/self/mountinfo; then umount "$1" || exit 1; fi
+if [ "$2" = rmdir ]; then rmdir "$1" 2>/dev/null; fi
+exit 0`
+
+// csiHelper names the host helper of one CSI path: concurrent calls for
+// different volumes must not share a container name.
+func csiHelper(kind, path string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path))
+	return fmt.Sprintf("%s-%08x", kind, h.Sum32())
+}
+
+// bindMount bind-mounts src onto dst on the host (idempotent), remounting
+// read-only when requested. The controller runs unprivileged in its own mount
+// namespace, so the mount goes through the host helper.
+func (s *CSIServer) bindMount(src, dst string, ro bool) error {
+	mode := "rw"
+	if ro {
+		mode = "ro"
+	}
+	return s.c.runHostHelper(csiHelper("csi-bind", dst), "nsenter", "-t", "1", "-m", "--", "sh", "-c", csiBindSh, "sh", src, dst, mode)
+}
+
+// unmountPath unmounts p on the host when it is a mountpoint (idempotent),
+// removing the directory as well when rmdir is set.
+func (s *CSIServer) unmountPath(p string, rmdir bool) error {
+	arg := ""
+	if rmdir {
+		arg = "rmdir"
+	}
+	return s.c.runHostHelper(csiHelper("csi-unbind", p), "nsenter", "-t", "1", "-m", "--", "sh", "-c", csiUnbindSh, "sh", p, arg)
+}
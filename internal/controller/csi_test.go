@@ -0,0 +1,360 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func mountCap(mode csi.VolumeCapability_AccessMode_Mode) []*csi.VolumeCapability {
+	return []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}}
+}
+
+func TestCSI_CreateDeleteVolume(t *testing.T) {
+	dir := t.TempDir()
+	s := NewCSIServer(&Controller{cfg: Config{Mountpoint: dir, RcloneRemote: "S3:b"}}, "test")
+	ctx := context.Background()
+
+	if _, err := s.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "v"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument without capabilities, got %v", err)
+	}
+	req := &csi.CreateVolumeRequest{
+		Name:               "v",
+		VolumeCapabilities: mountCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		Parameters:         map[string]string{"bucket": "b", "prefix": "teams/a", "reclaim": "Delete"},
+	}
+	r1, err := s.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	r2, _ := s.CreateVolume(ctx, req)
+	if r1.Volume.VolumeId != r2.Volume.VolumeId {
+		t.Fatalf("create not idempotent: %q vs %q", r1.Volume.VolumeId, r2.Volume.VolumeId)
+	}
+	cs, err := claimFromCSIVolumeID(r1.Volume.VolumeId)
+	if err != nil || cs.bucket != "b" || cs.prefix != "teams/a" || cs.reclaim != "Delete" {
+		t.Fatalf("volume id round trip: %#v %v", cs, err)
+	}
+
+	data := filepath.Join(dir, "teams/a")
+	if err := os.MkdirAll(data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: r1.Volume.VolumeId}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := os.Stat(data); !os.IsNotExist(err) {
+		t.Fatalf("reclaim=Delete should move data away, stat err=%v", err)
+	}
+	if trashed, _ := filepath.Glob(filepath.Join(dir, ".trash", "*", "teams", "a")); len(trashed) != 1 {
+		t.Fatalf("expected data in trash, got %v", trashed)
+	}
+	if _, err := s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "bogus"}); err != nil {
+		t.Fatalf("delete of unknown id should succeed: %v", err)
+	}
+}
+
+// Stage calls ensure the mounter from gRPC goroutines while the reconcile
+// loop pulls and /status reads; run with -race.
+func TestCSI_ConcurrentImageState(t *testing.T) {
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/create"):
+			_, _ = w.Write([]byte(`{"status":"ok"}`))
+		case strings.Contains(r.URL.Path, "/images/"):
+			_ = json.NewEncoder(w).Encode(map[string]any{"Id": "sha256:mounter"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{MounterImage: "rclone/rclone:latest"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if id := c.cachedImageID(); id != "sha256:mounter" {
+				t.Errorf("image id %q", id)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := c.pullMounterImageIfChanged(); err != nil {
+				t.Errorf("pull: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = c.lastImagePull()
+			_ = c.mounterCreatedTotal()
+		}()
+	}
+	wg.Wait()
+	if c.lastImagePull().IsZero() {
+		t.Fatal("pull not recorded")
+	}
+}
+
+// TestCSI_Sanity walks the controller-side csi-sanity checks over a real gRPC
+// socket, with a stand-in S3 behind AutoCreatePrefix.
+func TestCSI_Sanity(t *testing.T) {
+	var mu sync.Mutex
+	puts := map[string]int{}
+	s3srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodHead:
+			if puts[r.URL.Path] == 0 {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+		case http.MethodPut:
+			puts[r.URL.Path]++
+		}
+	}))
+	defer s3srv.Close()
+	t.Setenv("VOLS3_ACCESS_KEY", "ak")
+	t.Setenv("VOLS3_SECRET_KEY", "sk")
+
+	dir := t.TempDir()
+	c := &Controller{ctx: context.Background(), cfg: Config{
+		Mountpoint: dir, ReadyFile: ".ready", S3Endpoint: s3srv.URL, S3PathStyle: true,
+		AutoCreateBucket: true, AutoCreatePrefix: true,
+	}}
+	sock := filepath.Join(dir, "csi.sock")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer()
+	NewCSIServer(c, "test").Register(g)
+	go func() { _ = g.Serve(lis) }()
+	defer g.Stop()
+	conn, err := grpc.NewClient("unix://"+sock, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ids, ctl := csi.NewIdentityClient(conn), csi.NewControllerClient(conn)
+	ctx := context.Background()
+
+	if info, err := ids.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}); err != nil || info.Name != CSIDriverName {
+		t.Fatalf("plugin info: %v %v", info, err)
+	}
+	if p, err := ids.Probe(ctx, &csi.ProbeRequest{}); err != nil || !p.GetReady().GetValue() {
+		t.Fatalf("probe: %v %v", p, err)
+	}
+
+	caps := mountCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+	params := map[string]string{"bucket": "data", "prefix": "teams/a"}
+	const size = 10 << 30
+	req := &csi.CreateVolumeRequest{Name: "sanity", VolumeCapabilities: caps, Parameters: params,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: size}}
+	v1, err := ctl.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if v1.Volume.CapacityBytes != size {
+		t.Fatalf("capacity: %d", v1.Volume.CapacityBytes)
+	}
+	if puts["/data"] != 1 || puts["/data/teams/a/"] != 1 {
+		t.Fatalf("remote paths not created: %v", puts)
+	}
+	if v2, err := ctl.CreateVolume(ctx, req); err != nil || v2.Volume.VolumeId != v1.Volume.VolumeId {
+		t.Fatalf("create not idempotent: %v %v", v2, err)
+	}
+
+	mismatch := []*csi.CreateVolumeRequest{
+		{Name: "sanity", VolumeCapabilities: caps, Parameters: map[string]string{"bucket": "data", "prefix": "teams/b"}, CapacityRange: req.CapacityRange},
+		{Name: "sanity", VolumeCapabilities: caps, Parameters: params, CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * size}},
+	}
+	for _, m := range mismatch {
+		if _, err := ctl.CreateVolume(ctx, m); status.Code(err) != codes.AlreadyExists {
+			t.Fatalf("create %v: expected AlreadyExists, got %v", m, err)
+		}
+	}
+	// the record survives a restarted server answering on another manager
+	if _, err := NewCSIServer(c, "test").CreateVolume(ctx, mismatch[0]); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("record not shared: %v", err)
+	}
+	bad := &csi.CreateVolumeRequest{Name: "other", VolumeCapabilities: caps, Parameters: params,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2, LimitBytes: 1}}
+	if _, err := ctl.CreateVolume(ctx, bad); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for inverted range, got %v", err)
+	}
+
+	vv, err := ctl.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: v1.Volume.VolumeId, VolumeCapabilities: caps})
+	if err != nil || vv.Confirmed == nil {
+		t.Fatalf("validate: %v %v", vv, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ctl.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: v1.Volume.VolumeId}); err != nil {
+			t.Fatalf("delete %d: %v", i, err)
+		}
+	}
+	// a deleted name may be reused with other parameters
+	if _, err := ctl.CreateVolume(ctx, mismatch[0]); err != nil {
+		t.Fatalf("create after delete: %v", err)
+	}
+}
+
+// TestCSI_NodeSanity walks the node-side calls over gRPC in per_claim mode: a
+// stand-in S3 gets the prefix, the claim's own mounter serves the staging
+// bind, and every mount runs in the host namespace through the helper.
+func TestCSI_NodeSanity(t *testing.T) {
+	var mu sync.Mutex
+	puts := map[string]int{}
+	s3srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+		case http.MethodPut:
+			puts[r.URL.Path]++
+		}
+	}))
+	defer s3srv.Close()
+	t.Setenv("VOLS3_ACCESS_KEY", "ak")
+	t.Setenv("VOLS3_SECRET_KEY", "sk")
+	created := map[string][]string{}
+	var calls []string
+	cli := fakeDocker(t, fakeUpgradeDaemon(created, &calls))
+
+	dir := t.TempDir()
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{
+		Mountpoint: dir, MounterMode: "per_claim", MounterImage: "rclone/rclone", HelperImage: "helper",
+		RcloneRemote: "S3:data", S3Endpoint: s3srv.URL, S3PathStyle: true, AutoCreatePrefix: true,
+	}}
+	claim := filepath.Join(dir, "data", "teams", "a")
+	defer func(f func() ([]byte, error)) { readMountInfo = f }(readMountInfo)
+	readMountInfo = func() ([]byte, error) {
+		return []byte("40 1 0:60 / " + claim + " rw - fuse.rclone S3:data/teams/a rw\n"), nil
+	}
+	srv := NewCSIServer(c, "test")
+	sock := filepath.Join(t.TempDir(), "csi.sock")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer()
+	srv.Register(g)
+	go func() { _ = g.Serve(lis) }()
+	defer g.Stop()
+	conn, err := grpc.NewClient("unix://"+sock, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	node := csi.NewNodeClient(conn)
+	ctx := context.Background()
+
+	if info, err := node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{}); err != nil || info.NodeId != sanitizeHostname() {
+		t.Fatalf("node info: %v %v", info, err)
+	}
+	if nc, err := node.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{}); err != nil || len(nc.Capabilities) != 1 {
+		t.Fatalf("node capabilities: %v %v", nc, err)
+	}
+
+	id := csiVolumeID(claimSpec{bucket: "data", prefix: "teams/a"})
+	capRW := mountCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)[0]
+	staging, target := "/var/lib/csi/staging/v1", "/var/lib/csi/publish/v1"
+	invalid := []error{
+		func() error {
+			_, err := node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: id, VolumeCapability: capRW})
+			return err
+		}(),
+		func() error {
+			_, err := node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{VolumeId: id, StagingTargetPath: staging})
+			return err
+		}(),
+		func() error {
+			_, err := node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: id})
+			return err
+		}(),
+		func() error {
+			_, err := node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{TargetPath: target})
+			return err
+		}(),
+	}
+	for i, err := range invalid {
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("missing argument %d: expected InvalidArgument, got %v", i, err)
+		}
+	}
+	if _, err := node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: "bogus", StagingTargetPath: staging, VolumeCapability: capRW}); status.Code(err) != codes.NotFound {
+		t.Fatalf("stage of unknown volume: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: id, StagingTargetPath: staging, VolumeCapability: capRW}); err != nil {
+			t.Fatalf("stage %d: %v", i, err)
+		}
+	}
+	mounter := created[c.claimMounterName(claimSpec{bucket: "data", prefix: "teams/a"})]
+	if len(mounter) < 3 || mounter[1] != "S3:data/teams/a" || mounter[2] != claim {
+		t.Fatalf("claim mounter: %v", mounter)
+	}
+	if _, ok := created[c.mounterName()]; ok {
+		t.Fatal("shared mounter started in per_claim mode")
+	}
+	mu.Lock()
+	if puts["/data/teams/a/"] == 0 {
+		t.Fatalf("prefix not created on the stand-in S3: %v", puts)
+	}
+	mu.Unlock()
+	bind := created[c.helperName(csiHelper("csi-bind", staging))]
+	if want := []string{"nsenter", "-t", "1", "-m", "--", "sh", "-c", csiBindSh, "sh", claim, staging, "rw"}; !reflect.DeepEqual(bind, want) {
+		t.Fatalf("stage bind: %q", bind)
+	}
+	if n := len(c.nodeClaims()); n != 1 {
+		t.Fatalf("staged claims: %d", n)
+	}
+
+	capRO := mountCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)[0]
+	if _, err := node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{VolumeId: id, StagingTargetPath: staging, TargetPath: target, VolumeCapability: capRO}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if pub := created[c.helperName(csiHelper("csi-bind", target))]; len(pub) != 12 || pub[9] != staging || pub[10] != target || pub[11] != "ro" {
+		t.Fatalf("publish bind: %q", pub)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: id, TargetPath: target}); err != nil {
+			t.Fatalf("unpublish %d: %v", i, err)
+		}
+		if _, err := node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: id, StagingTargetPath: staging}); err != nil {
+			t.Fatalf("unstage %d: %v", i, err)
+		}
+	}
+	if un := created[c.helperName(csiHelper("csi-unbind", target))]; len(un) != 11 || un[9] != target || un[10] != "rmdir" {
+		t.Fatalf("unpublish: %q", un)
+	}
+	if un := created[c.helperName(csiHelper("csi-unbind", staging))]; len(un) != 11 || un[9] != staging || un[10] != "" {
+		t.Fatalf("unstage: %q", un)
+	}
+	if n := len(c.nodeClaims()); n != 0 {
+		t.Fatalf("claims still staged after unstage: %d", n)
+	}
+}
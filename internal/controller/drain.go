@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Drain before removal. With --vfs-cache-mode=writes/full written files sit in
+// the mounter's VFS cache until uploaded, and removing the container drops
+// them. Before a running mounter is removed (image change, spec drift,
+// claim gone, shutdown) it is marked draining, which makes Ready and new
+// plugin/CSI mounts of it fail, and rc vfs/stats is polled until no upload is
+// queued or in progress, or DrainTimeout passes.
+
+// drainHistory bounds the drain results kept for /status.
+const drainHistory = 20
+
+// DrainStatus is the outcome of draining one mounter.
+type DrainStatus struct {
+	Mounter    string
+	Claim      string `json:",omitempty"`
+	Reason     string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Drained counts files uploaded while draining, Abandoned those still
+	// pending when the drain gave up.
+	Drained   int64
+	Abandoned int64
+	TimedOut  bool
+	Error     string `json:",omitempty"`
+}
+
+type drainState struct {
+	mu       sync.Mutex
+	draining map[string]struct{}
+	history  []DrainStatus
+}
+
+// vfsPending returns the number of uploads queued or in progress from a vfs/stats reply.
+func vfsPending(b []byte) (int64, error) {
+	var vs rcVFSStats
+	if err := json.Unmarshal(b, &vs); err != nil {
+		return 0, fmt.Errorf("vfs/stats: %w", err)
+	}
+	if vs.DiskCache == nil {
+		return 0, nil
+	}
+	return vs.DiskCache.UploadsInProgress + vs.DiskCache.UploadsQueued, nil
+}
+
+// isDraining reports whether the named mounter is being drained.
+func (c *Controller) isDraining(name string) bool {
+	c.drain.mu.Lock()
+	defer c.drain.mu.Unlock()
+	_, ok := c.drain.draining[name]
+	return ok
+}
+
+// claimDraining reports whether the mounter serving cs is being drained.
+func (c *Controller) claimDraining(cs claimSpec) bool {
+	if c.perClaimMounters() {
+		return c.isDraining(c.claimMounterName(cs))
+	}
+	return c.isDraining(c.mounterName())
+}
+
+func (c *Controller) setDraining(name string, on bool) {
+	c.drain.mu.Lock()
+	defer c.drain.mu.Unlock()
+	if c.drain.draining == nil {
+		c.drain.draining = map[string]struct{}{}
+	}
+	if on {
+		c.drain.draining[name] = struct{}{}
+	} else {
+		delete(c.drain.draining, name)
+	}
+}
+
+// drainMounter waits for the mounter's pending uploads before it is removed.
+// Without rc or with DrainTimeout <= 0 it returns at once. Cleanup passes a
+// fresh ctx since the controller's own is already cancelled at shutdown.
+func (c *Controller) drainMounter(ctx context.Context, id, name, claim, reason string) {
+	if strings.TrimSpace(c.cfg.RcloneRCAddr) == "" || c.cfg.DrainTimeout <= 0 {
+		return
+	}
+	c.setDraining(name, true)
+	defer c.setDraining(name, false)
+
+	st := DrainStatus{Mounter: name, Claim: claim, Reason: reason, StartedAt: time.Now().UTC()}
+	deadline := time.Now().Add(c.cfg.DrainTimeout)
+	var peak, pending int64
+	for {
+		out, err := c.rcCall(ctx, id, "vfs/stats")
+		if err == nil {
+			pending, err = vfsPending(out)
+		}
+		if err != nil {
+			// nothing more can be learnt; count what was last seen as lost
+			st.Error = err.Error()
+			break
+		}
+		if pending > peak {
+			peak = pending
+		}
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			st.TimedOut = true
+			break
+		}
+		select {
+		case <-ctx.Done():
+			st.Error = ctx.Err().Error()
+		case <-time.After(time.Second):
+		}
+		if st.Error != "" {
+			break
+		}
+	}
+	st.Abandoned = pending
+	st.Drained = peak - pending
+	st.FinishedAt = time.Now().UTC()
+	if st.Abandoned > 0 || st.Error != "" {
+		slog.Warn("mounter drain incomplete", "mounter", name, "reason", reason, "drained", st.Drained,
+			"abandoned", st.Abandoned, "timed_out", st.TimedOut, "error", st.Error)
+	} else {
+		slog.Info("mounter drained", "mounter", name, "reason", reason, "drained", st.Drained,
+			"took", st.FinishedAt.Sub(st.StartedAt).String())
+	}
+	c.drain.mu.Lock()
+	c.drain.history = append(c.drain.history, st)
+	if n := len(c.drain.history); n > drainHistory {
+		c.drain.history = c.drain.history[n-drainHistory:]
+	}
+	c.drain.mu.Unlock()
+}
+
+func (c *Controller) drainSnapshot() (draining []string, history []DrainStatus) {
+	c.drain.mu.Lock()
+	defer c.drain.mu.Unlock()
+	for name := range c.drain.draining {
+		draining = append(draining, name)
+	}
+	return draining, append([]DrainStatus(nil), c.drain.history...)
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVFSPending(t *testing.T) {
+	n, err := vfsPending([]byte(`{"fs":"S3:b","diskCache":{"uploadsInProgress":2,"uploadsQueued":5}}`))
+	if err != nil || n != 7 {
+		t.Fatalf("pending = %d, %v; want 7", n, err)
+	}
+	if n, err := vfsPending([]byte(`{"fs":"S3:b"}`)); err != nil || n != 0 {
+		t.Fatalf("no disk cache: pending = %d, %v", n, err)
+	}
+	if _, err := vfsPending([]byte(`not json`)); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestClaimDraining(t *testing.T) {
+	c := &Controller{cfg: Config{MounterMode: "per_claim"}}
+	a := claimSpec{bucket: "b", prefix: "a"}
+	other := claimSpec{bucket: "b", prefix: "other"}
+	c.setDraining(c.claimMounterName(a), true)
+	if !c.claimDraining(a) || c.claimDraining(other) {
+		t.Fatal("only the drained claim mounter should report draining")
+	}
+	c.setDraining(c.claimMounterName(a), false)
+	if c.claimDraining(a) {
+		t.Fatal("drain flag not cleared")
+	}
+
+	c.cfg.MounterMode = ""
+	c.setDraining(c.mounterName(), true)
+	if !c.claimDraining(a) || !c.claimDraining(other) {
+		t.Fatal("draining the shared mounter affects every claim")
+	}
+}
+
+func TestDrainMounterDisabled(t *testing.T) {
+	// without rc there is nothing to poll: no record, no draining flag left behind
+	c := &Controller{cfg: Config{DrainTimeout: 0, RcloneRCAddr: "127.0.0.1:5572"}}
+	c.drainMounter(context.Background(), "id", "m", "", "test")
+	if draining, hist := c.drainSnapshot(); len(draining) != 0 || len(hist) != 0 {
+		t.Fatalf("unexpected drain state %v %v", draining, hist)
+	}
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint failover without HAProxy. When VOLS3_ENDPOINTS lists endpoints the
+// controller probes each of them and resolveEndpointForMounter returns the
+// selection; a change of selection changes the mounter env, which
+// ensureMounterSpec detects as spec drift, which recreates the mounter on the new endpoint.
+//
+// The selection is sticky: it only moves when the selected endpoint fails a
+// probe, and then to the healthy endpoint with the lowest latency.
+
+// EndpointStatus is the last probe result of one endpoint.
+type EndpointStatus struct {
+	URL       string
+	Healthy   bool
+	LatencyMs int64
+	Selected  bool
+	LastError string `json:",omitempty"`
+	LastCheck time.Time
+}
+
+// endpointList parses cfg.MinioEndpointsCSV.
+func (c *Controller) endpointList() []string {
+	var out []string
+	for _, e := range strings.Split(c.cfg.MinioEndpointsCSV, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, strings.TrimSuffix(e, "/"))
+		}
+	}
+	return out
+}
+
+// probeEndpoint reports whether ep answers. With a health path a 2xx is
+// required; otherwise any non-5xx reply (S3 answers 403 anonymously) counts.
+func (c *Controller) probeEndpoint(ctx context.Context, ep string) (time.Duration, error) {
+	target := ep + c.cfg.EndpointHealthPath
+	if c.cfg.EndpointHealthPath == "" {
+		target = ep + "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	rtt := time.Since(start)
+	if resp.StatusCode >= 500 || (c.cfg.EndpointHealthPath != "" && resp.StatusCode/100 != 2) {
+		return rtt, &endpointStatusError{code: resp.StatusCode}
+	}
+	return rtt, nil
+}
+
+type endpointStatusError struct{ code int }
+
+func (e *endpointStatusError) Error() string { return fmt.Sprintf("unhealthy status %d", e.code) }
+
+// checkEndpoints probes all endpoints concurrently and updates the selection.
+// It returns true when the selected endpoint changed.
+func (c *Controller) checkEndpoints() bool {
+	list := c.endpointList()
+	if len(list) == 0 {
+		return false
+	}
+	results := make([]EndpointStatus, len(list))
+	var wg sync.WaitGroup
+	for i, ep := range list {
+		wg.Add(1)
+		go func(i int, ep string) {
+			defer wg.Done()
+			ctx, cancel := c.timeoutCtx(3 * time.Second)
+			defer cancel()
+			rtt, err := c.probeEndpoint(ctx, ep)
+			st := EndpointStatus{URL: ep, Healthy: err == nil, LatencyMs: rtt.Milliseconds(), LastCheck: time.Now().UTC()}
+			if err != nil {
+				st.LastError = err.Error()
+			}
+			results[i] = st
+		}(i, ep)
+	}
+	wg.Wait()
+
+	c.endpointMu.Lock()
+	defer c.endpointMu.Unlock()
+	prev := c.selectedEndpoint
+	sel := ""
+	for _, st := range results {
+		if st.Healthy && st.URL == prev {
+			sel = prev
+		}
+	}
+	if sel == "" {
+		var best *EndpointStatus
+		for i := range results {
+			if results[i].Healthy && (best == nil || results[i].LatencyMs < best.LatencyMs) {
+				best = &results[i]
+			}
+		}
+		if best != nil {
+			sel = best.URL
+		}
+	}
+	// with nothing reachable keep the previous choice rather than flapping
+	if sel == "" {
+		sel = prev
+	}
+	for i := range results {
+		results[i].Selected = results[i].URL == sel
+	}
+	c.endpoints = results
+	c.selectedEndpoint = sel
+	if sel != prev {
+		slog.Warn("endpoint selection changed", "from", prev, "to", sel)
+		return true
+	}
+	return false
+}
+
+// runEndpointChecks probes endpoints until shutdown and nudges a reconcile when
+// the selection changes.
+func (c *Controller) runEndpointChecks() {
+	every := c.cfg.EndpointCheckInterval
+	if every <= 0 {
+		every = 10 * time.Second
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-t.C:
+			if c.checkEndpoints() {
+				c.Nudge()
+			}
+		}
+	}
+}
+
+// currentEndpoint returns the selected endpoint, or "" without VOLS3_ENDPOINTS.
+func (c *Controller) currentEndpoint() string {
+	c.endpointMu.Lock()
+	defer c.endpointMu.Unlock()
+	return c.selectedEndpoint
+}
+
+func (c *Controller) endpointsSnapshot() []EndpointStatus {
+	c.endpointMu.Lock()
+	defer c.endpointMu.Unlock()
+	return append([]EndpointStatus(nil), c.endpoints...)
+}
+
+func validEndpointURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
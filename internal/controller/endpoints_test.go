@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckEndpoints_Failover(t *testing.T) {
+	var downA atomic.Bool
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if downA.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden) // anonymous S3 reply still means reachable
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer b.Close()
+
+	c := &Controller{ctx: context.Background(), cfg: Config{S3Endpoint: "http://fallback:9000", MinioEndpointsCSV: a.URL + ", " + b.URL}}
+	if got := c.resolveEndpointForMounter(); got != "http://fallback:9000" {
+		t.Fatalf("before probing: %s", got)
+	}
+	if !c.checkEndpoints() {
+		t.Fatal("first probe should select an endpoint")
+	}
+	first := c.resolveEndpointForMounter()
+	if first != a.URL && first != b.URL {
+		t.Fatalf("unexpected selection %s", first)
+	}
+	// sticky while healthy
+	if c.checkEndpoints() || c.resolveEndpointForMounter() != first {
+		t.Fatal("selection moved although the endpoint is healthy")
+	}
+
+	downA.Store(true)
+	c.checkEndpoints()
+	if got := c.resolveEndpointForMounter(); got != b.URL {
+		t.Fatalf("expected failover to %s, got %s", b.URL, got)
+	}
+	s := c.Snapshot()
+	if len(s.Endpoints) != 2 || s.Endpoints[0].Healthy || !s.Endpoints[1].Selected || s.SelectedEndpoint != b.URL {
+		t.Fatalf("unexpected snapshot: %+v", s.Endpoints)
+	}
+}
@@ -0,0 +1,366 @@
+package controller
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// HAProxy engine (VOLS3_PROXY_ENGINE=haproxy). The controller renders
+// haproxy.cfg from Config, validates it with `haproxy -c` and runs HAProxy as a
+// child process. Weight and drain changes are applied live through the runtime
+// socket; any other change is written and picked up by a graceful reload
+// (a new process started with -sf <old pid>).
+
+const (
+	haproxyBackend   = "s3_upstream"
+	haproxyTemplates = 8 // server-template slots per service
+	// SIGUSR1 is HAProxy's soft stop: finish in-flight requests, then exit
+	haproxySoftStop = syscall.SIGUSR1
+)
+
+type haproxyService struct {
+	Name   string
+	ID     string // sanitized for server names
+	Backup bool
+	Weight int
+	Drain  bool
+}
+
+// haproxyConfig is the typed input of the generated config.
+type haproxyConfig struct {
+	Port           string
+	Balance        string
+	HealthPath     string
+	BackendPort    string
+	TimeoutConnect time.Duration
+	TimeoutClient  time.Duration
+	TimeoutServer  time.Duration
+	Retries        int
+	Socket         string
+	Services       []haproxyService
+}
+
+type haproxyManager struct {
+	mu      sync.Mutex
+	applied *haproxyConfig
+	text    string
+	cmd     *exec.Cmd
+	exited  chan struct{}
+}
+
+func (c *Controller) haproxyEnabled() bool {
+	return c.cfg.EnableProxy && (c.cfg.ProxyEngine == "" || c.cfg.ProxyEngine == "haproxy")
+}
+
+func haproxyServerID(svc string) string {
+	var b strings.Builder
+	for _, r := range svc {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// desiredHAProxyConfig derives the typed config from Config.
+func (c *Controller) desiredHAProxyConfig() *haproxyConfig {
+	drain := map[string]bool{}
+	for _, s := range strings.Split(c.cfg.ProxyDrainServices, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			drain[s] = true
+		}
+	}
+	hc := &haproxyConfig{
+		Port:           strings.TrimSpace(c.cfg.ProxyPort),
+		Balance:        c.cfg.ProxyBalance,
+		HealthPath:     c.cfg.ProxyHealthPath,
+		BackendPort:    c.cfg.ProxyBackendPort,
+		TimeoutConnect: c.cfg.ProxyTimeoutConnect,
+		TimeoutClient:  c.cfg.ProxyTimeoutClient,
+		TimeoutServer:  c.cfg.ProxyTimeoutServer,
+		Retries:        c.cfg.ProxyRetries,
+		Socket:         c.cfg.HAProxySocket,
+	}
+	if hc.Balance == "" {
+		hc.Balance = "leastconn"
+	}
+	for _, s := range strings.Split(c.cfg.ProxyLocalServices, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			hc.Services = append(hc.Services, haproxyService{Name: s, ID: "loc_" + haproxyServerID(s), Weight: c.cfg.ProxyLocalWeight, Drain: drain[s]})
+		}
+	}
+	if s := strings.TrimSpace(c.cfg.ProxyRemoteService); s != "" {
+		hc.Services = append(hc.Services, haproxyService{Name: s, ID: "rmt", Backup: true, Weight: c.cfg.ProxyRemoteWeight, Drain: drain[s]})
+	}
+	return hc
+}
+
+func haproxyDuration(d time.Duration, def string) string {
+	if d <= 0 {
+		return def
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "ms"
+}
+
+// renderHAProxyConfig produces haproxy.cfg. Drained services get weight 0 so a
+// restart keeps them out of rotation.
+func renderHAProxyConfig(hc *haproxyConfig) string {
+	var b strings.Builder
+	b.WriteString("# generated by volume-ops; do not edit\n")
+	b.WriteString("global\n  log stdout format raw local0\n  tune.bufsize 32768\n")
+	if hc.Socket != "" {
+		fmt.Fprintf(&b, "  stats socket %s mode 600 level admin expose-fd listeners\n", hc.Socket)
+	}
+	b.WriteString("\ndefaults\n  mode http\n  option  httplog\n  option  http-keep-alive\n  http-reuse safe\n")
+	fmt.Fprintf(&b, "  timeout connect %s\n", haproxyDuration(hc.TimeoutConnect, "2s"))
+	fmt.Fprintf(&b, "  timeout client  %s\n", haproxyDuration(hc.TimeoutClient, "60s"))
+	fmt.Fprintf(&b, "  timeout server  %s\n", haproxyDuration(hc.TimeoutServer, "60s"))
+	fmt.Fprintf(&b, "  retries %d\n", hc.Retries)
+	b.WriteString("\nresolvers docker\n  nameserver dns 127.0.0.11:53\n  resolve_retries 3\n  timeout retry 1s\n  hold valid 10s\n")
+	fmt.Fprintf(&b, "\nfrontend s3_in\n  bind :%s\n  default_backend %s\n", hc.Port, haproxyBackend)
+	fmt.Fprintf(&b, "\nbackend %s\n  balance %s\n", haproxyBackend, hc.Balance)
+	fmt.Fprintf(&b, "  option httpchk GET %s\n  http-check expect status 200-399\n", hc.HealthPath)
+	b.WriteString("  default-server inter 2s fastinter 500ms downinter 5s fall 3 rise 2 slowstart 5s maxconn 500\n")
+	for _, s := range hc.Services {
+		w := s.Weight
+		if s.Drain {
+			w = 0
+		}
+		backup := ""
+		if s.Backup {
+			backup = " backup"
+		}
+		fmt.Fprintf(&b, "  server-template %s 1-%d tasks.%s:%s resolvers docker resolve-prefer ipv4 init-addr none%s weight %d\n",
+			s.ID, haproxyTemplates, s.Name, hc.BackendPort, backup, w)
+	}
+	return b.String()
+}
+
+// haproxyRuntimeOnly reports whether next differs from prev only in weights
+// and drain state, which the runtime API can apply without a reload.
+func haproxyRuntimeOnly(prev, next *haproxyConfig) bool {
+	if prev == nil || len(prev.Services) != len(next.Services) {
+		return false
+	}
+	strip := func(hc *haproxyConfig) string {
+		cp := *hc
+		cp.Services = make([]haproxyService, len(hc.Services))
+		for i, s := range hc.Services {
+			s.Weight, s.Drain = 0, false
+			cp.Services[i] = s
+		}
+		return renderHAProxyConfig(&cp)
+	}
+	return strip(prev) == strip(next)
+}
+
+// haproxyRuntimeCommands lists the runtime API commands turning prev into next.
+func haproxyRuntimeCommands(prev, next *haproxyConfig) []string {
+	var cmds []string
+	for i, s := range next.Services {
+		p := prev.Services[i]
+		for n := 1; n <= haproxyTemplates; n++ {
+			srv := fmt.Sprintf("%s/%s%d", haproxyBackend, s.ID, n)
+			if s.Weight != p.Weight {
+				cmds = append(cmds, fmt.Sprintf("set server %s weight %d", srv, s.Weight))
+			}
+			if s.Drain != p.Drain {
+				state := "ready"
+				if s.Drain {
+					state = "drain"
+				}
+				cmds = append(cmds, fmt.Sprintf("set server %s state %s", srv, state))
+			}
+		}
+	}
+	return cmds
+}
+
+// haproxyRuntime sends commands to the runtime socket, one connection each.
+func haproxyRuntime(socket string, cmds []string) error {
+	for _, cmd := range cmds {
+		conn, err := net.DialTimeout("unix", socket, 2*time.Second)
+		if err != nil {
+			return err
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(cmd + "\n")); err != nil {
+			_ = conn.Close()
+			return err
+		}
+		var out strings.Builder
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			out.WriteString(sc.Text())
+		}
+		_ = conn.Close()
+		// successful set commands answer with an empty line
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("haproxy runtime %q: %s", cmd, msg)
+		}
+	}
+	return nil
+}
+
+func (c *Controller) haproxyBinary() string {
+	if b := strings.TrimSpace(c.cfg.HAProxyBinary); b != "" {
+		return b
+	}
+	return "haproxy"
+}
+
+// validateHAProxyConfig runs `haproxy -c` on a config file.
+func (c *Controller) validateHAProxyConfig(path string) error {
+	out, err := exec.Command(c.haproxyBinary(), "-c", "-q", "-f", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("haproxy -c: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// reconcileHAProxy renders the config and applies it: runtime API for
+// weight/drain-only changes, otherwise validate, write and (re)start.
+func (c *Controller) reconcileHAProxy() error {
+	if !c.haproxyEnabled() {
+		return nil
+	}
+	m := &c.haproxy
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	next := c.desiredHAProxyConfig()
+	text := renderHAProxyConfig(next)
+	running := m.cmd != nil && !isClosed(m.exited)
+
+	if running && text == m.text {
+		return nil
+	}
+	if running && haproxyRuntimeOnly(m.applied, next) {
+		err := haproxyRuntime(next.Socket, haproxyRuntimeCommands(m.applied, next))
+		if err == nil {
+			if err := c.writeHAProxyConfig(text); err != nil {
+				return err
+			}
+			m.applied, m.text = next, text
+			slog.Info("haproxy runtime update applied")
+			return nil
+		}
+		slog.Warn("haproxy runtime update failed, reloading", "error", err)
+	}
+	if err := c.writeHAProxyConfig(text); err != nil {
+		return err
+	}
+	if err := c.startHAProxy(m); err != nil {
+		return err
+	}
+	m.applied, m.text = next, text
+	return nil
+}
+
+// writeHAProxyConfig validates text in a temp file and moves it into place.
+func (c *Controller) writeHAProxyConfig(text string) error {
+	path := c.cfg.HAProxyConfigFile
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, []byte(text), 0o644); err != nil {
+		return err
+	}
+	if err := c.validateHAProxyConfig(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// haproxyArgs builds the command line. A reload (oldPid > 0) takes the
+// listening sockets over from the old process through its stats socket (-x,
+// served by "expose-fd listeners") so no connection is refused meanwhile.
+func haproxyArgs(configFile string, oldPid int, oldSocket string) []string {
+	args := []string{"-f", configFile, "-db"}
+	if oldPid > 0 {
+		if oldSocket != "" {
+			args = append(args, "-x", oldSocket)
+		}
+		args = append(args, "-sf", strconv.Itoa(oldPid))
+	}
+	return args
+}
+
+// startHAProxy starts HAProxy, or replaces the running one gracefully (-sf).
+// Callers must hold m.mu.
+func (c *Controller) startHAProxy(m *haproxyManager) error {
+	old := m.cmd
+	oldPid, oldSocket := 0, ""
+	if old != nil && !isClosed(m.exited) {
+		oldPid = old.Process.Pid
+		if m.applied != nil {
+			oldSocket = m.applied.Socket
+		}
+	}
+	args := haproxyArgs(c.cfg.HAProxyConfigFile, oldPid, oldSocket)
+	cmd := exec.Command(c.haproxyBinary(), args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start haproxy: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		slog.Info("haproxy exited", "pid", cmd.Process.Pid, "error", err)
+	}()
+	if old != nil {
+		slog.Info("haproxy reloaded", "pid", cmd.Process.Pid, "old_pid", old.Process.Pid)
+	} else {
+		slog.Info("haproxy started", "pid", cmd.Process.Pid)
+	}
+	m.cmd, m.exited = cmd, exited
+	return nil
+}
+
+// stopHAProxy terminates the child process on shutdown.
+func (c *Controller) stopHAProxy() {
+	m := &c.haproxy
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cmd != nil && !isClosed(m.exited) {
+		_ = m.cmd.Process.Signal(haproxySoftStop)
+		select {
+		case <-m.exited:
+		case <-time.After(10 * time.Second):
+			_ = m.cmd.Process.Kill()
+		}
+	}
+}
+
+// HAProxyConfig returns the last applied generated config ("" when unused).
+func (c *Controller) HAProxyConfig() string {
+	c.haproxy.mu.Lock()
+	defer c.haproxy.mu.Unlock()
+	return c.haproxy.text
+}
+
+func isClosed(ch chan struct{}) bool {
+	if ch == nil {
+		return true
+	}
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testHAProxyController() *Controller {
+	return &Controller{cfg: Config{
+		EnableProxy:         true,
+		ProxyEngine:         "haproxy",
+		ProxyPort:           "8081",
+		ProxyLocalServices:  "minio-a, minio.b",
+		ProxyRemoteService:  "minio-remote",
+		ProxyBackendPort:    "9000",
+		ProxyHealthPath:     "/minio/health/ready",
+		ProxyBalance:        "leastconn",
+		ProxyTimeoutConnect: 2 * time.Second,
+		ProxyRetries:        2,
+		ProxyLocalWeight:    100,
+		ProxyRemoteWeight:   10,
+		HAProxySocket:       "/run/haproxy.sock",
+	}}
+}
+
+func TestRenderHAProxyConfig(t *testing.T) {
+	c := testHAProxyController()
+	c.cfg.ProxyDrainServices = "minio.b"
+	text := renderHAProxyConfig(c.desiredHAProxyConfig())
+	for _, want := range []string{
+		"stats socket /run/haproxy.sock mode 600 level admin",
+		"bind :8081",
+		"balance leastconn",
+		"option httpchk GET /minio/health/ready",
+		"timeout connect 2000ms",
+		"timeout client  60s",
+		"server-template loc_minio-a 1-8 tasks.minio-a:9000 resolvers docker resolve-prefer ipv4 init-addr none weight 100\n",
+		"server-template loc_minio_b 1-8 tasks.minio.b:9000 resolvers docker resolve-prefer ipv4 init-addr none weight 0\n",
+		"server-template rmt 1-8 tasks.minio-remote:9000 resolvers docker resolve-prefer ipv4 init-addr none backup weight 10\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("config missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestHAProxyArgs(t *testing.T) {
+	if got := haproxyArgs("/etc/haproxy.cfg", 0, "/run/haproxy.sock"); !reflect.DeepEqual(got, []string{"-f", "/etc/haproxy.cfg", "-db"}) {
+		t.Fatalf("start: %v", got)
+	}
+	// reload hands the listeners over before stopping the old process
+	want := []string{"-f", "/etc/haproxy.cfg", "-db", "-x", "/run/haproxy.sock", "-sf", "42"}
+	if got := haproxyArgs("/etc/haproxy.cfg", 42, "/run/haproxy.sock"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("reload: %v", got)
+	}
+}
+
+func TestHAProxyRuntimeOnly(t *testing.T) {
+	c := testHAProxyController()
+	prev := c.desiredHAProxyConfig()
+
+	c.cfg.ProxyLocalWeight = 50
+	c.cfg.ProxyDrainServices = "minio-remote"
+	next := c.desiredHAProxyConfig()
+	if !haproxyRuntimeOnly(prev, next) {
+		t.Fatal("weight/drain change should be runtime-only")
+	}
+	cmds := haproxyRuntimeCommands(prev, next)
+	if len(cmds) != 3*haproxyTemplates {
+		t.Fatalf("got %d commands, want %d: %v", len(cmds), 3*haproxyTemplates, cmds)
+	}
+	for _, want := range []string{
+		"set server s3_upstream/loc_minio-a1 weight 50",
+		"set server s3_upstream/loc_minio_b8 weight 50",
+		"set server s3_upstream/rmt3 state drain",
+	} {
+		found := false
+		for _, cmd := range cmds {
+			found = found || cmd == want
+		}
+		if !found {
+			t.Errorf("missing command %q", want)
+		}
+	}
+
+	c.cfg.ProxyBalance = "roundrobin"
+	if haproxyRuntimeOnly(next, c.desiredHAProxyConfig()) {
+		t.Fatal("balance change must reload")
+	}
+	c.cfg.ProxyLocalServices = "minio-a"
+	if haproxyRuntimeOnly(next, c.desiredHAProxyConfig()) {
+		t.Fatal("service change must reload")
+	}
+	if haproxyRuntimeOnly(nil, next) {
+		t.Fatal("nothing applied yet must start")
+	}
+}
+
+func TestHAProxyRuntime(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "haproxy.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			line = strings.TrimSpace(line)
+			got <- line
+			if strings.Contains(line, "nosuch") {
+				_, _ = conn.Write([]byte("No such server.\n\n"))
+			} else {
+				_, _ = conn.Write([]byte("\n"))
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	cmds := []string{"set server s3_upstream/rmt1 weight 5", "set server s3_upstream/rmt1 state drain"}
+	if err := haproxyRuntime(sock, cmds); err != nil {
+		t.Fatal(err)
+	}
+	if recv := []string{<-got, <-got}; !reflect.DeepEqual(recv, cmds) {
+		t.Fatalf("socket received %v", recv)
+	}
+	err = haproxyRuntime(sock, []string{"set server s3_upstream/nosuch1 weight 5"})
+	if err == nil || !strings.Contains(err.Error(), "No such server") {
+		t.Fatalf("expected runtime error, got %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Lazy mounting (LazyMount, shared mounter only). The mounter is created once
+// this node has demand for it: a discovered claim (a labelled container or,
+// with node-aware discovery, a service task scheduled here), a container
+// binding a path under the mountpoint (plugin volumes, hand-written binds) or
+// a bind of the mount in the controller itself (CSI staging). Container and
+// service events from the Docker event stream trigger the reconcile that sees
+// new demand. After IdleUnmountAfter without demand the mounter goes through
+// the drain path, is removed and the mountpoint unmounted. Transitions are
+// kept for /status.
+
+// lazyHistory bounds the transitions kept for /status.
+const lazyHistory = 20
+
+// LazyMountStatus reports the on-demand shared mounter in /status.
+type LazyMountStatus struct {
+	State        string // unmounted | mounted | idle (mounted, no demand)
+	Demand       int
+	IdleSince    time.Time `json:",omitempty"`
+	UnmountAfter time.Time `json:",omitempty"`
+	Error        string    `json:",omitempty"`
+	Transitions  []LazyTransition
+}
+
+// LazyTransition is one state change of the on-demand mounter.
+type LazyTransition struct {
+	At     time.Time
+	From   string
+	To     string
+	Reason string
+}
+
+type lazyState struct {
+	mu          sync.Mutex
+	state       string
+	demand      int
+	idleSince   time.Time
+	lastErr     string
+	transitions []LazyTransition
+}
+
+func (c *Controller) lazyMount() bool {
+	return c.cfg.LazyMount && !c.perClaimMounters()
+}
+
+// lazyUnmounted reports whether the on-demand mounter is currently down, in
+// which case the mountpoint is a plain directory that must not be written.
+func (c *Controller) lazyUnmounted() bool {
+	if !c.lazyMount() {
+		return false
+	}
+	c.lazy.mu.Lock()
+	defer c.lazy.mu.Unlock()
+	return c.lazy.state == "" || c.lazy.state == "unmounted"
+}
+
+func (c *Controller) setLazyState(to, reason string) {
+	c.lazy.mu.Lock()
+	defer c.lazy.mu.Unlock()
+	from := c.lazy.state
+	if from == "" {
+		from = "unmounted"
+	}
+	c.lazy.state = to
+	if from == to {
+		return
+	}
+	slog.Info("lazy mount", "from", from, "to", to, "reason", reason)
+	c.lazy.transitions = append(c.lazy.transitions, LazyTransition{At: time.Now().UTC(), From: from, To: to, Reason: reason})
+	if n := len(c.lazy.transitions); n > lazyHistory {
+		c.lazy.transitions = c.lazy.transitions[n-lazyHistory:]
+	}
+}
+
+// underPath reports whether p is root or below it.
+func underPath(p, root string) bool {
+	root = strings.TrimRight(filepath.Clean(root), "/")
+	p = filepath.Clean(p)
+	return root != "" && (p == root || strings.HasPrefix(p, root+"/"))
+}
+
+// countSubBinds counts the mounts in mountinfo data that show a part of the
+// filesystem mounted at path elsewhere (bind mounts share its device).
+func countSubBinds(data, path string) int {
+	me, ok := parseMountInfo(data, path)
+	if !ok {
+		return 0
+	}
+	return countDevMounts(data, me.dev, path)
+}
+
+// countDevMounts counts the mounts of device dev, except the one at except.
+func countDevMounts(data, dev, except string) int {
+	n := 0
+	for _, ln := range strings.Split(data, "\n") {
+		fields := strings.Fields(ln)
+		if len(fields) >= 5 && fields[2] == dev && fields[4] != except {
+			n++
+		}
+	}
+	return n
+}
+
+// mountDemand counts what needs the shared mount on this node.
+func (c *Controller) mountDemand(specs []claimSpec) (int, error) {
+	n := 0
+	for _, s := range specs {
+		if s.enabled && s.prefix != "" && s.err == nil {
+			n++
+		}
+	}
+	conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{})
+	if err != nil {
+		return n, err
+	}
+	for _, ct := range conts {
+		if ct.Labels["swarmnative.mounter"] == "managed" {
+			continue
+		}
+		for _, m := range ct.Mounts {
+			if underPath(m.Source, c.cfg.Mountpoint) || (c.cfg.ReadOnlyMountRoot != "" && underPath(m.Source, c.cfg.ReadOnlyMountRoot)) {
+				n++
+				break
+			}
+		}
+	}
+	if b, err := os.ReadFile("/proc/self/mountinfo"); err == nil {
+		n += countSubBinds(string(b), filepath.Clean(c.cfg.Mountpoint))
+	}
+	return n, nil
+}
+
+// sharedMounterID returns the ID of the running shared mounter ("" when none).
+func (c *Controller) sharedMounterID() string {
+	args := filters.NewArgs()
+	args.Add("name", "^/"+c.mounterName()+"$")
+	args.Add("status", "running")
+	ctx, cancel := c.timeoutCtx(10 * time.Second)
+	defer cancel()
+	conts, err := c.cli.ContainerList(ctx, container.ListOptions{Filters: args})
+	if err != nil || len(conts) == 0 {
+		return ""
+	}
+	return conts[0].ID
+}
+
+// reconcileLazyMounter mounts on demand and unmounts after the idle period.
+// It reports whether the shared mount is up for the rest of the reconcile.
+func (c *Controller) reconcileLazyMounter(specs []claimSpec) (bool, error) {
+	demand, err := c.mountDemand(specs)
+	if err != nil {
+		// without a complete view keep whatever is there
+		slog.Warn("lazy mount demand", "error", err)
+		return !c.lazyUnmounted(), nil
+	}
+	running := c.sharedMounterID() != ""
+	now := time.Now().UTC()
+	c.lazy.mu.Lock()
+	c.lazy.demand = demand
+	if demand > 0 {
+		c.lazy.idleSince = time.Time{}
+	} else if running && c.lazy.idleSince.IsZero() {
+		c.lazy.idleSince = now
+	}
+	idleSince := c.lazy.idleSince
+	c.lazy.mu.Unlock()
+
+	switch {
+	case demand > 0:
+		err := c.ensureMounter()
+		c.lazy.mu.Lock()
+		c.lazy.lastErr = ""
+		if err != nil {
+			c.lazy.lastErr = err.Error()
+		}
+		c.lazy.mu.Unlock()
+		if err != nil {
+			return false, err
+		}
+		c.setLazyState("mounted", fmt.Sprintf("demand %d", demand))
+		return true, nil
+	case !running:
+		c.lazy.mu.Lock()
+		c.lazy.idleSince = time.Time{}
+		c.lazy.mu.Unlock()
+		c.setLazyState("unmounted", "no demand")
+		return false, nil
+	case now.Sub(idleSince) < c.cfg.IdleUnmountAfter:
+		c.setLazyState("idle", "no claiming containers")
+		return true, nil
+	}
+	if err := c.lazyUnmount(); err != nil {
+		c.lazy.mu.Lock()
+		c.lazy.lastErr = err.Error()
+		c.lazy.mu.Unlock()
+		return true, err
+	}
+	c.lazy.mu.Lock()
+	c.lazy.idleSince = time.Time{}
+	c.lazy.mu.Unlock()
+	c.setLazyState("unmounted", "idle for "+now.Sub(idleSince).Round(time.Second).String())
+	return false, nil
+}
+
+// lazyMountFor brings the on-demand mounter up for a request outside the
+// reconcile loop and waits for the FUSE mount: until then the mountpoint is
+// a local directory that passes every probe.
+func (c *Controller) lazyMountFor(reason string) error {
+	err := c.ensureMounter()
+	if err == nil {
+		err = c.waitMounted(c.cfg.Mountpoint, claimMountTimeout)
+	}
+	c.lazy.mu.Lock()
+	c.lazy.lastErr = ""
+	if err != nil {
+		c.lazy.lastErr = err.Error()
+	} else {
+		// the idle period starts over
+		c.lazy.idleSince = time.Time{}
+	}
+	c.lazy.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.setLazyState("mounted", reason)
+	return nil
+}
+
+// lazyUnmount drains and removes the shared mounter and unmounts the mountpoint.
+func (c *Controller) lazyUnmount() error {
+	c.mounterMu.Lock()
+	defer c.mounterMu.Unlock()
+	name := c.mounterName()
+	if id := c.sharedMounterID(); id != "" {
+		c.drainMounter(c.ctx, id, name, "", "idle")
+		c.releaseReadOnlyBinds(nil)
+		rctx, rcancel := c.timeoutCtx(10 * time.Second)
+		err := c.cli.ContainerRemove(rctx, id, container.RemoveOptions{Force: true})
+		rcancel()
+		if err != nil {
+			return fmt.Errorf("remove idle mounter: %w", err)
+		}
+	}
+	return c.unmountIfMounted(c.cfg.Mountpoint)
+}
+
+func (c *Controller) lazyMountStatus() *LazyMountStatus {
+	if !c.lazyMount() {
+		return nil
+	}
+	c.lazy.mu.Lock()
+	defer c.lazy.mu.Unlock()
+	st := &LazyMountStatus{
+		State:       c.lazy.state,
+		Demand:      c.lazy.demand,
+		IdleSince:   c.lazy.idleSince,
+		Error:       c.lazy.lastErr,
+		Transitions: append([]LazyTransition(nil), c.lazy.transitions...),
+	}
+	if st.State == "" {
+		st.State = "unmounted"
+	}
+	if !st.IdleSince.IsZero() {
+		st.UnmountAfter = st.IdleSince.Add(c.cfg.IdleUnmountAfter)
+	}
+	return st
+}
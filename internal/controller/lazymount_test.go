@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestCountSubBinds(t *testing.T) {
+	info := `22 1 0:52 / /mnt/s3 rw,relatime shared:1 - fuse.rclone S3:data rw
+23 1 0:52 /teams/a /run/csi/staging/vol1 rw,relatime shared:1 - fuse.rclone S3:data rw
+24 1 8:1 / /var/lib/docker rw - ext4 /dev/sda1 rw
+25 1 0:52 /teams/a /run/csi/publish/vol1 rw,relatime shared:1 - fuse.rclone S3:data rw`
+	if n := countSubBinds(info, "/mnt/s3"); n != 2 {
+		t.Fatalf("sub binds: %d", n)
+	}
+	if n := countSubBinds(info, "/mnt/other"); n != 0 {
+		t.Fatalf("unmounted path: %d", n)
+	}
+	if !underPath("/mnt/s3/teams/a", "/mnt/s3/") || underPath("/mnt/s3-ro/a", "/mnt/s3") {
+		t.Fatal("underPath")
+	}
+}
+
+func TestLazyMounterIdleUnmount(t *testing.T) {
+	// fake daemon: a running shared mounter and one app container whose bind
+	// goes away halfway
+	var mu sync.Mutex
+	mounter, appBind := true, true
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/containers/m1"):
+			mounter = false
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			out := []types.Container{}
+			if strings.Contains(r.URL.Query().Get("filters"), "rclone-mounter-") {
+				if mounter {
+					out = append(out, types.Container{ID: "m1", Names: []string{"/rclone-mounter-" + sanitizeHostname()}, State: "running"})
+				}
+			} else if appBind {
+				out = append(out, types.Container{ID: "app", Mounts: []types.MountPoint{{Type: "bind", Source: "/mnt/s3/teams/a"}}})
+			}
+			_ = json.NewEncoder(w).Encode(out)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{LazyMount: true, IdleUnmountAfter: time.Minute, Mountpoint: "/mnt/s3"}}
+
+	if n, err := c.mountDemand(nil); err != nil || n != 1 {
+		t.Fatalf("demand: %d %v", n, err)
+	}
+	mu.Lock()
+	appBind = false
+	mu.Unlock()
+	if up, err := c.reconcileLazyMounter(nil); err != nil || !up {
+		t.Fatalf("first idle reconcile: up=%v err=%v", up, err)
+	}
+	if st := c.lazyMountStatus(); st.State != "idle" || st.IdleSince.IsZero() || st.Demand != 0 {
+		t.Fatalf("idle status: %+v", st)
+	}
+	// pretend the idle period passed
+	c.lazy.mu.Lock()
+	c.lazy.idleSince = time.Now().Add(-2 * time.Minute)
+	c.lazy.mu.Unlock()
+	if up, err := c.reconcileLazyMounter(nil); err != nil || up {
+		t.Fatalf("idle unmount: up=%v err=%v", up, err)
+	}
+	st := c.lazyMountStatus()
+	if st.State != "unmounted" || mounter || !c.lazyUnmounted() {
+		t.Fatalf("after unmount: %+v mounter=%v", st, mounter)
+	}
+	if n := len(st.Transitions); n != 2 || st.Transitions[0].To != "idle" || st.Transitions[1].From != "idle" || !strings.HasPrefix(st.Transitions[1].Reason, "idle for") {
+		t.Fatalf("transitions: %+v", st.Transitions)
+	}
+}
+
+func TestVolumePlugin_LazyMounterDown(t *testing.T) {
+	created := map[string][]string{}
+	var calls []string
+	var mu sync.Mutex
+	up := false
+	daemon := fakeUpgradeDaemon(created, &calls)
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/containers/create") && strings.HasPrefix(r.URL.Query().Get("name"), "rclone-mounter-") {
+			mu.Lock()
+			up = true
+			mu.Unlock()
+		}
+		daemon(w, r)
+	})
+	dir := t.TempDir()
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{
+		LazyMount: true, IdleUnmountAfter: time.Minute, Mountpoint: dir,
+		MounterImage: "rclone/rclone", HelperImage: "helper", RcloneRemote: "S3:b",
+	}}
+	// the mountpoint shows the FUSE mount once the mounter is created
+	defer func(f func() ([]byte, error)) { readMountInfo = f }(readMountInfo)
+	readMountInfo = func() ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !up {
+			return nil, nil
+		}
+		return []byte("50 1 0:52 / " + dir + " rw - fuse.rclone S3:b rw\n"), nil
+	}
+	p := NewVolumePlugin(c, "")
+	if err := p.create("data", map[string]string{"prefix": "teams/a", "reclaim": "Delete"}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.lazyUnmounted() {
+		t.Fatal("lazy mounter should start down")
+	}
+
+	// a mount brings the mounter up before handing out the path
+	mp, err := p.mount("data", "c1")
+	if err != nil || mp != filepath.Join(dir, "teams/a") {
+		t.Fatalf("mount: %q %v", mp, err)
+	}
+	if _, ok := created[c.mounterName()]; !ok || c.lazyMountStatus().State != "mounted" {
+		t.Fatalf("mounter not started: %v %+v", created, c.lazyMountStatus())
+	}
+	if err := p.unmount("data", "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// mounter down again: trashing would find the prefix gone
+	mu.Lock()
+	up = false
+	mu.Unlock()
+	delete(created, c.mounterName())
+	c.setLazyState("unmounted", "test")
+	if _, err := c.trashClaim(claimSpec{prefix: "teams/b", reclaim: "Delete", enabled: true}, time.Now()); err == nil || !strings.Contains(err.Error(), "on-demand") {
+		t.Fatal("trashed with the on-demand mounter down")
+	}
+	// removing the volume mounts first, then moves the data away
+	if err := p.remove("data"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, ok := created[c.mounterName()]; !ok {
+		t.Fatal("remove did not bring the mounter up")
+	}
+	if trashed, _ := filepath.Glob(filepath.Join(dir, ".trash", "*", "teams", "a")); len(trashed) != 1 {
+		t.Fatalf("expected data in trash, got %v", trashed)
+	}
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// Node-aware service claims. With NodeAwareClaims the controller lists only
+// the Swarm tasks placed on its own node (desired state running) and reads
+// the specs of their services, instead of every service in the cluster. Task
+// listing needs a manager: workers go through ManagerDockerHost. Nodes that
+// are not part of a swarm fall back to the full service list.
+
+type nodeState struct {
+	mu      sync.Mutex
+	id      string
+	manager bool
+}
+
+// swarmCli is the client for Swarm (manager) API calls.
+func (c *Controller) swarmCli() *client.Client {
+	if c.managerCli != nil {
+		return c.managerCli
+	}
+	return c.cli
+}
+
+// swarmNodeID returns the Swarm node ID of the local daemon ("" outside a
+// swarm); it is resolved once.
+func (c *Controller) swarmNodeID() string {
+	c.node.mu.Lock()
+	defer c.node.mu.Unlock()
+	if c.node.id != "" {
+		return c.node.id
+	}
+	ctx, cancel := c.timeoutCtx(10 * time.Second)
+	defer cancel()
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		slog.Warn("resolve swarm node id", "error", err)
+		return ""
+	}
+	c.node.id = strings.TrimSpace(info.Swarm.NodeID)
+	c.node.manager = info.Swarm.ControlAvailable
+	if c.node.id != "" {
+		slog.Info("swarm node resolved", "node", c.node.id)
+	}
+	return c.node.id
+}
+
+// swarmManager reports whether the local daemon is a Swarm manager.
+func (c *Controller) swarmManager() bool {
+	if c.swarmNodeID() == "" {
+		return false
+	}
+	c.node.mu.Lock()
+	defer c.node.mu.Unlock()
+	return c.node.manager
+}
+
+// knownNodeID returns the node ID resolved so far, for /status.
+func (c *Controller) knownNodeID() string {
+	c.node.mu.Lock()
+	defer c.node.mu.Unlock()
+	return c.node.id
+}
+
+// localServiceFilter returns a service list filter matching the services with
+// running tasks on this node. scoped is false when node-aware discovery is off
+// or the node is not in a swarm; an empty filter with scoped set means no
+// service has tasks here.
+func (c *Controller) localServiceFilter() (f filters.Args, scoped bool, err error) {
+	if !c.cfg.NodeAwareClaims {
+		return f, false, nil
+	}
+	node := c.swarmNodeID()
+	if node == "" {
+		return f, false, nil
+	}
+	tasks, err := c.swarmCli().TaskList(c.ctx, types.TaskListOptions{Filters: filters.NewArgs(
+		filters.Arg("node", node),
+		filters.Arg("desired-state", "running"),
+	)})
+	if err != nil {
+		return f, true, fmt.Errorf("list tasks on node %s: %w", node, err)
+	}
+	f = filters.NewArgs()
+	for _, t := range tasks {
+		if t.ServiceID != "" {
+			f.Add("id", t.ServiceID)
+		}
+	}
+	return f, true, nil
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// fakeSwarm answers /info, /tasks and /services like a manager daemon: two
+// services, only "web" has a task on node n1.
+func fakeSwarm(t *testing.T, calls *[]string) http.HandlerFunc {
+	services := []swarm.Service{
+		{ID: "svc-web", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "web", Labels: map[string]string{"volume-s3.enabled": "true", "volume-s3.prefix": "web"}}}},
+		{ID: "svc-db", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "db", Labels: map[string]string{"volume-s3.enabled": "true", "volume-s3.prefix": "db"}}}},
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := r.URL.Query().Get("filters")
+		*calls = append(*calls, r.URL.Path[strings.LastIndex(r.URL.Path, "/"):]+" "+f)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			_ = json.NewEncoder(w).Encode(map[string]any{"Swarm": map[string]string{"NodeID": "n1"}})
+		case strings.HasSuffix(r.URL.Path, "/tasks"):
+			if !strings.Contains(f, `"node":{"n1":true}`) || !strings.Contains(f, `"desired-state":{"running":true}`) {
+				t.Errorf("task filters: %s", f)
+			}
+			_ = json.NewEncoder(w).Encode([]swarm.Task{{ID: "t1", ServiceID: "svc-web", NodeID: "n1"}})
+		case strings.HasSuffix(r.URL.Path, "/services"):
+			var out []swarm.Service
+			for _, s := range services {
+				if f == "" || strings.Contains(f, `"`+s.ID+`"`) {
+					out = append(out, s)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(out)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestCollectServiceClaimSpecsNodeAware(t *testing.T) {
+	var calls []string
+	cli := fakeDocker(t, fakeSwarm(t, &calls))
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{NodeAwareClaims: true}}
+
+	specs, scoped, err := c.collectServiceClaimSpecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scoped || len(specs) != 1 || specs[0].prefix != "web" || specs[0].source != "service:web" {
+		t.Fatalf("scoped=%v specs=%+v", scoped, specs)
+	}
+	if c.knownNodeID() != "n1" {
+		t.Fatalf("node id: %q", c.knownNodeID())
+	}
+
+	c.cfg.NodeAwareClaims = false
+	calls = nil
+	if specs, scoped, err = c.collectServiceClaimSpecs(); err != nil || scoped || len(specs) != 2 {
+		t.Fatalf("cluster-wide: scoped=%v specs=%+v err=%v", scoped, specs, err)
+	}
+	if len(calls) != 1 || !strings.HasPrefix(calls[0], "/services") {
+		t.Fatalf("cluster-wide listing should only list services: %v", calls)
+	}
+}
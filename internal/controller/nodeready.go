@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Node readiness label (NodeReadyLabel). After each reconcile the result of
+// Ready() (mountpoint writable, not draining, optional strict remote check)
+// is published as the node label volume-s3.ready=true|false, so stacks can
+// place on `node.labels.volume-s3.ready == true` instead of the static
+// mount_s3 intent. A change is only published after NodeReadyThreshold
+// consecutive reconciles agree (hysteresis); the first result is published
+// right away. Node updates go through the manager API: locally on a manager
+// or via ManagerDockerHost. With lazy mounting an unmounted node counts as
+// ready, the mount follows the first claim.
+
+// nodeReadyLabel is the node label carrying mount readiness.
+const nodeReadyLabel = "volume-s3.ready"
+
+// NodeReadyStatus reports the published readiness label in /status.
+type NodeReadyStatus struct {
+	// Label is the published value ("" until the first publish)
+	Label    string
+	Observed bool
+	// Streak counts consecutive results disagreeing with Label
+	Streak    int
+	ChangedAt time.Time `json:",omitempty"`
+	Error     string    `json:",omitempty"`
+}
+
+type nodeReadyState struct {
+	mu        sync.Mutex
+	published string
+	observed  bool
+	streak    int
+	changedAt time.Time
+	lastErr   string
+}
+
+func (c *Controller) nodeReadyThreshold() int {
+	if c.cfg.NodeReadyThreshold > 0 {
+		return c.cfg.NodeReadyThreshold
+	}
+	return 1
+}
+
+// observeNodeReady records one readiness result and reports the label value
+// to publish, if any.
+func (c *Controller) observeNodeReady(ready bool) (string, bool) {
+	c.ready.mu.Lock()
+	defer c.ready.mu.Unlock()
+	c.ready.observed = ready
+	want := strconv.FormatBool(ready)
+	if want == c.ready.published {
+		c.ready.streak = 0
+		return "", false
+	}
+	c.ready.streak++
+	if c.ready.published != "" && c.ready.streak < c.nodeReadyThreshold() {
+		return "", false
+	}
+	return want, true
+}
+
+// publishNodeReady maintains the readiness label from the current Ready().
+func (c *Controller) publishNodeReady() {
+	if !c.cfg.NodeReadyLabel {
+		return
+	}
+	err := c.Ready()
+	if err != nil {
+		slog.Debug("node not ready", "error", err)
+	}
+	value, ok := c.observeNodeReady(err == nil)
+	if !ok {
+		return
+	}
+	if c.managerCli == nil && !c.swarmManager() {
+		c.ready.mu.Lock()
+		c.ready.lastErr = "no manager API: run on a manager or set ManagerDockerHost"
+		c.ready.mu.Unlock()
+		return
+	}
+	ctx, cancel := c.timeoutCtx(15 * time.Second)
+	defer cancel()
+	c.setNodeReadyLabel(ctx, value)
+}
+
+// setNodeReadyLabel writes the label on this node and records the outcome; a
+// failed write is retried on the next reconcile.
+func (c *Controller) setNodeReadyLabel(ctx context.Context, value string) {
+	err := c.updateNodeLabel(ctx, nodeReadyLabel, value)
+	c.ready.mu.Lock()
+	defer c.ready.mu.Unlock()
+	if err != nil {
+		c.ready.lastErr = err.Error()
+		slog.Warn("node ready label", "value", value, "error", err)
+		return
+	}
+	if c.ready.published != value {
+		slog.Info("node ready label", "label", nodeReadyLabel, "from", c.ready.published, "to", value)
+		c.ready.changedAt = time.Now().UTC()
+	}
+	c.ready.published, c.ready.streak, c.ready.lastErr = value, 0, ""
+}
+
+// updateNodeLabel sets one label on the local Swarm node (no-op when it
+// already has the value).
+func (c *Controller) updateNodeLabel(ctx context.Context, key, value string) error {
+	id := c.knownNodeID()
+	if id == "" {
+		if id = c.swarmNodeID(); id == "" {
+			return fmt.Errorf("not a swarm node")
+		}
+	}
+	node, _, err := c.swarmCli().NodeInspectWithRaw(ctx, id)
+	if err != nil {
+		return fmt.Errorf("inspect node %s: %w", id, err)
+	}
+	if node.Spec.Labels[key] == value {
+		return nil
+	}
+	spec := node.Spec
+	labels := make(map[string]string, len(spec.Labels)+1)
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+	labels[key] = value
+	spec.Labels = labels
+	if err := c.swarmCli().NodeUpdate(ctx, id, node.Version, spec); err != nil {
+		return fmt.Errorf("update node %s: %w", id, err)
+	}
+	return nil
+}
+
+// withdrawNodeReady marks the node not ready before its mount goes away on
+// shutdown; the controller context is already cancelled by then.
+func (c *Controller) withdrawNodeReady() {
+	if !c.cfg.NodeReadyLabel || c.knownNodeID() == "" || (c.managerCli == nil && !c.swarmManager()) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c.setNodeReadyLabel(ctx, "false")
+}
+
+func (c *Controller) nodeReadyStatus() *NodeReadyStatus {
+	if !c.cfg.NodeReadyLabel {
+		return nil
+	}
+	c.ready.mu.Lock()
+	defer c.ready.mu.Unlock()
+	return &NodeReadyStatus{
+		Label:     c.ready.published,
+		Observed:  c.ready.observed,
+		Streak:    c.ready.streak,
+		ChangedAt: c.ready.changedAt,
+		Error:     c.ready.lastErr,
+	}
+}
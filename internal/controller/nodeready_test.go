@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestNodeReadyHysteresis(t *testing.T) {
+	c := &Controller{cfg: Config{NodeReadyLabel: true, NodeReadyThreshold: 3}}
+	// first result is published at once
+	if v, ok := c.observeNodeReady(true); !ok || v != "true" {
+		t.Fatalf("first: %q %v", v, ok)
+	}
+	c.ready.published, c.ready.streak = "true", 0
+	// a single failed probe between successes does not flip the label
+	for i, want := range []bool{false, false, true, false, false, false} {
+		v, ok := c.observeNodeReady(want)
+		if ok != (i == 5) {
+			t.Fatalf("step %d: publish=%v %q", i, ok, v)
+		}
+	}
+	if st := c.nodeReadyStatus(); st.Streak != 3 || st.Observed || st.Label != "true" {
+		t.Fatalf("status: %+v", st)
+	}
+}
+
+func TestPublishNodeReady(t *testing.T) {
+	node := swarm.Node{ID: "n1", Spec: swarm.NodeSpec{Annotations: swarm.Annotations{Labels: map[string]string{"mount_s3": "true"}}}}
+	node.Version.Index = 4
+	updates := 0
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			_ = json.NewEncoder(w).Encode(map[string]any{"Swarm": map[string]any{"NodeID": "n1", "ControlAvailable": true}})
+		case strings.HasSuffix(r.URL.Path, "/nodes/n1"):
+			_ = json.NewEncoder(w).Encode(node)
+		case strings.HasSuffix(r.URL.Path, "/nodes/n1/update"):
+			if r.URL.Query().Get("version") != "4" {
+				t.Errorf("node update version %s", r.URL.Query().Get("version"))
+			}
+			updates++
+			_ = json.NewDecoder(r.Body).Decode(&node.Spec)
+			node.Version.Index++
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{Mountpoint: t.TempDir(), ReadyFile: ".ready", NodeReadyLabel: true, NodeReadyThreshold: 3}}
+
+	c.publishNodeReady()
+	if updates != 1 || node.Spec.Labels[nodeReadyLabel] != "true" || node.Spec.Labels["mount_s3"] != "true" {
+		t.Fatalf("updates=%d labels=%v", updates, node.Spec.Labels)
+	}
+	// steady state: no further node updates
+	c.publishNodeReady()
+	if st := c.nodeReadyStatus(); updates != 1 || st.Label != "true" || st.ChangedAt.IsZero() || st.Error != "" {
+		t.Fatalf("updates=%d status=%+v", updates, st)
+	}
+}
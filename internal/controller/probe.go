@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Hang-safe mount probes. A hung rclone makes every filesystem call on its
+// mountpoint block in the kernel, so probes run in a goroutine and are given
+// up after ProbeTimeout. The goroutine itself cannot be cancelled; while it
+// is still stuck further probes of the same path report timeout at once
+// instead of piling up blocked goroutines.
+
+// Probe outcomes.
+const (
+	ProbeOK           = "ok"
+	ProbeNotConnected = "enotconn" // rclone gone, FUSE connection dead
+	ProbeIOError      = "eio"
+	ProbeTimeout      = "timeout" // rclone hung
+	ProbeReadOnly     = "read_only"
+	ProbeError        = "error"
+)
+
+var errProbeTimeout = errors.New("probe timed out")
+
+// MountProbe is the last probe result of one path.
+type MountProbe struct {
+	Path      string
+	Result    string
+	Error     string `json:",omitempty"`
+	LatencyMs int64
+	CheckedAt time.Time
+}
+
+// Err returns nil for ok and an error naming the outcome otherwise.
+func (p MountProbe) Err() error {
+	if p.Result == ProbeOK {
+		return nil
+	}
+	return fmt.Errorf("mount probe %s: %s", p.Result, p.Error)
+}
+
+// hung reports whether the outcome points at a dead or stuck FUSE connection.
+func (p MountProbe) hung() bool {
+	return p.Result == ProbeTimeout || p.Result == ProbeNotConnected || p.Result == ProbeIOError
+}
+
+type probeState struct {
+	mu       sync.Mutex
+	inflight map[string]bool
+	last     map[string]MountProbe
+}
+
+func classifyProbe(err error) string {
+	switch {
+	case err == nil:
+		return ProbeOK
+	case errors.Is(err, errProbeTimeout):
+		return ProbeTimeout
+	case errors.Is(err, syscall.ENOTCONN):
+		return ProbeNotConnected
+	case errors.Is(err, syscall.EIO):
+		return ProbeIOError
+	case errors.Is(err, syscall.EROFS):
+		return ProbeReadOnly
+	}
+	return ProbeError
+}
+
+func (c *Controller) probeTimeout() time.Duration {
+	if c.cfg.ProbeTimeout > 0 {
+		return c.cfg.ProbeTimeout
+	}
+	return 5 * time.Second
+}
+
+// probePath runs fn against path with a deadline and records the outcome.
+func (c *Controller) probePath(path string, fn func() error) MountProbe {
+	p := MountProbe{Path: path, CheckedAt: time.Now().UTC()}
+	c.probes.mu.Lock()
+	if c.probes.inflight == nil {
+		c.probes.inflight = map[string]bool{}
+		c.probes.last = map[string]MountProbe{}
+	}
+	busy := c.probes.inflight[path]
+	if !busy {
+		c.probes.inflight[path] = true
+	}
+	c.probes.mu.Unlock()
+
+	var err error
+	if busy {
+		err = fmt.Errorf("%w: previous probe still blocked", errProbeTimeout)
+	} else {
+		done := make(chan error, 1)
+		go func() {
+			err := fn()
+			c.probes.mu.Lock()
+			delete(c.probes.inflight, path)
+			c.probes.mu.Unlock()
+			done <- err
+		}()
+		t := time.NewTimer(c.probeTimeout())
+		select {
+		case err = <-done:
+		case <-t.C:
+			err = fmt.Errorf("%w after %s", errProbeTimeout, c.probeTimeout())
+		}
+		t.Stop()
+	}
+	p.LatencyMs = time.Since(p.CheckedAt).Milliseconds()
+	p.Result = classifyProbe(err)
+	if err != nil {
+		p.Error = err.Error()
+	}
+	c.probes.mu.Lock()
+	c.probes.last[path] = p
+	c.probes.mu.Unlock()
+	return p
+}
+
+// probeMount checks path with a write probe, or a listing when write is false.
+func (c *Controller) probeMount(path string, write bool) MountProbe {
+	if write {
+		return c.probePath(path, func() error { return testRW(path) })
+	}
+	return c.probePath(path, func() error {
+		_, err := os.ReadDir(path)
+		return err
+	})
+}
+
+func (c *Controller) probesSnapshot() []MountProbe {
+	c.probes.mu.Lock()
+	out := make([]MountProbe, 0, len(c.probes.last))
+	for _, p := range c.probes.last {
+		out = append(out, p)
+	}
+	c.probes.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// mountEntry is the topmost mount at a path from /proc/self/mountinfo.
+type mountEntry struct {
+	dev    string // major:minor
+	fstype string
+}
+
+// parseMountInfo returns the topmost mount at path; reading mountinfo never
+// touches the mounted filesystem, so it is safe on hung mounts.
+func parseMountInfo(data, path string) (mountEntry, bool) {
+	var me mountEntry
+	found := false
+	for _, ln := range strings.Split(data, "\n") {
+		fields := strings.Fields(ln)
+		if len(fields) < 5 || fields[4] != path {
+			continue
+		}
+		me, found = mountEntry{dev: fields[2]}, true
+		for i, f := range fields {
+			if f == "-" && i+1 < len(fields) {
+				me.fstype = fields[i+1]
+				break
+			}
+		}
+	}
+	return me, found
+}
+
+// readMountInfo returns the mount table of the controller's mount namespace.
+var readMountInfo = func() ([]byte, error) {
+	return os.ReadFile("/proc/self/mountinfo")
+}
+
+func lookupMount(path string) (mountEntry, bool) {
+	b, err := readMountInfo()
+	if err != nil {
+		return mountEntry{}, false
+	}
+	return parseMountInfo(string(b), path)
+}
+
+// fuseConnID returns the /sys/fs/fuse/connections entry of the FUSE mount at
+// path: FUSE mounts use major 0, and the directory is named by the minor.
+func fuseConnID(me mountEntry) string {
+	if !strings.HasPrefix(me.fstype, "fuse") {
+		return ""
+	}
+	major, minor, ok := strings.Cut(me.dev, ":")
+	if !ok || major != "0" {
+		return ""
+	}
+	return minor
+}
+
+// fuseAbortSh aborts a FUSE connection in the host namespace, mounting fusectl
+// first when needed. Blocked syscalls then fail with ENOTCONN and the mount can
+// be unmounted.
+func fuseAbortSh(id string) string {
+	return fmt.Sprintf("(nsenter -t 1 -m -- sh -c 'mountpoint -q /sys/fs/fuse/connections || mount -t fusectl none /sys/fs/fuse/connections; echo 1 > /sys/fs/fuse/connections/%s/abort' || true); ", id)
+}
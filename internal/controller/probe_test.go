@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyProbe(t *testing.T) {
+	cases := map[error]string{
+		nil: ProbeOK,
+		&os.PathError{Op: "open", Path: "/mnt/s3/.rw-test", Err: syscall.ENOTCONN}: ProbeNotConnected,
+		&os.PathError{Op: "write", Path: "/mnt/s3/.rw-test", Err: syscall.EIO}:     ProbeIOError,
+		&os.PathError{Op: "open", Path: "/mnt/s3/.rw-test", Err: syscall.EROFS}:    ProbeReadOnly,
+		fmt.Errorf("%w after 5s", errProbeTimeout):                                 ProbeTimeout,
+		os.ErrPermission: ProbeError,
+	}
+	for err, want := range cases {
+		if got := classifyProbe(err); got != want {
+			t.Errorf("classifyProbe(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestProbePathTimeout(t *testing.T) {
+	c := &Controller{cfg: Config{ProbeTimeout: 50 * time.Millisecond}}
+	release := make(chan struct{})
+	hung := func() error { <-release; return nil }
+
+	p := c.probePath("/mnt/hung", hung)
+	if p.Result != ProbeTimeout || p.Err() == nil || !p.hung() {
+		t.Fatalf("expected timeout, got %+v", p)
+	}
+	// the first probe is still blocked: no second goroutine, immediate timeout
+	start := time.Now()
+	if p := c.probePath("/mnt/hung", func() error { return nil }); p.Result != ProbeTimeout {
+		t.Fatalf("expected timeout while blocked, got %+v", p)
+	}
+	if time.Since(start) > 40*time.Millisecond {
+		t.Fatal("probe of a blocked path should not wait")
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if p := c.probePath("/mnt/hung", func() error { return nil }); p.Result == ProbeOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("path never recovered after the hung probe returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if snap := c.probesSnapshot(); len(snap) != 1 || snap[0].Result != ProbeOK {
+		t.Fatalf("unexpected snapshot %+v", snap)
+	}
+}
+
+func TestProbeMountDir(t *testing.T) {
+	c := &Controller{}
+	dir := t.TempDir()
+	if p := c.probeMount(dir, true); p.Result != ProbeOK {
+		t.Fatalf("write probe on temp dir: %+v", p)
+	}
+	if p := c.probeMount(dir+"/missing/sub", false); p.Result != ProbeError {
+		t.Fatalf("listing a missing dir: %+v", p)
+	}
+}
+
+func TestFuseConnID(t *testing.T) {
+	info := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+98 22 0:52 / /mnt/s3 rw,nosuid,nodev,relatime shared:40 - fuse.rclone S3:bucket rw,user_id=0,group_id=0
+99 98 0:60 / /mnt/s3 rw,nosuid,nodev,relatime shared:41 - fuse.rclone S3:bucket rw,user_id=0,group_id=0
+100 22 8:1 /data /mnt/data rw,relatime shared:1 - ext4 /dev/sda1 rw`
+	me, ok := parseMountInfo(info, "/mnt/s3")
+	if !ok || fuseConnID(me) != "60" {
+		t.Fatalf("topmost fuse mount expected, got %+v %v", me, ok)
+	}
+	me, ok = parseMountInfo(info, "/mnt/data")
+	if !ok || fuseConnID(me) != "" {
+		t.Fatalf("non-fuse mount must have no connection, got %+v", me)
+	}
+	if _, ok := parseMountInfo(info, "/mnt/none"); ok {
+		t.Fatal("unexpected mount")
+	}
+}
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// rclone remote control. Mounters run with --rc bound to RcloneRCAddr, a
+// loopback address by default, so the API is only reachable from inside the
+// mounter's own network namespace. The controller queries it with
+// `docker exec <mounter> rclone rc ...` on every reconcile.
+
+// RcloneStats is what the rc API of one mounter reported in the last reconcile.
+type RcloneStats struct {
+	Mounter   string
+	Claim     string `json:",omitempty"`
+	Up        bool
+	Version   string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+	UpdatedAt time.Time
+	// core/stats
+	Bytes     int64
+	Errors    int64
+	Transfers int64
+	Checks    int64
+	LastError string `json:",omitempty"`
+	// vfs/stats
+	CacheBytes        int64
+	CacheFiles        int64
+	CacheErroredFiles int64
+	CacheOutOfSpace   bool
+	UploadsInProgress int64
+	UploadsQueued     int64
+}
+
+type rcCoreStats struct {
+	Bytes     int64  `json:"bytes"`
+	Errors    int64  `json:"errors"`
+	Transfers int64  `json:"transfers"`
+	Checks    int64  `json:"checks"`
+	LastError string `json:"lastError"`
+}
+
+type rcVFSStats struct {
+	DiskCache *struct {
+		BytesUsed         int64 `json:"bytesUsed"`
+		Files             int64 `json:"files"`
+		ErroredFiles      int64 `json:"erroredFiles"`
+		OutOfSpace        bool  `json:"outOfSpace"`
+		UploadsInProgress int64 `json:"uploadsInProgress"`
+		UploadsQueued     int64 `json:"uploadsQueued"`
+	} `json:"diskCache"`
+}
+
+type rcVersion struct {
+	Version string `json:"version"`
+}
+
+// rcArgs returns the mounter flags enabling the rc API ("" addr disables it).
+func (c *Controller) rcArgs() []string {
+	addr := strings.TrimSpace(c.cfg.RcloneRCAddr)
+	if addr == "" {
+		return nil
+	}
+	return []string{"--rc", "--rc-addr=" + addr}
+}
+
+// applyRcloneStats fills st from the raw JSON replies of the three rc calls.
+func applyRcloneStats(st *RcloneStats, core, vfs, version []byte) error {
+	var cs rcCoreStats
+	if err := json.Unmarshal(core, &cs); err != nil {
+		return fmt.Errorf("core/stats: %w", err)
+	}
+	st.Bytes, st.Errors, st.Transfers, st.Checks, st.LastError = cs.Bytes, cs.Errors, cs.Transfers, cs.Checks, cs.LastError
+	var vs rcVFSStats
+	if err := json.Unmarshal(vfs, &vs); err != nil {
+		return fmt.Errorf("vfs/stats: %w", err)
+	}
+	// diskCache is absent with --vfs-cache-mode=off
+	if dc := vs.DiskCache; dc != nil {
+		st.CacheBytes, st.CacheFiles, st.CacheErroredFiles, st.CacheOutOfSpace = dc.BytesUsed, dc.Files, dc.ErroredFiles, dc.OutOfSpace
+		st.UploadsInProgress, st.UploadsQueued = dc.UploadsInProgress, dc.UploadsQueued
+	}
+	var v rcVersion
+	if err := json.Unmarshal(version, &v); err != nil {
+		return fmt.Errorf("core/version: %w", err)
+	}
+	st.Version = v.Version
+	return nil
+}
+
+// rcCall runs one rc method inside the mounter container and returns its JSON reply.
+func (c *Controller) rcCall(parent context.Context, id, method string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(parent, 5*time.Second)
+	defer cancel()
+	url := "http://" + strings.TrimSpace(c.cfg.RcloneRCAddr) + "/"
+	ex, err := c.cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:          []string{"rclone", "rc", "--url", url, method},
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	att, err := c.cli.ContainerExecAttach(ctx, ex.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, err
+	}
+	defer att.Close()
+	var stdout, stderr strings.Builder
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, att.Reader); err != nil {
+		return nil, err
+	}
+	insp, err := c.cli.ContainerExecInspect(ctx, ex.ID)
+	if err != nil {
+		return nil, err
+	}
+	if insp.ExitCode != 0 {
+		return nil, fmt.Errorf("rclone rc %s exited %d: %s", method, insp.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return []byte(stdout.String()), nil
+}
+
+func (c *Controller) queryRcloneStats(ct types.Container) RcloneStats {
+	st := RcloneStats{
+		Mounter:   strings.TrimPrefix(firstName(ct.Names), "/"),
+		Claim:     ct.Labels[claimMounterLabel],
+		UpdatedAt: time.Now().UTC(),
+	}
+	var replies [3][]byte
+	for i, method := range []string{"core/stats", "vfs/stats", "core/version"} {
+		out, err := c.rcCall(c.ctx, ct.ID, method)
+		if err != nil {
+			st.Error = err.Error()
+			return st
+		}
+		replies[i] = out
+	}
+	if err := applyRcloneStats(&st, replies[0], replies[1], replies[2]); err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	st.Up = true
+	return st
+}
+
+// collectRcloneStats queries every running mounter on this node.
+func (c *Controller) collectRcloneStats() {
+	if strings.TrimSpace(c.cfg.RcloneRCAddr) == "" {
+		return
+	}
+	args := filters.NewArgs()
+	args.Add("label", "swarmnative.mounter=managed")
+	args.Add("label", "swarmnative.mounter.node="+sanitizeHostname())
+	args.Add("status", "running")
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	conts, err := c.cli.ContainerList(ctx, container.ListOptions{Filters: args})
+	cancel()
+	if err != nil {
+		slog.Warn("list mounters for rc stats", "error", err)
+		return
+	}
+	stats := make([]RcloneStats, len(conts))
+	var wg sync.WaitGroup
+	for i, ct := range conts {
+		wg.Add(1)
+		go func(i int, ct types.Container) {
+			defer wg.Done()
+			stats[i] = c.queryRcloneStats(ct)
+		}(i, ct)
+	}
+	wg.Wait()
+	for _, st := range stats {
+		if st.Error != "" {
+			slog.Debug("rclone rc", "mounter", st.Mounter, "error", st.Error)
+		} else if st.CacheErroredFiles > 0 || st.CacheOutOfSpace {
+			slog.Warn("rclone vfs cache trouble", "mounter", st.Mounter, "queued", st.UploadsQueued,
+				"in_progress", st.UploadsInProgress, "errored_files", st.CacheErroredFiles, "out_of_space", st.CacheOutOfSpace)
+		}
+	}
+	c.rcMu.Lock()
+	c.rcStats = stats
+	c.rcMu.Unlock()
+}
+
+func (c *Controller) rcloneStatsSnapshot() []RcloneStats {
+	c.rcMu.Lock()
+	defer c.rcMu.Unlock()
+	return append([]RcloneStats(nil), c.rcStats...)
+}
+
+func firstName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyRcloneStats(t *testing.T) {
+	core := []byte(`{"bytes":1048576,"checks":3,"errors":1,"lastError":"upload failed","transfers":7,"speed":12.5}`)
+	vfs := []byte(`{"fs":"S3:bucket/app","inUse":2,"diskCache":{"bytesUsed":4096,"erroredFiles":1,"files":5,
+		"hashType":0,"outOfSpace":false,"path":"/root/.cache/rclone/vfs/S3","uploadsInProgress":1,"uploadsQueued":3}}`)
+	version := []byte(`{"version":"v1.66.0","goVersion":"go1.22.1","os":"linux","arch":"amd64"}`)
+
+	var st RcloneStats
+	if err := applyRcloneStats(&st, core, vfs, version); err != nil {
+		t.Fatal(err)
+	}
+	want := RcloneStats{
+		Version: "v1.66.0", Bytes: 1048576, Errors: 1, Transfers: 7, Checks: 3, LastError: "upload failed",
+		CacheBytes: 4096, CacheFiles: 5, CacheErroredFiles: 1, UploadsInProgress: 1, UploadsQueued: 3,
+	}
+	if !reflect.DeepEqual(st, want) {
+		t.Fatalf("got %+v\nwant %+v", st, want)
+	}
+
+	// --vfs-cache-mode=off has no diskCache section
+	st = RcloneStats{}
+	if err := applyRcloneStats(&st, core, []byte(`{"fs":"S3:bucket","inUse":0}`), version); err != nil {
+		t.Fatal(err)
+	}
+	if st.CacheBytes != 0 || st.UploadsQueued != 0 || st.Transfers != 7 {
+		t.Fatalf("unexpected stats without cache: %+v", st)
+	}
+
+	if err := applyRcloneStats(&st, []byte("Failed to rc"), vfs, version); err == nil {
+		t.Fatal("expected error for non-JSON reply")
+	}
+}
+
+func TestRcArgs(t *testing.T) {
+	c := &Controller{cfg: Config{RcloneRCAddr: "127.0.0.1:5572"}}
+	if got := c.rcArgs(); !reflect.DeepEqual(got, []string{"--rc", "--rc-addr=127.0.0.1:5572"}) {
+		t.Fatalf("rcArgs = %v", got)
+	}
+	c.cfg.RcloneRCAddr = ""
+	if got := c.rcArgs(); got != nil {
+		t.Fatalf("rc disabled, got %v", got)
+	}
+}
@@ -0,0 +1,400 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reclaim of volume-s3.reclaim=Delete claims. A claim that disappears from all
+// services is kept for ReclaimGracePeriod, then its prefix is moved to
+// .trash/<timestamp>/<prefix> and purged after ReclaimRetention. Only claims
+// declared on services are tracked: container claims are node-local and their
+// absence on one node says nothing about the cluster. A prefix is never
+// trashed while another live claim (any source, any reclaim policy) maps to it
+// or below it.
+//
+// Reclaim runs on one node only, the Swarm leader (reclaimOwner): every node
+// sees all services, but plugin volumes, CSI volumes and container claims
+// are known only to the node holding them, so a prefix still used that way
+// on another node does not block reclaim.
+
+const (
+	trashDir         = ".trash"
+	trashStampLayout = "20060102T150405Z"
+)
+
+// ReclaimStatus reports one tracked Delete claim in /status.
+type ReclaimStatus struct {
+	Claim       string
+	Source      string
+	State       string // bound | released | trashed | failed
+	LastSeen    time.Time
+	DeleteAfter time.Time `json:",omitempty"`
+	TrashPath   string    `json:",omitempty"`
+	PurgeAfter  time.Time `json:",omitempty"`
+	DryRun      bool
+	Error       string `json:",omitempty"`
+}
+
+type reclaimEntry struct {
+	spec   claimSpec
+	status ReclaimStatus
+}
+
+func isDeleteClaim(cs claimSpec) bool {
+	return strings.EqualFold(cs.reclaim, "Delete")
+}
+
+// claimHolders are the live claims held outside discovery: Docker volume
+// plugin and CSI volumes, listed by their servers on demand. list holds every
+// volume (reclaim guard), node only those in use on this node (per-claim
+// mounters).
+type claimHolders struct {
+	mu   sync.Mutex
+	list map[string]func() []claimSpec
+	node map[string]func() []claimSpec
+}
+
+// registerClaimHolder makes the claims returned by fn block reclaim of their
+// prefixes; a later registration under the same kind replaces the earlier one.
+func (c *Controller) registerClaimHolder(kind string, fn func() []claimSpec) {
+	c.holders.mu.Lock()
+	defer c.holders.mu.Unlock()
+	if c.holders.list == nil {
+		c.holders.list = map[string]func() []claimSpec{}
+	}
+	c.holders.list[kind] = fn
+}
+
+// claimHolder names a live claim whose data lies at or below the prefix of cs,
+// or returns "" when the prefix is free.
+func (c *Controller) claimHolder(cs claimSpec) string {
+	id := c.claimID(cs)
+	covers := func(other string) bool {
+		return other == id || strings.HasPrefix(other, id+"/")
+	}
+	for _, st := range c.claimsSnapshot() {
+		if covers(st.ID) {
+			return strings.Join(st.Sources, ",")
+		}
+	}
+	c.reclaimMu.Lock()
+	services := c.serviceClaims
+	c.reclaimMu.Unlock()
+	for _, s := range services {
+		if covers(c.claimID(s)) {
+			return s.source
+		}
+	}
+	c.holders.mu.Lock()
+	fns := make([]func() []claimSpec, 0, len(c.holders.list))
+	for _, fn := range c.holders.list {
+		fns = append(fns, fn)
+	}
+	c.holders.mu.Unlock()
+	for _, fn := range fns {
+		for _, s := range fn() {
+			if covers(c.claimID(s)) {
+				return s.source
+			}
+		}
+	}
+	return ""
+}
+
+// mountedBucket is the bucket served by the shared mount (the path of
+// RcloneRemote); claim paths under the shared mount do not carry a bucket.
+func (c *Controller) mountedBucket() string {
+	if i := strings.Index(c.cfg.RcloneRemote, ":"); i >= 0 {
+		return strings.Trim(c.cfg.RcloneRemote[i+1:], "/")
+	}
+	return ""
+}
+
+// reclaimOwner reports whether this node runs reclaim: the Swarm leader, or
+// the node itself outside a swarm. Leadership is checked every time, so
+// reclaim moves with it.
+func (c *Controller) reclaimOwner() bool {
+	ctx, cancel := c.timeoutCtx(10 * time.Second)
+	defer cancel()
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		slog.Warn("reclaim: docker info", "error", err)
+		return false
+	}
+	if info.Swarm.NodeID == "" {
+		return true
+	}
+	if !info.Swarm.ControlAvailable {
+		return false
+	}
+	n, _, err := c.cli.NodeInspectWithRaw(ctx, info.Swarm.NodeID)
+	if err != nil {
+		slog.Warn("reclaim: inspect own node", "error", err)
+		return false
+	}
+	return n.ManagerStatus != nil && n.ManagerStatus.Leader
+}
+
+// observeServiceClaims records the complete set of service claims seen in this
+// reconcile; Delete claims missing from it start their grace period.
+func (c *Controller) observeServiceClaims(specs []claimSpec) {
+	if !c.cfg.ReclaimEnabled {
+		return
+	}
+	now := time.Now().UTC()
+	seen := map[string]claimSpec{}
+	var live []claimSpec
+	for _, s := range specs {
+		if !s.enabled || s.prefix == "" {
+			continue
+		}
+		live = append(live, s)
+		if isDeleteClaim(s) && s.err == nil {
+			seen[claimKey(s)] = s
+		}
+	}
+	c.reclaimMu.Lock()
+	defer c.reclaimMu.Unlock()
+	c.serviceClaims = live
+	if c.reclaims == nil {
+		c.reclaims = map[string]*reclaimEntry{}
+	}
+	for key, s := range seen {
+		e, ok := c.reclaims[key]
+		if !ok {
+			e = &reclaimEntry{}
+			c.reclaims[key] = e
+		}
+		if e.status.State == "trashed" {
+			// a new claim on the now empty prefix; the trash keeps its timestamp
+			if e.status.DryRun {
+				slog.Info("reclaim: dry-run, claim bound again", "claim", key, "source", s.source)
+			} else {
+				slog.Warn("reclaim: claim reappeared after its data was trashed", "claim", key, "trash", e.status.TrashPath)
+			}
+		}
+		if e.status.State == "released" {
+			slog.Info("reclaim: claim bound again, grace period cancelled", "claim", key, "source", s.source)
+		}
+		e.spec = s
+		e.status = ReclaimStatus{Claim: key, Source: s.source, State: "bound", LastSeen: now, DryRun: c.cfg.ReclaimDryRun}
+	}
+	for key, e := range c.reclaims {
+		if _, ok := seen[key]; ok || e.status.State != "bound" {
+			continue
+		}
+		e.status.State = "released"
+		e.status.DeleteAfter = e.status.LastSeen.Add(c.cfg.ReclaimGracePeriod)
+		slog.Info("reclaim: claim released", "claim", key, "source", e.status.Source, "delete_after", e.status.DeleteAfter.Format(time.RFC3339), "dry_run", c.cfg.ReclaimDryRun)
+	}
+}
+
+// processReclaims trashes released claims past their grace period and purges
+// expired trash.
+func (c *Controller) processReclaims() {
+	if !c.cfg.ReclaimEnabled {
+		return
+	}
+	now := time.Now().UTC()
+	c.reclaimMu.Lock()
+	var due []*reclaimEntry
+	for _, e := range c.reclaims {
+		if e.status.State == "released" && !now.Before(e.status.DeleteAfter) {
+			due = append(due, e)
+		}
+	}
+	c.reclaimMu.Unlock()
+	for _, e := range due {
+		trash, err := c.trashClaim(e.spec, now)
+		c.reclaimMu.Lock()
+		switch {
+		case err != nil:
+			e.status.State = "failed"
+			e.status.Error = err.Error()
+			slog.Error("reclaim: trash failed", "claim", e.status.Claim, "error", err)
+		case trash == "":
+			delete(c.reclaims, e.status.Claim)
+		default:
+			e.status.State = "trashed"
+			e.status.TrashPath = trash
+			e.status.PurgeAfter = now.Add(c.cfg.ReclaimRetention)
+			e.status.Error = ""
+			if c.perClaimMounters() && !c.cfg.ReclaimDryRun {
+				if c.trashRoots == nil {
+					c.trashRoots = map[string]struct{}{}
+				}
+				c.trashRoots[c.remoteTrashRoot(e.spec)] = struct{}{}
+			}
+		}
+		c.reclaimMu.Unlock()
+	}
+	c.purgeTrash(now)
+	c.forgetDryRun(now)
+}
+
+// forgetDryRun drops dry-run entries past their retention: nothing was moved,
+// so no purge will ever forget them.
+func (c *Controller) forgetDryRun(now time.Time) {
+	c.reclaimMu.Lock()
+	defer c.reclaimMu.Unlock()
+	for key, e := range c.reclaims {
+		if e.status.State == "trashed" && e.status.DryRun && !now.Before(e.status.PurgeAfter) {
+			slog.Info("reclaim: dry-run, would purge trash", "claim", key, "path", e.status.TrashPath)
+			delete(c.reclaims, key)
+		}
+	}
+}
+
+// trashClaim moves the claim prefix to .trash/<timestamp>/<prefix> and returns
+// the trash path (in dry-run mode only the would-be path). An empty path means
+// there was nothing to move.
+func (c *Controller) trashClaim(cs claimSpec, now time.Time) (string, error) {
+	prefix := strings.Trim(cs.prefix, "/")
+	if prefix == "" || checkClaimPrefix(prefix) != nil {
+		return "", fmt.Errorf("refusing to trash prefix %q", cs.prefix)
+	}
+	if b := strings.Trim(cs.bucket, "/"); !c.perClaimMounters() && b != "" && b != c.mountedBucket() {
+		return "", fmt.Errorf("refusing to trash %s/%s: the shared mount serves bucket %q", b, prefix, c.mountedBucket())
+	}
+	if holder := c.claimHolder(cs); holder != "" {
+		return "", fmt.Errorf("refusing to trash %s: still claimed by %s", c.claimID(cs), holder)
+	}
+	stamp := now.UTC().Format(trashStampLayout)
+	if c.perClaimMounters() {
+		src := c.claimRemote(cs)
+		dst := strings.TrimSuffix(src, prefix) + path.Join(trashDir, stamp, prefix)
+		if c.cfg.ReclaimDryRun {
+			slog.Info("reclaim: dry-run, would move prefix to trash", "from", src, "to", dst)
+			return dst, nil
+		}
+		slog.Info("reclaim: moving prefix to trash", "from", src, "to", dst)
+		if err := c.runRcloneCmd([]string{"moveto", src, dst}); err != nil {
+			return "", err
+		}
+		return dst, nil
+	}
+	if c.lazyUnmounted() {
+		return "", fmt.Errorf("refusing to trash %s: the on-demand mounter is down", c.claimID(cs))
+	}
+	src := c.claimPath(cs)
+	dst := filepath.Join(c.cfg.Mountpoint, trashDir, stamp, prefix)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		slog.Info("reclaim: prefix already gone", "path", src)
+		return "", nil
+	}
+	if c.cfg.ReclaimDryRun {
+		slog.Info("reclaim: dry-run, would move prefix to trash", "from", src, "to", dst)
+		return dst, nil
+	}
+	slog.Info("reclaim: moving prefix to trash", "from", src, "to", dst)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// purgeTrash removes .trash/<timestamp> entries older than ReclaimRetention.
+// On the shared mount the timestamp in the path is authoritative, so trash left
+// by a previous controller run is purged as well.
+func (c *Controller) purgeTrash(now time.Time) {
+	if c.perClaimMounters() {
+		c.purgeRemoteTrash(now)
+		return
+	}
+	root := filepath.Join(c.cfg.Mountpoint, trashDir)
+	ents, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, ent := range ents {
+		ts, err := time.Parse(trashStampLayout, ent.Name())
+		if !ent.IsDir() || err != nil || now.Sub(ts) < c.cfg.ReclaimRetention {
+			continue
+		}
+		p := filepath.Join(root, ent.Name())
+		if c.cfg.ReclaimDryRun {
+			slog.Info("reclaim: dry-run, would purge trash", "path", p)
+			continue
+		}
+		slog.Info("reclaim: purging trash", "path", p, "trashed_at", ts.Format(time.RFC3339))
+		if err := os.RemoveAll(p); err != nil {
+			slog.Error("reclaim: purge failed", "path", p, "error", err)
+			continue
+		}
+		c.forgetTrashed(p)
+	}
+}
+
+func (c *Controller) purgeRemoteTrash(now time.Time) {
+	c.reclaimMu.Lock()
+	roots := make([]string, 0, len(c.trashRoots))
+	for r := range c.trashRoots {
+		roots = append(roots, r)
+	}
+	c.reclaimMu.Unlock()
+	for _, root := range roots {
+		out, err := c.runRcloneOutput([]string{"lsf", "--dirs-only", root})
+		if err != nil {
+			slog.Warn("reclaim: list trash", "path", root, "error", err)
+			continue
+		}
+		for _, ln := range strings.Split(out, "\n") {
+			name := strings.Trim(strings.TrimSpace(ln), "/")
+			ts, err := time.Parse(trashStampLayout, name)
+			if err != nil || now.Sub(ts) < c.cfg.ReclaimRetention {
+				continue
+			}
+			p := root + "/" + name
+			if c.cfg.ReclaimDryRun {
+				slog.Info("reclaim: dry-run, would purge trash", "path", p)
+				continue
+			}
+			slog.Info("reclaim: purging trash", "path", p, "trashed_at", ts.Format(time.RFC3339))
+			if err := c.runRcloneCmd([]string{"purge", p}); err != nil {
+				slog.Error("reclaim: purge failed", "path", p, "error", err)
+				continue
+			}
+			c.forgetTrashed(p)
+		}
+	}
+}
+
+// remoteTrashRoot is the .trash directory of the bucket of cs.
+func (c *Controller) remoteTrashRoot(cs claimSpec) string {
+	return strings.TrimSuffix(c.claimRemote(cs), strings.Trim(cs.prefix, "/")) + trashDir
+}
+
+// forgetTrashed drops tracked entries whose trash path lived under the purged root.
+func (c *Controller) forgetTrashed(root string) {
+	c.reclaimMu.Lock()
+	defer c.reclaimMu.Unlock()
+	for key, e := range c.reclaims {
+		if e.status.State == "trashed" && strings.HasPrefix(e.status.TrashPath, root+"/") {
+			slog.Info("reclaim: claim purged", "claim", key)
+			delete(c.reclaims, key)
+		}
+	}
+}
+
+// reclaimSnapshot returns tracked reclaim entries ordered by claim.
+func (c *Controller) reclaimSnapshot() []ReclaimStatus {
+	c.reclaimMu.Lock()
+	defer c.reclaimMu.Unlock()
+	out := make([]ReclaimStatus, 0, len(c.reclaims))
+	for _, e := range c.reclaims {
+		out = append(out, e.status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Claim < out[j].Claim })
+	return out
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestReclaim_GraceTrashPurge(t *testing.T) {
+	dir := t.TempDir()
+	c := &Controller{cfg: Config{Mountpoint: dir, ReclaimEnabled: true, ReclaimGracePeriod: time.Hour, ReclaimRetention: time.Hour}}
+	claim := claimSpec{enabled: true, prefix: "teams/a", reclaim: "Delete", source: "service:a"}
+	data := filepath.Join(dir, "teams/a")
+	if err := os.MkdirAll(data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c.observeServiceClaims([]claimSpec{claim, {enabled: true, prefix: "teams/b", source: "service:b"}})
+	c.observeServiceClaims(nil)
+	st := c.reclaimSnapshot()
+	if len(st) != 1 || st[0].State != "released" {
+		t.Fatalf("expected one released claim (Retain claims untracked): %#v", st)
+	}
+
+	// within grace: nothing happens
+	c.processReclaims()
+	if _, err := os.Stat(data); err != nil {
+		t.Fatalf("data removed during grace period: %v", err)
+	}
+
+	// grace elapsed, dry-run only reports
+	c.reclaims["/teams/a"].status.DeleteAfter = time.Now().Add(-time.Minute)
+	c.cfg.ReclaimDryRun = true
+	c.processReclaims()
+	if _, err := os.Stat(data); err != nil {
+		t.Fatalf("dry-run must not move data: %v", err)
+	}
+
+	c.reclaims["/teams/a"].status.State = "released"
+	c.cfg.ReclaimDryRun = false
+	c.processReclaims()
+	st = c.reclaimSnapshot()
+	if len(st) != 1 || st[0].State != "trashed" {
+		t.Fatalf("expected trashed: %#v", st)
+	}
+	if _, err := os.Stat(st[0].TrashPath); err != nil {
+		t.Fatalf("trash path missing: %v", err)
+	}
+
+	// retention elapsed: purge by the timestamp in the trash path
+	old := filepath.Join(dir, trashDir, time.Now().Add(-2*time.Hour).UTC().Format(trashStampLayout))
+	if err := os.Rename(filepath.Dir(filepath.Dir(st[0].TrashPath)), old); err != nil {
+		t.Fatal(err)
+	}
+	c.reclaims["/teams/a"].status.TrashPath = filepath.Join(old, "teams/a")
+	c.processReclaims()
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("expected trash purged, stat err=%v", err)
+	}
+	if st := c.reclaimSnapshot(); len(st) != 0 {
+		t.Fatalf("purged claim should be forgotten: %#v", st)
+	}
+}
+
+func TestReclaim_ClaimBackAfterTrash(t *testing.T) {
+	dir := t.TempDir()
+	c := &Controller{cfg: Config{Mountpoint: dir, ReclaimEnabled: true, ReclaimDryRun: true, ReclaimRetention: time.Hour}}
+	claim := claimSpec{enabled: true, prefix: "teams/a", reclaim: "Delete", source: "service:a"}
+	if err := os.MkdirAll(filepath.Join(dir, "teams/a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	release := func() {
+		c.observeServiceClaims([]claimSpec{claim})
+		c.observeServiceClaims(nil)
+		c.reclaims["/teams/a"].status.DeleteAfter = time.Now().Add(-time.Minute)
+		c.processReclaims()
+	}
+
+	// dry-run: the would-be trash is no reason to ignore the claim later
+	release()
+	if st := c.reclaimSnapshot(); len(st) != 1 || st[0].State != "trashed" || !st[0].DryRun {
+		t.Fatalf("expected dry-run trashed: %#v", st)
+	}
+	c.observeServiceClaims([]claimSpec{claim})
+	if st := c.reclaimSnapshot(); len(st) != 1 || st[0].State != "bound" {
+		t.Fatalf("claim back after dry-run not tracked: %#v", st)
+	}
+	// and past the retention it is forgotten, nothing to purge
+	release()
+	c.reclaims["/teams/a"].status.PurgeAfter = time.Now().Add(-time.Minute)
+	c.processReclaims()
+	if st := c.reclaimSnapshot(); len(st) != 0 {
+		t.Fatalf("dry-run entry kept past retention: %#v", st)
+	}
+
+	// a claim on a trashed prefix is a new claim and tracked again
+	c.cfg.ReclaimDryRun = false
+	release()
+	st := c.reclaimSnapshot()
+	if len(st) != 1 || st[0].State != "trashed" {
+		t.Fatalf("expected trashed: %#v", st)
+	}
+	trash := st[0].TrashPath
+	c.observeServiceClaims([]claimSpec{claim})
+	if st := c.reclaimSnapshot(); len(st) != 1 || st[0].State != "bound" {
+		t.Fatalf("claim back after trash not tracked: %#v", st)
+	}
+	if _, err := os.Stat(trash); err != nil {
+		t.Fatalf("trash lost: %v", err)
+	}
+}
+
+func TestReclaimOwner(t *testing.T) {
+	var mu sync.Mutex
+	nodeID, manager, leader := "n1", true, true
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			_ = json.NewEncoder(w).Encode(map[string]any{"Swarm": map[string]any{"NodeID": nodeID, "ControlAvailable": manager}})
+		case strings.HasSuffix(r.URL.Path, "/nodes/n1"):
+			_ = json.NewEncoder(w).Encode(swarm.Node{ID: "n1", ManagerStatus: &swarm.ManagerStatus{Leader: leader}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &Controller{ctx: context.Background(), cli: cli}
+	for _, tc := range []struct {
+		node            string
+		manager, leader bool
+		want            bool
+	}{
+		{"n1", true, true, true},
+		{"n1", true, false, false},
+		{"n1", false, false, false},
+		{"", false, false, true}, // not in a swarm
+	} {
+		mu.Lock()
+		nodeID, manager, leader = tc.node, tc.manager, tc.leader
+		mu.Unlock()
+		if got := c.reclaimOwner(); got != tc.want {
+			t.Fatalf("node %q manager=%v leader=%v: owner=%v", tc.node, tc.manager, tc.leader, got)
+		}
+	}
+}
+
+func TestReclaim_RefusesLiveOrForeignPrefix(t *testing.T) {
+	dir := t.TempDir()
+	c := &Controller{cfg: Config{Mountpoint: dir, RcloneRemote: "S3:data", ReclaimEnabled: true}}
+	if err := os.MkdirAll(filepath.Join(dir, "teams/a/x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	del := claimSpec{enabled: true, prefix: "teams/a", reclaim: "Delete", source: "service:a"}
+
+	// a Retain service claim below the prefix keeps it
+	c.observeServiceClaims([]claimSpec{{enabled: true, prefix: "teams/a/x", source: "service:b"}})
+	if _, err := c.trashClaim(del, time.Now()); err == nil || !strings.Contains(err.Error(), "service:b") {
+		t.Fatalf("trashed under a live service claim: %v", err)
+	}
+	c.observeServiceClaims(nil)
+
+	// so does a container claim of the last reconcile and a plugin volume
+	c.recordClaims([]claimSpec{{enabled: true, bucket: "data", prefix: "teams/a", source: "container:app"}}, nil)
+	if _, err := c.trashClaim(del, time.Now()); err == nil {
+		t.Fatal("trashed under a live container claim")
+	}
+	c.recordClaims(nil, nil)
+	p := NewVolumePlugin(c, "")
+	if err := p.create("keep", map[string]string{"prefix": "teams/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.trashClaim(del, time.Now()); err == nil {
+		t.Fatal("trashed under a plugin volume")
+	}
+	delete(p.volumes, "keep")
+
+	// the shared mount only serves its own bucket
+	other := del
+	other.bucket = "other"
+	if _, err := c.trashClaim(other, time.Now()); err == nil {
+		t.Fatal("trashed a claim of another bucket")
+	}
+	if _, err := c.trashClaim(del, time.Now()); err != nil {
+		t.Fatalf("free prefix: %v", err)
+	}
+}
+
+// Reclaim and plugin volumes see claims with their class applied: a class
+// bucket decides which data a Delete claim owns.
+func TestReclaim_ResolvesClassBucket(t *testing.T) {
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			_, _ = w.Write([]byte(`[]`))
+		case strings.HasSuffix(r.URL.Path, "/services"):
+			_ = json.NewEncoder(w).Encode([]swarm.Service{{ID: "svc-a", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{
+				Name: "a", Labels: map[string]string{"volume-s3.enabled": "true", "volume-s3.prefix": "a", "volume-s3.class": "archive", "volume-s3.reclaim": "Delete"},
+			}}}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{
+		Mountpoint: t.TempDir(), RcloneRemote: "S3:shared", MounterMode: "per_claim",
+		ReadServiceLabels: true, ReclaimEnabled: true, ReclaimGracePeriod: time.Hour, ReclaimRetention: time.Hour,
+	}}
+	c.classes = map[string]StorageClass{"archive": {Bucket: "cold"}}
+
+	if _, err := c.discoverClaims(); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := c.reclaims["cold/a"]
+	if !ok || e.spec.bucket != "cold" {
+		t.Fatalf("reclaim tracks the unresolved claim: %v", c.reclaimSnapshot())
+	}
+	if holder := c.claimHolder(claimSpec{bucket: "cold", prefix: "a"}); holder != "service:a" {
+		t.Fatalf("holder of cold/a: %q", holder)
+	}
+	if holder := c.claimHolder(claimSpec{prefix: "a"}); holder != "" {
+		t.Fatalf("default bucket prefix held by the class claim: %q", holder)
+	}
+
+	p := NewVolumePlugin(c, "")
+	if err := p.create("v", map[string]string{"prefix": "b", "class": "archive", "reclaim": "Delete"}); err != nil {
+		t.Fatal(err)
+	}
+	if vi, err := p.get("v"); err != nil || vi.Status["bucket"] != "cold" {
+		t.Fatalf("plugin volume info: %+v %v", vi, err)
+	}
+	if holder := c.claimHolder(claimSpec{bucket: "cold", prefix: "b"}); holder != "volume:v" {
+		t.Fatalf("holder of cold/b: %q", holder)
+	}
+	// without its class the volume's data cannot be located: not reclaimed
+	c.classes = map[string]StorageClass{}
+	if err := p.remove("v"); err == nil {
+		t.Fatal("volume with an unknown class removed")
+	}
+}
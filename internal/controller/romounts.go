@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Read-only claims (volume-s3.access=ro). With the shared mounter the rw mount
+// is re-exposed per claim as a read-only bind mount under ReadOnlyMountRoot
+// (e.g. /mnt/s3-ro/<prefix>), created in the host mount namespace so it is
+// visible to application containers. Per-claim mounters enforce ro with
+// --read-only instead.
+
+func isReadOnlyClaim(cs claimSpec) bool {
+	return strings.EqualFold(strings.TrimSpace(cs.access), "ro")
+}
+
+func (c *Controller) readOnlyPath(cs claimSpec) string {
+	return filepath.Join(c.cfg.ReadOnlyMountRoot, filepath.Clean("/"+cs.prefix))
+}
+
+// claimEffectivePath is the host path applications should bind for the claim.
+func (c *Controller) claimEffectivePath(cs claimSpec) string {
+	if isReadOnlyClaim(cs) && !c.perClaimMounters() && strings.TrimSpace(c.cfg.ReadOnlyMountRoot) != "" {
+		return c.readOnlyPath(cs)
+	}
+	return c.claimPath(cs)
+}
+
+// roBindSh (re)binds $1 read-only at $2 in the host mount namespace. A bind
+// whose device differs from the live mount at $3 pins a dead FUSE connection
+// (the mounter was recreated) and is replaced. Paths are positional arguments,
+// never part of the script.
+const roBindSh = `dev() { awk -v p="$1" '$5 == p { d = $3 } END { print d }' /proc/self/mountinfo; }
+cur=$(dev "$2")
+if [ -n "$cur" ] && [ "$cur" = "$(dev "$3")" ]; then exit 0; fi
+if [ -n "$cur" ]; then umount -l "$2" || exit 1; fi
+mkdir -p "$2" && mount --bind "$1" "$2" && mount -o remount,bind,ro "$2"`
+
+// ensureReadOnlyBind bind-mounts the claim directory read-only at its
+// effective path (idempotent; stale binds are replaced).
+func (c *Controller) ensureReadOnlyBind(cs claimSpec) error {
+	dst := c.claimEffectivePath(cs)
+	src := c.claimPath(cs)
+	if dst == src {
+		return nil
+	}
+	if err := c.runHostHelper("ro-bind", "nsenter", "-t", "1", "-m", "--", "sh", "-c", roBindSh, "sh", src, dst, c.cfg.Mountpoint); err != nil {
+		return err
+	}
+	c.claims.mu.Lock()
+	if c.claims.roMounts == nil {
+		c.claims.roMounts = map[string]string{}
+	}
+	c.claims.roMounts[dst] = claimKey(cs)
+	c.claims.mu.Unlock()
+	return nil
+}
+
+// releaseReadOnlyBinds lazily unmounts ro binds whose claim is no longer active.
+func (c *Controller) releaseReadOnlyBinds(active map[string]struct{}) {
+	c.claims.mu.Lock()
+	var stale []string
+	for dst, key := range c.claims.roMounts {
+		if _, ok := active[key]; !ok {
+			stale = append(stale, dst)
+		}
+	}
+	c.claims.mu.Unlock()
+	for _, dst := range stale {
+		if err := c.runHostHelper("ro-unbind", "nsenter", "-t", "1", "-m", "--", "sh", "-c", `umount -l "$1" || true`, "sh", dst); err != nil {
+			slog.Warn("ro unbind", "path", dst, "error", err)
+			continue
+		}
+		slog.Info("ro unbind", "path", dst)
+		c.claims.mu.Lock()
+		delete(c.claims.roMounts, dst)
+		c.claims.mu.Unlock()
+	}
+}
+
+// loadReadOnlyBinds rebuilds the bind table from the host mountinfo after a
+// restart. The claims are unknown until the next reconcile binds them again;
+// binds of claims gone in the meantime are released then.
+func (c *Controller) loadReadOnlyBinds() {
+	root := strings.TrimSpace(c.cfg.ReadOnlyMountRoot)
+	if root == "" || c.perClaimMounters() {
+		return
+	}
+	out, err := c.hostHelperOutput("ro-scan", "nsenter", "-t", "1", "-m", "--", "cat", "/proc/self/mountinfo")
+	if err != nil {
+		slog.Warn("ro binds: read host mountinfo", "error", err)
+		return
+	}
+	dsts := mountsUnder(out, root)
+	if len(dsts) == 0 {
+		return
+	}
+	c.claims.mu.Lock()
+	defer c.claims.mu.Unlock()
+	if c.claims.roMounts == nil {
+		c.claims.roMounts = map[string]string{}
+	}
+	for _, dst := range dsts {
+		if _, ok := c.claims.roMounts[dst]; !ok {
+			c.claims.roMounts[dst] = ""
+		}
+	}
+	slog.Info("ro binds found", "root", root, "count", len(dsts))
+}
+
+// mountsUnder lists the mount points of a mountinfo table strictly below root.
+func mountsUnder(mountinfo, root string) []string {
+	root = filepath.Clean(root)
+	seen := map[string]bool{}
+	var out []string
+	for _, ln := range strings.Split(mountinfo, "\n") {
+		f := strings.Fields(ln)
+		if len(f) < 5 {
+			continue
+		}
+		mp := unescapeMountinfo(f[4])
+		if !strings.HasPrefix(mp, root+"/") || seen[mp] {
+			continue
+		}
+		seen[mp] = true
+		out = append(out, mp)
+	}
+	return out
+}
+
+// unescapeMountinfo decodes the octal escapes (\040 etc.) of mountinfo paths.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// runHostHelper runs cmd in a privileged helper container sharing the host
+// PID namespace and waits for it to finish. Paths go in as arguments; the
+// helper binds nothing, so a hung mount cannot block its start.
+func (c *Controller) runHostHelper(prefix string, cmd ...string) error {
+	_, err := c.hostHelperOutput(prefix, cmd...)
+	return err
+}
+
+// hostHelperOutput is runHostHelper returning the helper's stdout.
+func (c *Controller) hostHelperOutput(prefix string, cmd ...string) (string, error) {
+	if err := c.ensureImagePresent(c.helperImageRef()); err != nil {
+		return "", err
+	}
+	cctx, ccancel := c.timeoutCtx(20 * time.Second)
+	cont, err := c.cli.ContainerCreate(cctx,
+		&container.Config{Image: c.helperImageRef(), Cmd: cmd},
+		&container.HostConfig{Privileged: true, PidMode: "host"},
+		&network.NetworkingConfig{}, nil, c.helperName(prefix))
+	ccancel()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = c.cli.ContainerRemove(context.Background(), cont.ID, container.RemoveOptions{Force: true}) }()
+	ctx, cancel := c.timeoutCtx(30 * time.Second)
+	defer cancel()
+	if err := c.cli.ContainerStart(ctx, cont.ID, container.StartOptions{}); err != nil {
+		return "", err
+	}
+	statusCh, errCh := c.cli.ContainerWait(ctx, cont.ID, container.WaitConditionNotRunning)
+	var exitCode int64
+	select {
+	case err := <-errCh:
+		return "", err
+	case st := <-statusCh:
+		exitCode = st.StatusCode
+	}
+	var stdout, stderr strings.Builder
+	if rc, err := c.cli.ContainerLogs(ctx, cont.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true}); err == nil {
+		_, _ = stdcopy.StdCopy(&stdout, &stderr, rc)
+		_ = rc.Close()
+	}
+	if exitCode != 0 {
+		return stdout.String(), fmt.Errorf("%s helper exited %d: %s", prefix, exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
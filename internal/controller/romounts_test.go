@@ -0,0 +1,19 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMountsUnder(t *testing.T) {
+	mi := `22 1 0:21 / /mnt/s3 rw,relatime shared:1 - fuse.rclone s3: rw
+40 22 0:21 /teams/a /mnt/s3-ro/teams/a ro,relatime shared:1 - fuse.rclone s3: rw
+41 22 0:21 /b\040c /mnt/s3-ro/b\040c ro,relatime shared:1 - fuse.rclone s3: rw
+42 1 8:1 / /mnt/s3-ro rw - ext4 /dev/sda1 rw
+`
+	got := mountsUnder(mi, "/mnt/s3-ro/")
+	want := []string{"/mnt/s3-ro/teams/a", "/mnt/s3-ro/b c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
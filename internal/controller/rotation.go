@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swarmnative/volume-s3/internal/s3"
+)
+
+// Credential rotation. The default keys (AccessKeyFile, SecretKeyFile,
+// SessionTokenFile) are re-read periodically and compared by content hash.
+// New keys are only adopted after a signed HeadBucket accepts them; the
+// mounter env then changes, and spec drift rolls the mounter through the
+// usual drain (or staged) path. Rejected keys leave the active ones in place.
+
+const rotationHistory = 20
+
+// RotationEvent records one observed change of the default credentials.
+type RotationEvent struct {
+	At        time.Time
+	AccessKey string // masked
+	Result    string // rotated | rejected
+	Error     string `json:",omitempty"`
+}
+
+// CredentialStatus is the /status view of the default credentials.
+type CredentialStatus struct {
+	AccessKey      string // masked
+	ActiveSince    time.Time
+	RotationsTotal int64
+	FailuresTotal  int64
+	Events         []RotationEvent
+	STS            *STSStatus `json:",omitempty"`
+}
+
+type credState struct {
+	mu       sync.Mutex
+	loaded   bool
+	active   s3.Credentials
+	hash     string
+	since    time.Time
+	rejected string // hash of the last rejected set, logged once
+	total    int64
+	failed   int64
+	events   []RotationEvent
+}
+
+// maskKey keeps enough of an access key to tell keys apart.
+func maskKey(k string) string {
+	if len(k) < 12 {
+		return "****"
+	}
+	return k[:4] + "****" + k[len(k)-4:]
+}
+
+func credentialsHash(cr s3.Credentials) string {
+	sum := sha256.Sum256([]byte(cr.AccessKey + "\x00" + cr.SecretKey + "\x00" + cr.SessionToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// readDefaultCredentials reads the default keys from env and files.
+func (c *Controller) readDefaultCredentials() s3.Credentials {
+	access := strings.TrimSpace(os.Getenv("VOLS3_ACCESS_KEY"))
+	secret := strings.TrimSpace(os.Getenv("VOLS3_SECRET_KEY"))
+	token := strings.TrimSpace(os.Getenv("VOLS3_SESSION_TOKEN"))
+	read := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(b))
+	}
+	if access == "" {
+		access = read(c.cfg.AccessKeyFile)
+	}
+	if secret == "" {
+		secret = read(c.cfg.SecretKeyFile)
+	}
+	if token == "" {
+		token = read(c.cfg.SessionTokenFile)
+	}
+	return s3.Credentials{AccessKey: access, SecretKey: secret, SessionToken: token}
+}
+
+// defaultCredentials returns the active default keys; the first call adopts
+// whatever is on disk. With STS these are the current temporary keys.
+func (c *Controller) defaultCredentials() s3.Credentials {
+	if c.stsEnabled() {
+		return c.stsCredentials()
+	}
+	c.creds.mu.Lock()
+	defer c.creds.mu.Unlock()
+	if !c.creds.loaded {
+		c.creds.active = c.readDefaultCredentials()
+		c.creds.hash = credentialsHash(c.creds.active)
+		c.creds.since = time.Now().UTC()
+		c.creds.loaded = true
+	}
+	return c.creds.active
+}
+
+// defaultBucket is the bucket of cfg.RcloneRemote ("" when none).
+func (c *Controller) defaultBucket() string {
+	_, path, ok := strings.Cut(c.cfg.RcloneRemote, ":")
+	if !ok {
+		return ""
+	}
+	bucket, _, _ := strings.Cut(strings.Trim(path, "/"), "/")
+	return bucket
+}
+
+// validateCredentials checks keys with a signed HeadBucket. A missing bucket
+// still proves the signature was accepted. Other backends have no such
+// check and take new keys as they are.
+func (c *Controller) validateCredentials(cr s3.Credentials) error {
+	if !c.s3Backend() {
+		return nil
+	}
+	bucket := c.defaultBucket()
+	if bucket == "" {
+		return fmt.Errorf("no bucket in %q to validate against", c.cfg.RcloneRemote)
+	}
+	cl, err := s3.New(s3.Options{
+		Endpoint:    c.resolveEndpointForMounter(),
+		Region:      c.cfg.S3Region,
+		PathStyle:   c.cfg.S3PathStyle,
+		Credentials: cr,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.timeoutCtx(10 * time.Second)
+	defer cancel()
+	// HeadBucket maps 404 to (false, nil)
+	_, err = cl.HeadBucket(ctx, bucket)
+	return err
+}
+
+// checkCredentialRotation adopts changed default keys once the backend accepts
+// them. It returns true when the active keys changed.
+func (c *Controller) checkCredentialRotation() bool {
+	current := c.defaultCredentials()
+	next := c.readDefaultCredentials()
+	h := credentialsHash(next)
+	c.creds.mu.Lock()
+	same := h == c.creds.hash
+	c.creds.mu.Unlock()
+	if same || next.AccessKey == "" || next.SecretKey == "" {
+		return false
+	}
+
+	ev := RotationEvent{At: time.Now().UTC(), AccessKey: maskKey(next.AccessKey)}
+	err := c.validateCredentials(next)
+	c.creds.mu.Lock()
+	defer c.creds.mu.Unlock()
+	if err != nil {
+		// keep retrying (the backend may be slow to learn new keys) but
+		// record each rejected set only once
+		if c.creds.rejected == h {
+			return false
+		}
+		c.creds.rejected = h
+		c.creds.failed++
+		ev.Result, ev.Error = "rejected", err.Error()
+		slog.Error("credential rotation rejected", "access_key", ev.AccessKey, "active", maskKey(current.AccessKey), "error", err)
+	} else {
+		c.creds.active, c.creds.hash, c.creds.since, c.creds.rejected = next, h, ev.At, ""
+		c.creds.total++
+		ev.Result = "rotated"
+		slog.Warn("credentials rotated", "from", maskKey(current.AccessKey), "to", ev.AccessKey)
+	}
+	c.creds.events = append(c.creds.events, ev)
+	if n := len(c.creds.events); n > rotationHistory {
+		c.creds.events = c.creds.events[n-rotationHistory:]
+	}
+	return err == nil
+}
+
+// runCredentialWatch polls the key files and nudges a reconcile, which rolls
+// the mounter, after a rotation. With STS the key files only sign AssumeRole
+// and are re-read on every refresh instead.
+func (c *Controller) runCredentialWatch() {
+	if c.stsEnabled() {
+		return
+	}
+	every := c.cfg.CredentialCheckInterval
+	if every <= 0 {
+		every = 30 * time.Second
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-t.C:
+			if c.checkCredentialRotation() {
+				c.Nudge()
+			}
+		}
+	}
+}
+
+func (c *Controller) credentialStatus() CredentialStatus {
+	cr := c.defaultCredentials()
+	c.creds.mu.Lock()
+	defer c.creds.mu.Unlock()
+	return CredentialStatus{
+		AccessKey:      maskKey(cr.AccessKey),
+		ActiveSince:    c.creds.since,
+		RotationsTotal: c.creds.total,
+		FailuresTotal:  c.creds.failed,
+		Events:         append([]RotationEvent(nil), c.creds.events...),
+		STS:            c.stsStatus(),
+	}
+}
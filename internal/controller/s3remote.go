@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"context"
+	"strings"
+
+	"github.com/swarmnative/volume-s3/internal/s3"
+)
+
+// Bucket and prefix management goes through the native S3 client instead of
+// one-shot rclone containers: no container churn per claim and reconcile.
+
+// s3ClientFor returns a client for the claim's endpoint and credentials: the
+// endpoint of its class when set, the mounter endpoint otherwise.
+func (c *Controller) s3ClientFor(cs claimSpec) (*s3.Client, error) {
+	endpoint := c.resolveEndpointForMounter()
+	if sc, ok := c.lookupClass(strings.TrimSpace(cs.class)); ok && sc.Endpoint != "" {
+		endpoint = sc.Endpoint
+	}
+	creds := c.defaultCredentials()
+	if cs.credentials != "" {
+		var err error
+		if creds, err = c.credentialSet(cs.credentials); err != nil {
+			return nil, err
+		}
+	}
+	return s3.New(s3.Options{
+		Endpoint:    endpoint,
+		Region:      c.cfg.S3Region,
+		PathStyle:   c.cfg.S3PathStyle,
+		Credentials: creds,
+	})
+}
+
+// ensurePrefixMarker writes a "<prefix>/" directory marker unless the prefix
+// already holds objects, so the prefix shows up as a directory in the mount.
+func ensurePrefixMarker(ctx context.Context, cl *s3.Client, bucket, prefix string) error {
+	res, err := cl.ListObjectsV2(ctx, bucket, prefix+"/", "", 1, "")
+	if err != nil {
+		return err
+	}
+	if len(res.Keys) > 0 {
+		return nil
+	}
+	return cl.PutObject(ctx, bucket, prefix+"/", nil)
+}
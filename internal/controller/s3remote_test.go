@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEnsureRemotePaths_Native(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet:
+			_, _ = w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("VOLS3_ACCESS_KEY", "ak")
+	t.Setenv("VOLS3_SECRET_KEY", "sk")
+	c := &Controller{cfg: Config{S3Endpoint: srv.URL, S3PathStyle: true, AutoCreateBucket: true, AutoCreatePrefix: true}}
+	c.ctx = context.Background()
+
+	cs := claimSpec{enabled: true, bucket: "data", prefix: "teams/a"}
+	if err := c.ensureRemotePaths(cs); err != nil {
+		t.Fatal(err)
+	}
+	want := "HEAD /data|PUT /data|GET /data|PUT /data/teams/a/"
+	if got := strings.Join(calls, "|"); got != want {
+		t.Fatalf("calls\n got: %s\nwant: %s", got, want)
+	}
+	c.recordClaims([]claimSpec{cs}, nil)
+	if st, _ := c.Claim("data/teams/a"); st.RemoteMkdir != "ok" {
+		t.Fatalf("remote mkdir outcome: %+v", st)
+	}
+}
+
+func TestEnsureRemotePaths_ClassEndpoint(t *testing.T) {
+	hits := func(n *int, mu *sync.Mutex) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			*n++
+			mu.Unlock()
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+			}
+		}))
+	}
+	var mu sync.Mutex
+	var def, cls int
+	defSrv, clsSrv := hits(&def, &mu), hits(&cls, &mu)
+	defer defSrv.Close()
+	defer clsSrv.Close()
+	t.Setenv("VOLS3_ACCESS_KEY", "ak")
+	t.Setenv("VOLS3_SECRET_KEY", "sk")
+	c := &Controller{cfg: Config{S3Endpoint: defSrv.URL, S3PathStyle: true, AutoCreatePrefix: true}}
+	c.ctx = context.Background()
+	c.classes = map[string]StorageClass{"archive": {Endpoint: clsSrv.URL}}
+
+	if err := c.ensureRemotePaths(claimSpec{enabled: true, bucket: "data", prefix: "a", class: "archive"}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if cls == 0 || def != 0 {
+		t.Fatalf("class endpoint calls=%d, default endpoint calls=%d", cls, def)
+	}
+}
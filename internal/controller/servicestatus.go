@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// Claim status on services (ServiceStatusLabels). After each reconcile the
+// state of every service claim in the claim table is written to the service:
+// volume-s3.status (Bound|Pending|Error), volume-s3.status.message and
+// volume-s3.status.path, plus volume-s3.status.node naming the writer. Label
+// updates do not restart tasks. Services are only updated when a value
+// changes, and every node provisioning the claim reports it, so nodes defer
+// to each other: a node replaces another node's status only with a more
+// severe one, or once the other node no longer runs a task of the service.
+// The service events caused by our own updates are not turned into
+// reconciles (see watchDockerEvents).
+
+const (
+	statusLabel        = "volume-s3.status"
+	statusMessageLabel = "volume-s3.status.message"
+	statusPathLabel    = "volume-s3.status.path"
+	statusNodeLabel    = "volume-s3.status.node"
+)
+
+// ownUpdateWindow is how long service events are attributed to our update.
+const ownUpdateWindow = 10 * time.Second
+
+type serviceStatusState struct {
+	mu  sync.Mutex
+	own map[string]time.Time // service ID -> our last label update
+}
+
+// isStatusLabel reports whether a label key (without prefix) is written by
+// the controller rather than declared by the user.
+func isStatusLabel(base string) bool {
+	return base == statusLabel || strings.HasPrefix(base, statusLabel+".")
+}
+
+// claimStateRank orders claim states by severity.
+func claimStateRank(state string) int {
+	switch state {
+	case "Error":
+		return 2
+	case "Pending":
+		return 1
+	}
+	return 0
+}
+
+// serviceStatusLabels are the status labels of one claim.
+func serviceStatusLabels(st ClaimStatus, node string) map[string]string {
+	msg := st.Message
+	if st.State == "Error" {
+		msg = st.Error
+	}
+	return map[string]string{
+		statusLabel:        st.State,
+		statusMessageLabel: msg,
+		statusPathLabel:    st.Path,
+		statusNodeLabel:    node,
+	}
+}
+
+// statusChanged reports whether the labels differ from want.
+func statusChanged(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return true
+		}
+	}
+	return false
+}
+
+// noteOwnServiceUpdate records that we are about to update a service.
+func (c *Controller) noteOwnServiceUpdate(id string) {
+	c.svcStatus.mu.Lock()
+	defer c.svcStatus.mu.Unlock()
+	if c.svcStatus.own == nil {
+		c.svcStatus.own = map[string]time.Time{}
+	}
+	now := time.Now()
+	c.svcStatus.own[id] = now
+	for k, at := range c.svcStatus.own {
+		if now.Sub(at) > ownUpdateWindow {
+			delete(c.svcStatus.own, k)
+		}
+	}
+}
+
+// ownServiceEvent reports whether a Docker event is the echo of our own
+// service update.
+func (c *Controller) ownServiceEvent(m events.Message) bool {
+	if m.Type != events.ServiceEventType || m.Action != events.ActionUpdate {
+		return false
+	}
+	c.svcStatus.mu.Lock()
+	defer c.svcStatus.mu.Unlock()
+	at, ok := c.svcStatus.own[m.Actor.ID]
+	return ok && time.Since(at) <= ownUpdateWindow
+}
+
+// serviceClaimStatus returns the claim of each service in the claim table.
+func (c *Controller) serviceClaimStatus() map[string]ClaimStatus {
+	out := map[string]ClaimStatus{}
+	for _, st := range c.claimsSnapshot() {
+		for _, src := range st.Sources {
+			if name, ok := strings.CutPrefix(src, "service:"); ok {
+				out[name] = st
+			}
+		}
+	}
+	return out
+}
+
+// writeServiceStatus annotates the services of the claim table. It needs the
+// manager API: locally on a manager or through ManagerDockerHost.
+func (c *Controller) writeServiceStatus() {
+	if !c.cfg.ServiceStatusLabels {
+		return
+	}
+	node := c.swarmNodeID()
+	if node == "" || (c.managerCli == nil && !c.swarmManager()) {
+		return
+	}
+	claims := c.serviceClaimStatus()
+	if len(claims) == 0 {
+		return
+	}
+	f := filters.NewArgs()
+	for name := range claims {
+		f.Add("name", name)
+	}
+	svcs, err := c.swarmCli().ServiceList(c.ctx, types.ServiceListOptions{Filters: f})
+	if err != nil {
+		slog.Warn("service status: list services", "error", err)
+		return
+	}
+	for _, svc := range svcs {
+		st, ok := claims[svc.Spec.Name]
+		if !ok {
+			continue // name filters match prefixes
+		}
+		want := serviceStatusLabels(st, node)
+		if !statusChanged(svc.Spec.Labels, want) || !c.mayOverwriteStatus(svc, st.State, node) {
+			continue
+		}
+		spec := svc.Spec
+		labels := make(map[string]string, len(spec.Labels)+len(want))
+		for k, v := range spec.Labels {
+			labels[k] = v
+		}
+		for k, v := range want {
+			labels[k] = v
+		}
+		spec.Labels = labels
+		c.noteOwnServiceUpdate(svc.ID)
+		ctx, cancel := c.timeoutCtx(30 * time.Second)
+		_, err := c.swarmCli().ServiceUpdate(ctx, svc.ID, svc.Version, spec, types.ServiceUpdateOptions{})
+		cancel()
+		if err != nil {
+			// a concurrent update moved the version on; retried next reconcile
+			slog.Warn("service status: update service", "service", svc.Spec.Name, "error", err)
+			continue
+		}
+		slog.Info("service status", "service", svc.Spec.Name, "state", st.State, "path", st.Path, "message", want[statusMessageLabel])
+	}
+}
+
+// mayOverwriteStatus decides whether this node may replace the status on a
+// service: its own or missing status, a more severe state, or a status from a
+// node no longer running a task of the service.
+func (c *Controller) mayOverwriteStatus(svc swarm.Service, state, node string) bool {
+	writer := svc.Spec.Labels[statusNodeLabel]
+	if writer == "" || writer == node || claimStateRank(state) > claimStateRank(svc.Spec.Labels[statusLabel]) {
+		return true
+	}
+	tasks, err := c.swarmCli().TaskList(c.ctx, types.TaskListOptions{Filters: filters.NewArgs(
+		filters.Arg("service", svc.ID),
+		filters.Arg("node", writer),
+		filters.Arg("desired-state", "running"),
+	)})
+	if err != nil {
+		slog.Warn("service status: list tasks", "service", svc.Spec.Name, "node", writer, "error", err)
+		return false
+	}
+	return len(tasks) == 0
+}
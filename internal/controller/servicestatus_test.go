@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestWriteServiceStatus(t *testing.T) {
+	var mu sync.Mutex
+	svcs := map[string]*swarm.Service{
+		"svc-web": {ID: "svc-web", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "web", Labels: map[string]string{"volume-s3.enabled": "true", "volume-s3.prefix": "web"}}}},
+		// n2 reported an error and still runs a task
+		"svc-db": {ID: "svc-db", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "db", Labels: map[string]string{"volume-s3.enabled": "true", "volume-s3.prefix": "db", statusLabel: "Error", statusNodeLabel: "n2"}}}},
+	}
+	n2Task := true
+	updates := map[string]int{}
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			_ = json.NewEncoder(w).Encode(map[string]any{"Swarm": map[string]any{"NodeID": "n1", "ControlAvailable": true}})
+		case strings.HasSuffix(r.URL.Path, "/services"):
+			out := []swarm.Service{}
+			for _, s := range svcs {
+				out = append(out, *s)
+			}
+			_ = json.NewEncoder(w).Encode(out)
+		case strings.HasSuffix(r.URL.Path, "/tasks"):
+			out := []swarm.Task{}
+			if n2Task && strings.Contains(r.URL.Query().Get("filters"), `"n2"`) {
+				out = append(out, swarm.Task{ID: "t2", ServiceID: "svc-db", NodeID: "n2"})
+			}
+			_ = json.NewEncoder(w).Encode(out)
+		case strings.HasSuffix(r.URL.Path, "/update"):
+			id := strings.Split(r.URL.Path, "/")[len(strings.Split(r.URL.Path, "/"))-2]
+			updates[id]++
+			_ = json.NewDecoder(r.Body).Decode(&svcs[id].Spec)
+			svcs[id].Version.Index++
+			_ = json.NewEncoder(w).Encode(swarm.ServiceUpdateResponse{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{Mountpoint: "/mnt/s3", RcloneRemote: "S3:data", ServiceStatusLabels: true}}
+	c.recordClaims([]claimSpec{
+		{enabled: true, prefix: "web", source: "service:web"},
+		{enabled: true, prefix: "db", source: "service:db"},
+	}, nil)
+
+	c.writeServiceStatus()
+	web := svcs["svc-web"].Spec.Labels
+	if updates["svc-web"] != 1 || web[statusLabel] != "Bound" || web[statusPathLabel] != "/mnt/s3/web" || web[statusNodeLabel] != "n1" {
+		t.Fatalf("web: updates=%d labels=%v", updates["svc-web"], web)
+	}
+	if web["volume-s3.prefix"] != "web" {
+		t.Fatal("user labels lost")
+	}
+	if updates["svc-db"] != 0 {
+		t.Fatal("error of a node still running the service overwritten")
+	}
+	if !c.ownServiceEvent(events.Message{Type: events.ServiceEventType, Action: events.ActionUpdate, Actor: events.Actor{ID: "svc-web"}}) {
+		t.Fatal("own update event not recognized")
+	}
+	if c.ownServiceEvent(events.Message{Type: events.ServiceEventType, Action: events.ActionUpdate, Actor: events.Actor{ID: "svc-db"}}) {
+		t.Fatal("foreign update event swallowed")
+	}
+
+	// unchanged status is not written again; the erroring node went away
+	mu.Lock()
+	n2Task = false
+	mu.Unlock()
+	c.writeServiceStatus()
+	if updates["svc-web"] != 1 || updates["svc-db"] != 1 || svcs["svc-db"].Spec.Labels[statusLabel] != "Bound" {
+		t.Fatalf("second pass: updates=%v db=%v", updates, svcs["svc-db"].Spec.Labels)
+	}
+
+	// a claim error is more severe and replaces the status
+	errTest := errors.New("mkdir failed")
+	c.recordClaims([]claimSpec{{enabled: true, prefix: "web", source: "service:web"}}, map[string]error{"/web": errTest})
+	c.writeServiceStatus()
+	if web := svcs["svc-web"].Spec.Labels; web[statusLabel] != "Error" || web[statusMessageLabel] != errTest.Error() {
+		t.Fatalf("error status: %v", web)
+	}
+}
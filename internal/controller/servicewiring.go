@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// Automatic service wiring (AutoWireServices). On a manager the controller
+// completes labelled services itself: a service with volume-s3.enabled=true,
+// volume-s3.prefix and volume-s3.target gets the rshared bind of the claim
+// path (<Mountpoint>/<prefix>, or the ro path of access=ro claims) at the
+// target and the mount placement constraint, via ServiceUpdate against the
+// listed version. Each service version is planned once: our own update bumps
+// the version, the next pass finds nothing left to add and stops there. With
+// AutoWireDryRun the planned changes are only logged and listed in /status.
+
+// defaultWireConstraint is the placement constraint of the mount nodes.
+const defaultWireConstraint = "node.labels.mount_s3 == true"
+
+// ServiceWiringStatus reports the wiring of one labelled service in /status.
+type ServiceWiringStatus struct {
+	Service string
+	Version uint64
+	Changes []string  `json:",omitempty"`
+	DryRun  bool      `json:",omitempty"`
+	Wired   time.Time `json:",omitempty"`
+	Error   string    `json:",omitempty"`
+}
+
+type wiringState struct {
+	mu       sync.Mutex
+	seen     map[string]uint64 // service ID -> version index planned
+	statuses map[string]ServiceWiringStatus
+}
+
+func (c *Controller) wireConstraint() string {
+	if s := strings.TrimSpace(c.cfg.AutoWireConstraint); s != "" {
+		return s
+	}
+	return defaultWireConstraint
+}
+
+// wiringPlan adds the claim bind and the placement constraint to spec and
+// lists what was added. A different mount at target is an error: the
+// service's own mounts are never replaced.
+func wiringPlan(spec *swarm.ServiceSpec, src, target string, ro bool, constraint string) ([]string, error) {
+	cs := spec.TaskTemplate.ContainerSpec
+	if cs == nil {
+		return nil, fmt.Errorf("not a container service")
+	}
+	var changes []string
+	bound := false
+	for _, m := range cs.Mounts {
+		if m.Target != target {
+			continue
+		}
+		if m.Type != mount.TypeBind || m.Source != src {
+			return nil, fmt.Errorf("target %s already mounted from %s", target, m.Source)
+		}
+		bound = true
+	}
+	if !bound {
+		cs.Mounts = append(cs.Mounts, mount.Mount{
+			Type:        mount.TypeBind,
+			Source:      src,
+			Target:      target,
+			ReadOnly:    ro,
+			BindOptions: &mount.BindOptions{Propagation: mount.PropagationRShared},
+		})
+		mode := "rw"
+		if ro {
+			mode = "ro"
+		}
+		changes = append(changes, fmt.Sprintf("+mount bind %s:%s:%s,rshared", src, target, mode))
+	}
+	if spec.TaskTemplate.Placement == nil {
+		spec.TaskTemplate.Placement = &swarm.Placement{}
+	}
+	pl := spec.TaskTemplate.Placement
+	norm := func(s string) string { return strings.Join(strings.Fields(s), "") }
+	has := false
+	for _, ct := range pl.Constraints {
+		has = has || norm(ct) == norm(constraint)
+	}
+	if !has {
+		pl.Constraints = append(pl.Constraints, constraint)
+		changes = append(changes, "+constraint "+constraint)
+	}
+	return changes, nil
+}
+
+// wireServices plans and applies the wiring of labelled services. It only
+// runs on a manager; failed updates are retried on the next reconcile.
+func (c *Controller) wireServices() {
+	if !c.cfg.AutoWireServices || !c.swarmManager() {
+		return
+	}
+	svcs, err := c.swarmCli().ServiceList(c.ctx, types.ServiceListOptions{})
+	if err != nil {
+		slog.Warn("service wiring: list services", "error", err)
+		return
+	}
+	c.wiring.mu.Lock()
+	if c.wiring.seen == nil {
+		c.wiring.seen = map[string]uint64{}
+		c.wiring.statuses = map[string]ServiceWiringStatus{}
+	}
+	live := map[string]bool{}
+	var todo []swarm.Service
+	for _, svc := range svcs {
+		live[svc.ID] = true
+		if v, ok := c.wiring.seen[svc.ID]; !ok || v != svc.Version.Index {
+			todo = append(todo, svc)
+		}
+	}
+	for id := range c.wiring.seen {
+		if !live[id] {
+			delete(c.wiring.seen, id)
+			delete(c.wiring.statuses, id)
+		}
+	}
+	c.wiring.mu.Unlock()
+
+	for _, svc := range todo {
+		st, done := c.wireService(svc)
+		c.wiring.mu.Lock()
+		if done {
+			c.wiring.seen[svc.ID] = svc.Version.Index
+		}
+		if st.Service == "" {
+			delete(c.wiring.statuses, svc.ID)
+		} else {
+			if prev, ok := c.wiring.statuses[svc.ID]; ok && st.Wired.IsZero() {
+				st.Wired = prev.Wired
+			}
+			c.wiring.statuses[svc.ID] = st
+		}
+		c.wiring.mu.Unlock()
+	}
+}
+
+// wireService handles one service version; done is false when the update
+// should be retried. An empty status means the service is not wired.
+func (c *Controller) wireService(svc swarm.Service) (st ServiceWiringStatus, done bool) {
+	labels := c.parseLabels(svc.Spec.Labels)
+	target := strings.TrimSpace(labels["volume-s3.target"])
+	cs := claimFromLabels(labels)
+	if !cs.enabled || target == "" {
+		return st, true
+	}
+	st = ServiceWiringStatus{Service: svc.Spec.Name, Version: svc.Version.Index, DryRun: c.cfg.AutoWireDryRun}
+	if cs.prefix == "" {
+		st.Error = "volume-s3.target needs volume-s3.prefix"
+		return st, true
+	}
+	cs, err := c.resolveClaim(cs)
+	if err != nil {
+		st.Error = err.Error()
+		return st, true
+	}
+	spec := svc.Spec
+	changes, err := wiringPlan(&spec, c.claimEffectivePath(cs), target, isReadOnlyClaim(cs), c.wireConstraint())
+	if err != nil {
+		st.Error = err.Error()
+		slog.Warn("service wiring", "service", svc.Spec.Name, "error", err)
+		return st, true
+	}
+	st.Changes = changes
+	if len(changes) == 0 {
+		return st, true
+	}
+	if c.cfg.AutoWireDryRun {
+		slog.Info("service wiring: dry-run, would update service", "service", svc.Spec.Name, "version", svc.Version.Index, "changes", strings.Join(changes, "; "))
+		return st, true
+	}
+	ctx, cancel := c.timeoutCtx(30 * time.Second)
+	defer cancel()
+	resp, err := c.swarmCli().ServiceUpdate(ctx, svc.ID, svc.Version, spec, types.ServiceUpdateOptions{})
+	if err != nil {
+		st.Error = err.Error()
+		slog.Warn("service wiring: update service", "service", svc.Spec.Name, "error", err)
+		return st, false
+	}
+	for _, w := range resp.Warnings {
+		slog.Warn("service wiring: update warning", "service", svc.Spec.Name, "warning", w)
+	}
+	st.Wired = time.Now().UTC()
+	slog.Info("service wiring: service updated", "service", svc.Spec.Name, "version", svc.Version.Index, "changes", strings.Join(changes, "; "))
+	return st, true
+}
+
+func (c *Controller) wiringSnapshot() []ServiceWiringStatus {
+	c.wiring.mu.Lock()
+	defer c.wiring.mu.Unlock()
+	out := make([]ServiceWiringStatus, 0, len(c.wiring.statuses))
+	for _, st := range c.wiring.statuses {
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestWiringPlan(t *testing.T) {
+	spec := swarm.ServiceSpec{TaskTemplate: swarm.TaskSpec{
+		ContainerSpec: &swarm.ContainerSpec{Image: "app"},
+		Placement:     &swarm.Placement{Constraints: []string{"node.role==worker"}},
+	}}
+	changes, err := wiringPlan(&spec, "/mnt/s3/teams/a", "/data", false, defaultWireConstraint)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("changes=%v err=%v", changes, err)
+	}
+	m := spec.TaskTemplate.ContainerSpec.Mounts
+	if len(m) != 1 || m[0].Source != "/mnt/s3/teams/a" || m[0].BindOptions.Propagation != mount.PropagationRShared {
+		t.Fatalf("mounts: %+v", m)
+	}
+	if c := spec.TaskTemplate.Placement.Constraints; len(c) != 2 || c[1] != defaultWireConstraint {
+		t.Fatalf("constraints: %v", c)
+	}
+	// applied specs plan nothing; spacing in constraints does not matter
+	spec.TaskTemplate.Placement.Constraints[1] = "node.labels.mount_s3==true"
+	if changes, err := wiringPlan(&spec, "/mnt/s3/teams/a", "/data", false, defaultWireConstraint); err != nil || len(changes) != 0 {
+		t.Fatalf("second plan: %v %v", changes, err)
+	}
+	if _, err := wiringPlan(&spec, "/mnt/s3/teams/b", "/data", false, defaultWireConstraint); err == nil {
+		t.Fatal("foreign mount at target replaced")
+	}
+}
+
+// fakeManager answers /info, /services and /services/<id>/update like a
+// manager holding one labelled service; updates bump its version.
+func fakeManager(t *testing.T, updates *int) http.HandlerFunc {
+	var mu sync.Mutex
+	svc := swarm.Service{ID: "svc-web", Spec: swarm.ServiceSpec{
+		Annotations:  swarm.Annotations{Name: "web", Labels: map[string]string{"volume-s3.enabled": "true", "volume-s3.prefix": "web", "volume-s3.target": "/data"}},
+		TaskTemplate: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: "app"}},
+	}}
+	svc.Version.Index = 7
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			_ = json.NewEncoder(w).Encode(map[string]any{"Swarm": map[string]any{"NodeID": "n1", "ControlAvailable": true}})
+		case strings.HasSuffix(r.URL.Path, "/services"):
+			_ = json.NewEncoder(w).Encode([]swarm.Service{svc})
+		case strings.HasSuffix(r.URL.Path, "/services/svc-web/update"):
+			if v := r.URL.Query().Get("version"); v != strconv.FormatUint(svc.Version.Index, 10) {
+				t.Errorf("update against version %s", v)
+			}
+			*updates++
+			_ = json.NewDecoder(r.Body).Decode(&svc.Spec)
+			svc.Version.Index++
+			_ = json.NewEncoder(w).Encode(swarm.ServiceUpdateResponse{})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestWireServices(t *testing.T) {
+	var updates int
+	cli := fakeDocker(t, fakeManager(t, &updates))
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{Mountpoint: "/mnt/s3", AutoWireServices: true, AutoWireDryRun: true}}
+
+	c.wireServices()
+	st := c.wiringSnapshot()
+	if updates != 0 || len(st) != 1 || !st[0].DryRun || len(st[0].Changes) != 2 || !strings.Contains(st[0].Changes[0], "/mnt/s3/web:/data") {
+		t.Fatalf("dry-run: updates=%d status=%+v", updates, st)
+	}
+
+	c.cfg.AutoWireDryRun = false
+	c.wireServices()
+	if updates != 0 {
+		t.Fatal("planned version updated again")
+	}
+	c.wiring.seen = nil
+	c.wireServices()
+	if updates != 1 {
+		t.Fatalf("updates: %d", updates)
+	}
+	// the update bumped the version: planned once more, nothing left to add
+	c.wireServices()
+	c.wireServices()
+	st = c.wiringSnapshot()
+	if updates != 1 || len(st) != 1 || st[0].Version != 8 || len(st[0].Changes) != 0 || st[0].Wired.IsZero() {
+		t.Fatalf("after update: updates=%d status=%+v", updates, st)
+	}
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// Spec drift. Every mounter carries a hash of the container spec it was
+// created from (cmd, env, binds, network, caps). ensureMounterSpec recomputes
+// the hash from the current Config and recreates the mounter when it differs,
+// so changes to rclone args, presets, access mode, provider or credentials
+// take effect without removing containers by hand. Mounters without the label
+// (created by older versions) count as changed.
+
+const mounterSpecHashLabel = "swarmnative.mounter.spec-hash"
+
+// mounterSpecView is the canonical, order-independent form of a mounter spec.
+type mounterSpecView struct {
+	Image       string
+	Cmd         []string
+	Env         []string
+	Binds       []string
+	NetworkMode string
+	Networks    []string
+	CapAdd      []string
+	SecurityOpt []string
+	Devices     []string
+	Privileged  bool
+	Restart     string
+}
+
+func sortedCopy(in []string) []string {
+	out := append([]string(nil), in...)
+	sort.Strings(out)
+	return out
+}
+
+func specView(cfg *container.Config, hostCfg *container.HostConfig, netCfg *network.NetworkingConfig) mounterSpecView {
+	v := mounterSpecView{
+		Image:       cfg.Image,
+		Cmd:         append([]string(nil), cfg.Cmd...),
+		Env:         sortedCopy(cfg.Env),
+		Binds:       sortedCopy(hostCfg.Binds),
+		NetworkMode: string(hostCfg.NetworkMode),
+		CapAdd:      sortedCopy(hostCfg.CapAdd),
+		SecurityOpt: sortedCopy(hostCfg.SecurityOpt),
+		Privileged:  hostCfg.Privileged,
+		Restart:     string(hostCfg.RestartPolicy.Name),
+	}
+	for _, d := range hostCfg.Resources.Devices {
+		v.Devices = append(v.Devices, d.PathOnHost+":"+d.PathInContainer+":"+d.CgroupPermissions)
+	}
+	sort.Strings(v.Devices)
+	if netCfg != nil {
+		for n := range netCfg.EndpointsConfig {
+			v.Networks = append(v.Networks, n)
+		}
+		sort.Strings(v.Networks)
+	}
+	return v
+}
+
+func (v mounterSpecView) hash() string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// mounterSpecHash returns the hash of the desired spec for ms and the view it
+// was computed from.
+func (c *Controller) mounterSpecHash(ms mounterSpec) (string, mounterSpecView, error) {
+	cfg, hostCfg, netCfg, err := c.mounterContainer(ms)
+	if err != nil {
+		return "", mounterSpecView{}, err
+	}
+	v := specView(cfg, hostCfg, netCfg)
+	return v.hash(), v, nil
+}
+
+// runningSpecView reconstructs the view of an existing container for diffs.
+// Docker merges the image's env into the container's, so only keys the
+// desired spec knows or rclone's own are kept.
+func runningSpecView(insp types.ContainerJSON, desired mounterSpecView) mounterSpecView {
+	cfg := &container.Config{}
+	if insp.Config != nil {
+		cfg = insp.Config
+	}
+	hostCfg := &container.HostConfig{}
+	if insp.HostConfig != nil {
+		hostCfg = insp.HostConfig
+	}
+	var netCfg *network.NetworkingConfig
+	if insp.NetworkSettings != nil {
+		netCfg = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+		for n := range insp.NetworkSettings.Networks {
+			if n != string(hostCfg.NetworkMode) {
+				netCfg.EndpointsConfig[n] = nil
+			}
+		}
+	}
+	v := specView(cfg, hostCfg, netCfg)
+	// a staged mounter mounts its staging dir in place of the live path
+	if src, live := cfg.Labels[mounterSourceLabel], cfg.Labels["swarmnative.mounter.path"]; src != "" && live != "" {
+		for i := range v.Cmd {
+			v.Cmd[i] = strings.ReplaceAll(v.Cmd[i], src, live)
+		}
+		for i := range v.Binds {
+			v.Binds[i] = strings.ReplaceAll(v.Binds[i], src, live)
+		}
+		sort.Strings(v.Binds)
+	}
+	known := map[string]bool{}
+	for _, e := range desired.Env {
+		known[envKey(e)] = true
+	}
+	var env []string
+	for _, e := range v.Env {
+		if k := envKey(e); known[k] || strings.HasPrefix(k, "RCLONE_") {
+			env = append(env, e)
+		}
+	}
+	v.Env = env
+	return v
+}
+
+func envKey(e string) string {
+	k, _, _ := strings.Cut(e, "=")
+	return k
+}
+
+// diffSpecViews lists what changed from old to new. Env values are never
+// printed, only the names of changed variables, as they carry secrets.
+func diffSpecViews(old, new mounterSpecView) []string {
+	var out []string
+	if old.Image != new.Image {
+		out = append(out, fmt.Sprintf("image: %s -> %s", old.Image, new.Image))
+	}
+	if strings.Join(old.Cmd, " ") != strings.Join(new.Cmd, " ") {
+		added, removed := setDiff(old.Cmd, new.Cmd)
+		out = append(out, fmt.Sprintf("cmd: +%v -%v", added, removed))
+	}
+	oldEnv, newEnv := map[string]string{}, map[string]string{}
+	for _, e := range old.Env {
+		oldEnv[envKey(e)] = e
+	}
+	for _, e := range new.Env {
+		newEnv[envKey(e)] = e
+	}
+	var changed []string
+	for k, e := range newEnv {
+		if oldEnv[k] != e {
+			changed = append(changed, k)
+		}
+	}
+	for k := range oldEnv {
+		if _, ok := newEnv[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		out = append(out, "env: "+strings.Join(changed, ","))
+	}
+	for _, f := range []struct {
+		name     string
+		old, new []string
+	}{
+		{"binds", old.Binds, new.Binds},
+		{"networks", old.Networks, new.Networks},
+		{"cap_add", old.CapAdd, new.CapAdd},
+		{"security_opt", old.SecurityOpt, new.SecurityOpt},
+		{"devices", old.Devices, new.Devices},
+	} {
+		if added, removed := setDiff(f.old, f.new); len(added)+len(removed) > 0 {
+			out = append(out, fmt.Sprintf("%s: +%v -%v", f.name, added, removed))
+		}
+	}
+	if old.NetworkMode != new.NetworkMode {
+		out = append(out, fmt.Sprintf("network_mode: %s -> %s", old.NetworkMode, new.NetworkMode))
+	}
+	if old.Privileged != new.Privileged {
+		out = append(out, fmt.Sprintf("privileged: %t -> %t", old.Privileged, new.Privileged))
+	}
+	if old.Restart != new.Restart {
+		out = append(out, fmt.Sprintf("restart: %s -> %s", old.Restart, new.Restart))
+	}
+	return out
+}
+
+// setDiff returns the elements only in b (added) and only in a (removed).
+func setDiff(a, b []string) (added, removed []string) {
+	in := func(xs []string, x string) bool {
+		for _, y := range xs {
+			if y == x {
+				return true
+			}
+		}
+		return false
+	}
+	for _, x := range b {
+		if !in(a, x) {
+			added = append(added, x)
+		}
+	}
+	for _, x := range a {
+		if !in(b, x) {
+			removed = append(removed, x)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestMounterSpecHashDrift(t *testing.T) {
+	c := &Controller{cfg: Config{S3Endpoint: "http://s3:9000", MounterImage: "rclone/rclone", RcloneExtraArgs: "--dir-cache-time=1h"}}
+	ms := c.sharedMounterSpec()
+	ms.mountpoint = "/mnt/s3"
+	h1, _, err := c.mounterSpecHash(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h2, _, _ := c.mounterSpecHash(ms); h1 != h2 {
+		t.Fatal("hash must be stable")
+	}
+	for name, mutate := range map[string]func(*Controller){
+		"args":        func(c *Controller) { c.cfg.RcloneExtraArgs = "--dir-cache-time=2h" },
+		"allow_other": func(c *Controller) { c.cfg.AllowOther = true },
+		"provider":    func(c *Controller) { c.cfg.S3Provider = "Minio" },
+		"endpoint":    func(c *Controller) { c.cfg.S3Endpoint = "http://s3b:9000" },
+		"network":     func(c *Controller) { c.cfg.ProxyNetwork = "s3_net" },
+	} {
+		c2 := &Controller{cfg: c.cfg}
+		mutate(c2)
+		ms2 := c2.sharedMounterSpec()
+		ms2.mountpoint = "/mnt/s3"
+		if h, _, _ := c2.mounterSpecHash(ms2); h == h1 {
+			t.Errorf("%s change not reflected in hash", name)
+		}
+	}
+	ro := ms
+	ro.readOnly = true
+	if h, _, _ := c.mounterSpecHash(ro); h == h1 {
+		t.Error("read-only change not reflected in hash")
+	}
+}
+
+func TestSpecViewOrderIndependent(t *testing.T) {
+	a := specView(&container.Config{Env: []string{"A=1", "B=2"}}, &container.HostConfig{Binds: []string{"/x:/x", "/y:/y"}}, nil)
+	b := specView(&container.Config{Env: []string{"B=2", "A=1"}}, &container.HostConfig{Binds: []string{"/y:/y", "/x:/x"}}, nil)
+	if a.hash() != b.hash() {
+		t.Fatal("env/bind order must not change the hash")
+	}
+}
+
+func TestDiffSpecViews(t *testing.T) {
+	c := &Controller{cfg: Config{S3Endpoint: "http://s3:9000", MounterImage: "rclone/rclone"}}
+	ms := c.sharedMounterSpec()
+	ms.mountpoint = "/mnt/s3"
+	cfg, hostCfg, _, err := c.mounterContainer(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// what Docker reports back: image env merged in
+	cfg.Env = append(cfg.Env, "PATH=/usr/bin", "XDG_CONFIG_HOME=/config")
+	running := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{HostConfig: hostCfg}, Config: cfg}
+
+	c.cfg.S3Endpoint = "http://s3b:9000"
+	c.cfg.ReadOnly = true
+	ms = c.sharedMounterSpec()
+	ms.mountpoint = "/mnt/s3"
+	_, desired, _ := c.mounterSpecHash(ms)
+	diff := diffSpecViews(runningSpecView(running, desired), desired)
+	got := strings.Join(diff, "\n")
+	if !strings.Contains(got, "cmd: +[--read-only] -[]") || !strings.Contains(got, "env: RCLONE_CONFIG_S3_ENDPOINT") {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+	if strings.Contains(got, "s3b") || strings.Contains(got, "PATH") {
+		t.Fatalf("diff must not leak env values or image env:\n%s", got)
+	}
+}
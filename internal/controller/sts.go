@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swarmnative/volume-s3/internal/s3"
+)
+
+// STS temporary credentials (STSEndpoint set). The default remote gets
+// short-lived keys from AssumeRoleWithWebIdentity (STSTokenFile, e.g. a Swarm
+// secret) or AssumeRole (signed with the static key files), refreshed at two
+// thirds of their lifetime.
+//
+// rclone rc cannot swap the keys of a mounted remote, so instead of env keys
+// the mounter runs with env_auth and an AWS config whose credential_process
+// reads credentials.json from STSCredentialsDir (bind-mounted read-only). The
+// SDK re-runs the process when the advertised expiry passes, which picks up
+// the refreshed file without a remount; the mounter env, and therefore its
+// spec hash, stays the same across refreshes.
+
+const (
+	stsCredentialsFile = "credentials.json"
+	stsConfigFile      = "config"
+	stsMinRetry        = 15 * time.Second
+	stsMaxRetry        = 5 * time.Minute
+)
+
+// STSStatus is the /status view of the STS provider.
+type STSStatus struct {
+	Endpoint    string
+	Mode        string // web_identity | assume_role
+	AccessKey   string // masked
+	Expiration  time.Time
+	LastRefresh time.Time
+	Refreshes   int64
+	Failures    int64
+	Error       string `json:",omitempty"`
+}
+
+type stsState struct {
+	mu        sync.Mutex
+	creds     s3.TemporaryCredentials
+	refreshed time.Time
+	refreshes int64
+	failures  int64
+	lastErr   string
+}
+
+func (c *Controller) stsEnabled() bool {
+	return strings.TrimSpace(c.cfg.STSEndpoint) != "" && c.s3Backend()
+}
+
+func (c *Controller) stsMode() string {
+	if strings.TrimSpace(c.cfg.STSTokenFile) != "" {
+		return "web_identity"
+	}
+	return "assume_role"
+}
+
+// stsAdvertisedExpiry is the expiry handed to the SDK: a sixth of the
+// lifetime early, so it asks again after the controller has refreshed the
+// file (at two thirds) but before the keys really expire.
+func stsAdvertisedExpiry(issued, exp time.Time) time.Time {
+	return exp.Add(-exp.Sub(issued) / 6)
+}
+
+// stsNextRefresh is when keys issued at issued and expiring at exp are renewed.
+func stsNextRefresh(issued, exp time.Time) time.Time {
+	return issued.Add(exp.Sub(issued) * 2 / 3)
+}
+
+// refreshSTS fetches new temporary credentials and publishes them to the
+// mounter's credential_process file.
+func (c *Controller) refreshSTS() (s3.TemporaryCredentials, error) {
+	o := s3.STSOptions{
+		Endpoint:    c.cfg.STSEndpoint,
+		Region:      c.cfg.S3Region,
+		RoleARN:     c.cfg.STSRoleARN,
+		SessionName: "volume-s3-" + sanitizeHostname(),
+		Duration:    c.cfg.STSDuration,
+	}
+	ctx, cancel := c.timeoutCtx(30 * time.Second)
+	defer cancel()
+	issued := time.Now().UTC()
+	var tc s3.TemporaryCredentials
+	var err error
+	if c.stsMode() == "web_identity" {
+		var b []byte
+		if b, err = os.ReadFile(c.cfg.STSTokenFile); err == nil {
+			tc, err = s3.AssumeRoleWithWebIdentity(ctx, o, strings.TrimSpace(string(b)))
+		}
+	} else {
+		tc, err = s3.AssumeRole(ctx, o, c.readDefaultCredentials())
+	}
+	if err == nil && tc.Expiration.IsZero() {
+		err = fmt.Errorf("sts: response without expiration")
+	}
+	if err == nil {
+		err = c.writeSTSFiles(tc, issued)
+	}
+
+	c.sts.mu.Lock()
+	defer c.sts.mu.Unlock()
+	if err != nil {
+		c.sts.failures++
+		c.sts.lastErr = err.Error()
+		return tc, err
+	}
+	c.sts.creds, c.sts.refreshed, c.sts.lastErr = tc, issued, ""
+	c.sts.refreshes++
+	return tc, nil
+}
+
+// writeSTSFiles writes the credential_process payload and the AWS config
+// pointing at it. Files are replaced atomically so the SDK never reads a
+// partial file.
+func (c *Controller) writeSTSFiles(tc s3.TemporaryCredentials, issued time.Time) error {
+	dir := c.cfg.STSCredentialsDir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]any{
+		"Version":         1,
+		"AccessKeyId":     tc.AccessKey,
+		"SecretAccessKey": tc.SecretKey,
+		"SessionToken":    tc.SessionToken,
+		"Expiration":      stsAdvertisedExpiry(issued, tc.Expiration).Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	config := fmt.Sprintf("[default]\ncredential_process = cat %s\n", filepath.Join(dir, stsCredentialsFile))
+	for name, data := range map[string][]byte{stsCredentialsFile: payload, stsConfigFile: []byte(config)} {
+		tmp := filepath.Join(dir, "."+name+".tmp")
+		if err := os.WriteFile(tmp, data, 0o600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stsCredentials returns the current temporary keys, fetching them on first use.
+func (c *Controller) stsCredentials() s3.Credentials {
+	c.sts.mu.Lock()
+	tc := c.sts.creds
+	c.sts.mu.Unlock()
+	if tc.AccessKey == "" {
+		if fresh, err := c.refreshSTS(); err == nil {
+			tc = fresh
+		}
+	}
+	return tc.Credentials
+}
+
+// stsEnv configures the default remote to take its keys from the
+// credential_process file.
+func (c *Controller) stsEnv() []string {
+	return []string{
+		remoteEnvPrefix(c.defaultRemoteName()) + "ENV_AUTH=true",
+		"AWS_SDK_LOAD_CONFIG=1",
+		"AWS_CONFIG_FILE=" + filepath.Join(c.cfg.STSCredentialsDir, stsConfigFile),
+		"AWS_PROFILE=default",
+	}
+}
+
+// runSTSRefresh renews the credentials before they expire; failures are
+// retried with backoff while the current keys are still valid.
+func (c *Controller) runSTSRefresh() {
+	retry := stsMinRetry
+	for {
+		c.sts.mu.Lock()
+		tc, issued := c.sts.creds, c.sts.refreshed
+		failed := c.sts.lastErr != ""
+		c.sts.mu.Unlock()
+
+		wait := time.Duration(0)
+		switch {
+		case failed:
+			wait = retry
+			if retry *= 2; retry > stsMaxRetry {
+				retry = stsMaxRetry
+			}
+		case tc.AccessKey != "":
+			wait = time.Until(stsNextRefresh(issued, tc.Expiration))
+			retry = stsMinRetry
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if tc, err := c.refreshSTS(); err != nil {
+			slog.Error("sts refresh", "endpoint", c.cfg.STSEndpoint, "mode", c.stsMode(), "error", err)
+		} else {
+			slog.Info("sts credentials refreshed", "access_key", maskKey(tc.AccessKey), "expires", tc.Expiration)
+		}
+	}
+}
+
+func (c *Controller) stsStatus() *STSStatus {
+	if !c.stsEnabled() {
+		return nil
+	}
+	c.sts.mu.Lock()
+	defer c.sts.mu.Unlock()
+	st := &STSStatus{
+		Endpoint:    c.cfg.STSEndpoint,
+		Mode:        c.stsMode(),
+		Expiration:  c.sts.creds.Expiration,
+		LastRefresh: c.sts.refreshed,
+		Refreshes:   c.sts.refreshes,
+		Failures:    c.sts.failures,
+		Error:       c.sts.lastErr,
+	}
+	if c.sts.creds.AccessKey != "" {
+		st.AccessKey = maskKey(c.sts.creds.AccessKey)
+	}
+	return st
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSTSRefreshSchedule(t *testing.T) {
+	issued := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	exp := issued.Add(time.Hour)
+	if got := stsNextRefresh(issued, exp); !got.Equal(issued.Add(40 * time.Minute)) {
+		t.Fatalf("next refresh: %v", got)
+	}
+	// the SDK reloads after the controller refreshed and before the keys expire
+	adv := stsAdvertisedExpiry(issued, exp)
+	if !adv.After(stsNextRefresh(issued, exp)) || !adv.Before(exp) {
+		t.Fatalf("advertised expiry: %v", adv)
+	}
+}
+
+func TestRefreshSTSWebIdentity(t *testing.T) {
+	// local STS stand-in handing out a new key per call
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("Action") != "AssumeRoleWithWebIdentity" || r.PostForm.Get("WebIdentityToken") != "jwt" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code></Error></ErrorResponse>`)
+			return
+		}
+		n++
+		exp := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials><AccessKeyId>TMPKEY%04d</AccessKeyId><SecretAccessKey>s%d</SecretAccessKey><SessionToken>t%d</SessionToken><Expiration>%s</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`, n, n, n, exp)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	if err := os.WriteFile(token, []byte("jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	credDir := filepath.Join(dir, "sts")
+	c := &Controller{cfg: Config{S3Endpoint: "http://minio:9000", STSEndpoint: srv.URL, STSTokenFile: token, STSDuration: time.Hour, STSCredentialsDir: credDir}}
+	c.ctx = context.Background()
+
+	if cr := c.defaultCredentials(); cr.AccessKey != "TMPKEY0001" || cr.SessionToken != "t1" {
+		t.Fatalf("first keys: %+v", cr)
+	}
+	env := strings.Join(c.buildRcloneEnv(), "\n")
+	if strings.Contains(env, "ACCESS_KEY_ID") || !strings.Contains(env, "RCLONE_CONFIG_S3_ENV_AUTH=true") {
+		t.Fatalf("mounter env: %s", env)
+	}
+	if _, err := c.refreshSTS(); err != nil {
+		t.Fatal(err)
+	}
+	// the refresh reaches the mounter through the file only; its env is unchanged
+	if again := strings.Join(c.buildRcloneEnv(), "\n"); again != env {
+		t.Fatalf("env changed on refresh:\n%s\n%s", env, again)
+	}
+	b, err := os.ReadFile(filepath.Join(credDir, stsCredentialsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Version     int
+		AccessKeyId string
+		Expiration  time.Time
+	}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Version != 1 || payload.AccessKeyId != "TMPKEY0002" || !payload.Expiration.After(time.Now()) {
+		t.Fatalf("credential_process payload: %s", b)
+	}
+	if cfg, _ := os.ReadFile(filepath.Join(credDir, stsConfigFile)); !strings.Contains(string(cfg), "credential_process = cat "+filepath.Join(credDir, stsCredentialsFile)) {
+		t.Fatalf("aws config: %s", cfg)
+	}
+
+	if err := os.WriteFile(token, []byte("expired"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.refreshSTS(); err == nil {
+		t.Fatal("rejected token accepted")
+	}
+	st := c.stsStatus()
+	if st.Refreshes != 2 || st.Failures != 1 || st.Error == "" || st.AccessKey != maskKey("TMPKEY0002") {
+		t.Fatalf("status: %+v", st)
+	}
+	// failed refreshes keep the last good keys
+	if cr := c.defaultCredentials(); cr.AccessKey != "TMPKEY0002" {
+		t.Fatalf("keys after failed refresh: %+v", cr)
+	}
+}
@@ -0,0 +1,378 @@
+package controller
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Staged mounter upgrades (MounterUpgradeStrategy=staged). Instead of removing
+// the running mounter and then starting a new one, which leaves applications
+// with "transport endpoint is not connected" in between, the new mounter is
+// started on a fresh directory under MounterStagingRoot and checked with
+// the mount probe. Only then the live mountpoint is switched in the host mount
+// namespace (lazy unmount of the old mount, bind of the staged one, in a single
+// helper run). The lazy unmount only detaches the old mount from the live
+// path: applications that bound it before the switch still talk to the old
+// FUSE connection, so the old mounter is renamed and kept as long as anything
+// uses its device, then drained and removed (reapRetiredMounters). Those
+// applications see the new mount after their next restart.
+// When a staged mounter restarts later, its staging mount is a new FUSE
+// connection while the live path still shows the old one; ensureMounter
+// notices the device mismatch and binds the live path again.
+
+// mounterSourceLabel records where a staged mounter actually mounts; the live
+// path stays in swarmnative.mounter.path.
+const mounterSourceLabel = "swarmnative.mounter.source"
+
+// stagedVerifyTimeout bounds the wait for a staged mounter to become usable.
+const stagedVerifyTimeout = 30 * time.Second
+
+func (c *Controller) stagedUpgrades() bool {
+	return strings.TrimSpace(c.cfg.MounterUpgradeStrategy) == "staged"
+}
+
+// stagingPath is a fresh staging directory for the mounter name.
+func (c *Controller) stagingPath(name string) string {
+	return filepath.Join(c.cfg.MounterStagingRoot, name+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+}
+
+// retiredName is the name the old mounter is renamed to while the new one
+// takes over its name. It carries the container ID: an old mounter that is
+// still in use may outlive the next upgrade.
+func retiredName(name, id string) string {
+	return name + "-old-" + shortID(id)
+}
+
+// isRetired tells a retired mounter from a live one by its container name.
+func isRetired(name, id string) bool {
+	return strings.HasSuffix(strings.TrimPrefix(name, "/"), "-old-"+shortID(id))
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// retiredMounter is an old mounter kept after a staged switch.
+type retiredMounter struct {
+	name   string
+	claim  string
+	reason string
+	// live path it served and its own staging mount ("" if it mounted the
+	// live path itself)
+	path   string
+	source string
+	// device of its FUSE connection, "" when unknown
+	dev string
+	// containers created before the switch may bind the old connection
+	since time.Time
+}
+
+type retiredState struct {
+	mu sync.Mutex
+	m  map[string]retiredMounter // by container ID
+}
+
+func (s *retiredState) get(id string) (retiredMounter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rm, ok := s.m[id]
+	return rm, ok
+}
+
+func (s *retiredState) set(id string, rm retiredMounter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = map[string]retiredMounter{}
+	}
+	s.m[id] = rm
+}
+
+// retain drops the entries whose container is gone.
+func (s *retiredState) retain(ids map[string]struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.m {
+		if _, ok := ids[id]; !ok {
+			delete(s.m, id)
+		}
+	}
+}
+
+// retiredInUse counts what still uses the FUSE connection of rm: mounts on
+// its device other than its own staging mount, and running containers that
+// bind the live path and were created before the switch.
+func (c *Controller) retiredInUse(rm retiredMounter) (int, error) {
+	n := 0
+	if rm.dev != "" {
+		info, err := readMountInfo()
+		if err != nil {
+			return 0, err
+		}
+		n += countDevMounts(string(info), rm.dev, rm.source)
+	}
+	ctx, cancel := c.timeoutCtx(10 * time.Second)
+	defer cancel()
+	conts, err := c.cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return n, err
+	}
+	for _, ct := range conts {
+		if ct.Labels["swarmnative.mounter"] == "managed" || ct.Created >= rm.since.Unix() {
+			continue
+		}
+		for _, m := range ct.Mounts {
+			if underPath(m.Source, rm.path) {
+				n++
+				break
+			}
+		}
+	}
+	return n, nil
+}
+
+// reapRetired drains and removes the retired mounter id once nothing uses it
+// any more and reports whether it is gone.
+func (c *Controller) reapRetired(id string, rm retiredMounter) bool {
+	n, err := c.retiredInUse(rm)
+	if err != nil {
+		slog.Warn("retired mounter: usage check", "mounter", rm.name, "error", err)
+		return false
+	}
+	if n > 0 {
+		slog.Debug("retired mounter still in use, kept", "mounter", rm.name, "users", n)
+		return false
+	}
+	c.drainMounter(c.ctx, id, rm.name, rm.claim, rm.reason)
+	c.removeContainer(id)
+	if rm.source != "" {
+		c.cleanupStaging(rm.source)
+	}
+	slog.Info("retired mounter removed", "mounter", rm.name, "path", rm.path)
+	return true
+}
+
+// reapRetiredMounters checks the retired mounters of this node, including
+// the ones left by an earlier controller run. Without a record of the switch
+// those are kept until every container created before now is gone.
+func (c *Controller) reapRetiredMounters() {
+	args := filters.NewArgs()
+	args.Add("label", "swarmnative.mounter=managed")
+	args.Add("label", "swarmnative.mounter.node="+sanitizeHostname())
+	conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		slog.Warn("list retired mounters", "error", err)
+		return
+	}
+	seen := map[string]struct{}{}
+	for _, ct := range conts {
+		name := strings.TrimPrefix(firstName(ct.Names), "/")
+		if !isRetired(name, ct.ID) {
+			continue
+		}
+		seen[ct.ID] = struct{}{}
+		rm, ok := c.retired.get(ct.ID)
+		if !ok {
+			rm = retiredMounter{name: name, claim: ct.Labels[claimMounterLabel], reason: "upgrade",
+				path: ct.Labels["swarmnative.mounter.path"], since: time.Now()}
+			if src := ct.Labels[mounterSourceLabel]; src != "" && src != rm.path {
+				rm.source = src
+				if me, ok := lookupMount(src); ok {
+					rm.dev = me.dev
+				}
+			}
+			c.retired.set(ct.ID, rm)
+		}
+		if ct.State != "running" {
+			c.removeContainer(ct.ID)
+			if rm.source != "" {
+				c.cleanupStaging(rm.source)
+			}
+			delete(seen, ct.ID)
+			continue
+		}
+		if c.reapRetired(ct.ID, rm) {
+			delete(seen, ct.ID)
+		}
+	}
+	c.retired.retain(seen)
+}
+
+// verifyStaged waits until the staged mount is usable: writable, or listable
+// and mounted for read-only mounters.
+func (c *Controller) verifyStaged(path string, readOnly bool) error {
+	deadline := time.Now().Add(stagedVerifyTimeout)
+	for {
+		var err error
+		if readOnly && !isMounted(path) {
+			err = fmt.Errorf("%s not mounted", path)
+		} else {
+			err = c.probeMount(path, !readOnly).Err()
+		}
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("staged mount not ready: %w", err)
+		}
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// switchMountCmd replaces the mount at live with a bind of staged in the host
+// mount namespace; the paths are arguments of a fixed script.
+func switchMountCmd(staged, live string) []string {
+	return []string{"nsenter", "-t", "1", "-m", "--", "sh", "-c",
+		`umount -l "$2" 2>/dev/null; mount --bind "$1" "$2" && mount --make-rshared "$2"`, "sh", staged, live}
+}
+
+// restoreStagedBind binds the live path of a running staged mounter to its
+// staging mount again when they no longer share a device: the mounter was
+// restarted, or healing unmounted the dead live bind.
+func (c *Controller) restoreStagedBind(ms mounterSpec, inspect types.ContainerJSON) error {
+	if inspect.Config == nil {
+		return nil
+	}
+	src := inspect.Config.Labels[mounterSourceLabel]
+	if src == "" || src == ms.mountpoint {
+		return nil
+	}
+	info, err := readMountInfo()
+	if err != nil {
+		return nil
+	}
+	staged, ok := parseMountInfo(string(info), src)
+	if !ok {
+		// rclone has not mounted (yet); binding the bare directory would hide the data
+		return nil
+	}
+	if live, ok := parseMountInfo(string(info), ms.mountpoint); ok && live.dev == staged.dev {
+		return nil
+	}
+	slog.Warn("staged mount: live path lost its source, binding again", "mounter", ms.name, "path", ms.mountpoint, "source", src)
+	if err := c.runHostHelper("mount-switch", switchMountCmd(src, ms.mountpoint)...); err != nil {
+		return fmt.Errorf("rebind %s: %w", ms.mountpoint, err)
+	}
+	return nil
+}
+
+func (c *Controller) removeContainer(id string) {
+	ctx, cancel := c.timeoutCtx(10 * time.Second)
+	defer cancel()
+	// Force kills rclone without letting it unmount: the live path is shared
+	// with the new mount by now
+	_ = c.cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true})
+}
+
+// stagedUpgrade replaces the running mounter old (named ms.name) with a new
+// one built from ms without unmounting the live path first. Callers hold
+// mounterMu. On error the new mounter is discarded and the old one keeps its name.
+func (c *Controller) stagedUpgrade(ms mounterSpec, old types.ContainerJSON, reason string) error {
+	if strings.TrimSpace(c.cfg.MounterStagingRoot) == "" {
+		return fmt.Errorf("no staging root configured")
+	}
+	oldSource := ms.mountpoint
+	if old.Config != nil && old.Config.Labels[mounterSourceLabel] != "" {
+		oldSource = old.Config.Labels[mounterSourceLabel]
+	}
+	retired := retiredName(ms.name, old.ID)
+	rctx, rcancel := c.timeoutCtx(10 * time.Second)
+	err := c.cli.ContainerRename(rctx, old.ID, retired)
+	rcancel()
+	if err != nil {
+		return fmt.Errorf("rename old mounter: %w", err)
+	}
+	restore := func() {
+		ctx, cancel := c.timeoutCtx(10 * time.Second)
+		defer cancel()
+		if err := c.cli.ContainerRename(ctx, old.ID, ms.name); err != nil {
+			slog.Error("restore old mounter name", "mounter", ms.name, "error", err)
+		}
+	}
+
+	staged := ms
+	staged.mountpoint = c.stagingPath(ms.name)
+	staged.labels = map[string]string{
+		"swarmnative.mounter.path": ms.mountpoint,
+		mounterSourceLabel:         staged.mountpoint,
+	}
+	for k, v := range ms.labels {
+		staged.labels[k] = v
+	}
+	if err := os.MkdirAll(staged.mountpoint, 0o755); err != nil {
+		restore()
+		return err
+	}
+	slog.Info("staged upgrade", "mounter", ms.name, "reason", reason, "staging", staged.mountpoint)
+	discard := func() {
+		args := filters.NewArgs()
+		args.Add("name", "^/"+ms.name+"$")
+		if conts, err := c.cli.ContainerList(c.ctx, container.ListOptions{All: true, Filters: args}); err == nil {
+			for _, ct := range conts {
+				c.removeContainer(ct.ID)
+			}
+		}
+		c.cleanupStaging(staged.mountpoint)
+		restore()
+	}
+	if err := c.createMounter(staged); err != nil {
+		discard()
+		return err
+	}
+	if err := c.verifyStaged(staged.mountpoint, staged.readOnly); err != nil {
+		discard()
+		return err
+	}
+
+	// ro binds of the shared mount point into the old FUSE connection
+	if ms.name == c.mounterName() {
+		c.releaseReadOnlyBinds(nil)
+	}
+	// the device of the connection the applications bound so far
+	oldDev := ""
+	if me, ok := lookupMount(ms.mountpoint); ok {
+		oldDev = me.dev
+	}
+	if err := c.runHostHelper("mount-switch", switchMountCmd(staged.mountpoint, ms.mountpoint)...); err != nil {
+		// the old mount may already be detached; give the name back so the
+		// caller's plain recreate can take over
+		discard()
+		return fmt.Errorf("switch %s: %w", ms.mountpoint, err)
+	}
+	slog.Info("mount switched", "path", ms.mountpoint, "from", oldSource, "to", staged.mountpoint)
+
+	rm := retiredMounter{name: retired, claim: ms.labels[claimMounterLabel], reason: reason,
+		path: ms.mountpoint, dev: oldDev, since: time.Now()}
+	if oldSource != ms.mountpoint {
+		rm.source = oldSource
+	}
+	if !c.reapRetired(old.ID, rm) {
+		slog.Info("old mounter still in use, kept until released", "mounter", retired, "path", ms.mountpoint)
+		c.retired.set(old.ID, rm)
+	}
+	return nil
+}
+
+// cleanupStaging drops a (possibly dead) staged mount and its directory.
+func (c *Controller) cleanupStaging(path string) {
+	if err := c.runHostHelper("stage-clean", "nsenter", "-t", "1", "-m", "--", "sh", "-c", `umount -l "$1" 2>/dev/null; rmdir "$1" 2>/dev/null; true`, "sh", path); err != nil {
+		slog.Warn("staging cleanup", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestStagingPath(t *testing.T) {
+	c := &Controller{cfg: Config{MounterUpgradeStrategy: "staged", MounterStagingRoot: "/mnt/s3-staging"}}
+	if !c.stagedUpgrades() {
+		t.Fatal("staged strategy not detected")
+	}
+	a, b := c.stagingPath("rclone-mounter-n1"), c.stagingPath("rclone-mounter-n1")
+	if filepath.Dir(a) != "/mnt/s3-staging" || !strings.HasPrefix(filepath.Base(a), "rclone-mounter-n1-") {
+		t.Fatalf("unexpected staging path %q", a)
+	}
+	if a == b {
+		t.Fatal("staging paths must be fresh per upgrade")
+	}
+	id := "0123456789abcdef"
+	if r := retiredName("rclone-mounter-n1", id); r == "rclone-mounter-n1" || !isRetired("/"+r, id) || isRetired("rclone-mounter-n1", id) {
+		t.Fatalf("retired mounter needs its own name: %q", r)
+	}
+}
+
+func TestSwitchMountCmd(t *testing.T) {
+	cmd := switchMountCmd("/mnt/s3-staging/m-1", "/mnt/s3")
+	want := []string{"nsenter", "-t", "1", "-m", "--", "sh", "-c"}
+	if !reflect.DeepEqual(cmd[:7], want) || !reflect.DeepEqual(cmd[8:], []string{"sh", "/mnt/s3-staging/m-1", "/mnt/s3"}) {
+		t.Fatalf("unexpected switch command %q", cmd)
+	}
+	if strings.Contains(cmd[7], "/mnt") {
+		t.Fatalf("paths must stay out of the script: %q", cmd[7])
+	}
+}
+
+// fakeUpgradeDaemon answers the container calls of a staged upgrade and
+// records the created containers (name -> command).
+func fakeUpgradeDaemon(created map[string][]string, calls *[]string) http.HandlerFunc {
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		p := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:] // strip the API version
+		*calls = append(*calls, r.Method+" "+p)
+		switch {
+		case strings.HasPrefix(p, "/images/"):
+			_, _ = w.Write([]byte(`{"Id":"sha256:img"}`))
+		case p == "/containers/json":
+			_, _ = w.Write([]byte(`[]`))
+		case p == "/containers/create":
+			var body struct{ Cmd []string }
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			name := r.URL.Query().Get("name")
+			created[name] = body.Cmd
+			_ = json.NewEncoder(w).Encode(map[string]string{"Id": name})
+		case strings.HasSuffix(p, "/wait"):
+			_, _ = w.Write([]byte(`{"StatusCode":0}`))
+		case strings.HasSuffix(p, "/logs"):
+			w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		case strings.HasSuffix(p, "/json"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func TestStagedUpgrade_SwitchAndRestore(t *testing.T) {
+	created := map[string][]string{}
+	var calls []string
+	cli := fakeDocker(t, fakeUpgradeDaemon(created, &calls))
+	live, root := t.TempDir(), t.TempDir()
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{
+		Mountpoint: live, MounterStagingRoot: root, MounterUpgradeStrategy: "staged",
+		MounterImage: "rclone/rclone", HelperImage: "helper", RcloneRemote: "S3:b",
+	}}
+	ms := c.sharedMounterSpec()
+	old := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: "old"}, Config: &container.Config{}}
+
+	// switch: the new mounter mounts a staging dir, the helper binds it live
+	if err := c.stagedUpgrade(ms, old, "image changed"); err != nil {
+		t.Fatalf("staged upgrade: %v", err)
+	}
+	stagedCmd := created[ms.name]
+	if len(stagedCmd) < 3 || filepath.Dir(stagedCmd[2]) != root {
+		t.Fatalf("mounter not staged: %q", stagedCmd)
+	}
+	staging := stagedCmd[2]
+	if got := created[c.helperName("mount-switch")]; !reflect.DeepEqual(got, switchMountCmd(staging, live)) {
+		t.Fatalf("switch helper: %q", got)
+	}
+	joined := strings.Join(calls, "|")
+	if !strings.Contains(joined, "POST /containers/old/rename") || !strings.Contains(joined, "DELETE /containers/old") {
+		t.Fatalf("old mounter not retired: %s", joined)
+	}
+
+	// restore: after a mounter restart the live bind shows the old connection
+	running := types.ContainerJSON{Config: &container.Config{Labels: map[string]string{mounterSourceLabel: staging}}}
+	defer func(f func() ([]byte, error)) { readMountInfo = f }(readMountInfo)
+	table := func(liveDev string) {
+		mi := "50 1 0:52 / " + staging + " rw - fuse.rclone S3:b rw\n"
+		if liveDev != "" {
+			mi += "51 1 " + liveDev + " / " + live + " rw - fuse.rclone S3:b rw\n"
+		}
+		readMountInfo = func() ([]byte, error) { return []byte(mi), nil }
+	}
+	for _, tc := range []struct {
+		liveDev string
+		rebind  bool
+	}{{"0:52", false}, {"0:51", true}, {"", true}} {
+		delete(created, c.helperName("mount-switch"))
+		table(tc.liveDev)
+		if err := c.restoreStagedBind(ms, running); err != nil {
+			t.Fatalf("restore (live %q): %v", tc.liveDev, err)
+		}
+		if _, ok := created[c.helperName("mount-switch")]; ok != tc.rebind {
+			t.Fatalf("live %q: rebind=%v, want %v", tc.liveDev, ok, tc.rebind)
+		}
+	}
+	// no staging mount: nothing to bind
+	delete(created, c.helperName("mount-switch"))
+	readMountInfo = func() ([]byte, error) { return nil, nil }
+	if err := c.restoreStagedBind(ms, running); err != nil || created[c.helperName("mount-switch")] != nil {
+		t.Fatalf("bound a bare staging dir: %v", err)
+	}
+}
+
+func TestStagedUpgrade_KeepsBoundMounter(t *testing.T) {
+	created := map[string][]string{}
+	var calls []string
+	var mu sync.Mutex
+	var listed []types.Container
+	daemon := fakeUpgradeDaemon(created, &calls)
+	cli := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/containers/json") {
+			mu.Lock()
+			defer mu.Unlock()
+			_ = json.NewEncoder(w).Encode(listed)
+			return
+		}
+		daemon(w, r)
+	})
+	live, root := t.TempDir(), t.TempDir()
+	c := &Controller{ctx: context.Background(), cli: cli, cfg: Config{
+		Mountpoint: live, MounterStagingRoot: root, MounterUpgradeStrategy: "staged",
+		MounterImage: "rclone/rclone", HelperImage: "helper", RcloneRemote: "S3:b",
+	}}
+	ms := c.sharedMounterSpec()
+	old := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: "old"}, Config: &container.Config{}}
+
+	// an application bound a directory of the old connection before the switch
+	defer func(f func() ([]byte, error)) { readMountInfo = f }(readMountInfo)
+	mi := "50 1 0:40 / " + live + " rw - fuse.rclone S3:b rw\n" +
+		"60 1 0:40 /data /var/lib/app rw - fuse.rclone S3:b rw\n"
+	readMountInfo = func() ([]byte, error) { return []byte(mi), nil }
+	deleted := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Contains(strings.Join(calls, "|"), "DELETE /containers/old")
+	}
+
+	if err := c.stagedUpgrade(ms, old, "image changed"); err != nil {
+		t.Fatalf("staged upgrade: %v", err)
+	}
+	if deleted() {
+		t.Fatal("old mounter removed while its connection is bound")
+	}
+	rm, ok := c.retired.get("old")
+	if !ok || rm.dev != "0:40" || rm.name != retiredName(ms.name, "old") {
+		t.Fatalf("old mounter not retired: %+v", rm)
+	}
+
+	mounter := types.Container{ID: "old", Names: []string{"/" + rm.name}, State: "running",
+		Labels: map[string]string{"swarmnative.mounter": "managed", "swarmnative.mounter.path": live}}
+	app := types.Container{ID: "app", Created: rm.since.Unix() - 60, State: "running",
+		Mounts: []types.MountPoint{{Type: "bind", Source: live + "/data", Destination: "/data"}}}
+	mu.Lock()
+	listed = []types.Container{mounter, app}
+	mu.Unlock()
+	c.reapRetiredMounters()
+	if deleted() {
+		t.Fatal("old mounter removed while a bind uses its device")
+	}
+
+	// bind gone, but a container started before the switch still uses it
+	readMountInfo = func() ([]byte, error) { return []byte("70 1 0:41 / " + live + " rw - fuse.rclone S3:b rw\n"), nil }
+	c.reapRetiredMounters()
+	if deleted() {
+		t.Fatal("old mounter removed while a container from before the switch runs")
+	}
+
+	// a container started after the switch binds the new connection
+	app.Created = rm.since.Unix() + 1
+	mu.Lock()
+	listed = []types.Container{mounter, app}
+	mu.Unlock()
+	c.reapRetiredMounters()
+	if !deleted() {
+		t.Fatalf("released mounter not removed: %s", strings.Join(calls, "|"))
+	}
+	if _, ok := c.retired.get("old"); ok {
+		t.Fatal("removed mounter still recorded")
+	}
+}
+
+func TestValidateConfig_UpgradeStrategy(t *testing.T) {
+	base := Config{S3Endpoint: "http://s3", Mountpoint: "/mnt/s3", MounterImage: "rclone/rclone"}
+	cfg := base
+	cfg.MounterUpgradeStrategy = "staged"
+	cfg.MounterStagingRoot = t.TempDir()
+	if vr := ValidateConfig(cfg); !vr.OK {
+		t.Fatalf("staged with root should validate: %#v", vr.Errors)
+	}
+	cfg.MounterStagingRoot = ""
+	if vr := ValidateConfig(cfg); vr.OK {
+		t.Fatal("staged without root must fail")
+	}
+	cfg = base
+	cfg.MounterUpgradeStrategy = "bluegreen"
+	if vr := ValidateConfig(cfg); vr.OK {
+		t.Fatal("unknown strategy must fail")
+	}
+}
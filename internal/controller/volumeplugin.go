@@ -0,0 +1,458 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Docker volume plugin protocol (https://docs.docker.com/engine/extend/plugins_volume/).
+// Each named volume maps to a bucket/prefix claim under the shared rclone mount.
+
+const volumePluginContentType = "application/vnd.docker.plugins.v1.2+json"
+
+type pluginVolume struct {
+	Name      string            `json:"name"`
+	Opts      map[string]string `json:"opts,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	// mount IDs currently holding the volume (not persisted)
+	mounts map[string]struct{}
+	// mounts being set up and a removal in progress; a volume is only
+	// reclaimed when neither mounts nor pending mounts hold it
+	pending  int
+	removing bool
+}
+
+// VolumePlugin serves the Docker volume plugin API on top of a Controller.
+type VolumePlugin struct {
+	c         *Controller
+	stateFile string
+	mu        sync.Mutex
+	volumes   map[string]*pluginVolume
+}
+
+// NewVolumePlugin creates the plugin and loads persisted volumes from stateFile
+// (empty keeps state in memory only).
+func NewVolumePlugin(c *Controller, stateFile string) *VolumePlugin {
+	p := &VolumePlugin{c: c, stateFile: strings.TrimSpace(stateFile), volumes: map[string]*pluginVolume{}}
+	p.load()
+	c.registerClaimHolder("plugin", p.liveClaims)
+	// volumes are node-local: each one keeps its per-claim mounter
+	c.registerNodeClaims("plugin", p.liveClaims)
+	return p
+}
+
+// liveClaims lists the claims of the plugin volumes, except those being removed.
+// A claim that no longer resolves is listed as declared.
+func (p *VolumePlugin) liveClaims() []claimSpec {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []claimSpec
+	for _, v := range p.volumes {
+		if v.removing {
+			continue
+		}
+		cs, err := claimFromVolumeOptions(v.Name, v.Opts)
+		if err != nil {
+			continue
+		}
+		if rs, err := p.c.resolveClaim(cs); err == nil {
+			cs = rs
+		}
+		cs.source = "volume:" + v.Name
+		out = append(out, cs)
+	}
+	return out
+}
+
+// claim returns the resolved claim of v: class defaults such as the bucket
+// decide where its data lives.
+func (p *VolumePlugin) claim(v *pluginVolume) (claimSpec, error) {
+	cs, err := claimFromVolumeOptions(v.Name, v.Opts)
+	if err != nil {
+		return cs, err
+	}
+	return p.c.resolveClaim(cs)
+}
+
+// claimFromVolumeOptions maps `docker volume create -o key=value` options onto a
+// claimSpec using the same keys as volume-s3.* labels. The prefix defaults to
+// the volume name.
+func claimFromVolumeOptions(name string, opts map[string]string) (claimSpec, error) {
+	m := map[string]string{"volume-s3.enabled": "true"}
+	for k, v := range opts {
+		switch k {
+		case "bucket", "prefix", "class", "reclaim", "access", "args", "credentials":
+			m["volume-s3."+k] = v
+		default:
+			return claimSpec{}, fmt.Errorf("unknown volume option %q", k)
+		}
+	}
+	cs := claimFromLabels(m)
+	if cs.prefix == "" {
+		cs.prefix = strings.Trim(name, "/")
+		if err := checkClaimPrefix(cs.prefix); err != nil {
+			return claimSpec{}, err
+		}
+	}
+	if cs.err != nil {
+		return claimSpec{}, cs.err
+	}
+	switch strings.ToLower(cs.access) {
+	case "", "rw", "ro":
+	default:
+		return claimSpec{}, fmt.Errorf("access must be rw or ro")
+	}
+	switch strings.ToLower(cs.reclaim) {
+	case "", "retain", "delete":
+	default:
+		return claimSpec{}, fmt.Errorf("reclaim must be Retain or Delete")
+	}
+	return cs, nil
+}
+
+func (p *VolumePlugin) load() {
+	if p.stateFile == "" {
+		return
+	}
+	b, err := os.ReadFile(p.stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("volume plugin load state", "file", p.stateFile, "error", err)
+		}
+		return
+	}
+	var vols []*pluginVolume
+	if err := json.Unmarshal(b, &vols); err != nil {
+		slog.Warn("volume plugin parse state", "file", p.stateFile, "error", err)
+		return
+	}
+	for _, v := range vols {
+		v.mounts = map[string]struct{}{}
+		p.volumes[v.Name] = v
+	}
+}
+
+// save persists volume definitions; callers must hold p.mu.
+func (p *VolumePlugin) save() {
+	if p.stateFile == "" {
+		return
+	}
+	vols := make([]*pluginVolume, 0, len(p.volumes))
+	for _, v := range p.volumes {
+		vols = append(vols, v)
+	}
+	sort.Slice(vols, func(i, j int) bool { return vols[i].Name < vols[j].Name })
+	b, err := json.MarshalIndent(vols, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(p.stateFile), 0o755); err != nil {
+		slog.Warn("volume plugin save state", "file", p.stateFile, "error", err)
+		return
+	}
+	tmp := p.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		slog.Warn("volume plugin save state", "file", p.stateFile, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, p.stateFile); err != nil {
+		slog.Warn("volume plugin save state", "file", p.stateFile, "error", err)
+	}
+}
+
+// Handler returns the HTTP handler to be served on the plugin unix socket.
+func (p *VolumePlugin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		writePluginJSON(w, map[string]any{"Implements": []string{"VolumeDriver"}})
+	})
+	mux.HandleFunc("/VolumeDriver.Capabilities", func(w http.ResponseWriter, r *http.Request) {
+		writePluginJSON(w, map[string]any{"Capabilities": map[string]string{"Scope": "local"}})
+	})
+	mux.HandleFunc("/VolumeDriver.Create", p.handle(func(req pluginRequest) map[string]any {
+		return errResp(p.create(req.Name, req.Opts))
+	}))
+	mux.HandleFunc("/VolumeDriver.Remove", p.handle(func(req pluginRequest) map[string]any {
+		return errResp(p.remove(req.Name))
+	}))
+	mux.HandleFunc("/VolumeDriver.Mount", p.handle(func(req pluginRequest) map[string]any {
+		mp, err := p.mount(req.Name, req.ID)
+		if err != nil {
+			return errResp(err)
+		}
+		return map[string]any{"Mountpoint": mp, "Err": ""}
+	}))
+	mux.HandleFunc("/VolumeDriver.Unmount", p.handle(func(req pluginRequest) map[string]any {
+		return errResp(p.unmount(req.Name, req.ID))
+	}))
+	mux.HandleFunc("/VolumeDriver.Path", p.handle(func(req pluginRequest) map[string]any {
+		mp, err := p.path(req.Name)
+		if err != nil {
+			return errResp(err)
+		}
+		return map[string]any{"Mountpoint": mp, "Err": ""}
+	}))
+	mux.HandleFunc("/VolumeDriver.Get", p.handle(func(req pluginRequest) map[string]any {
+		v, err := p.get(req.Name)
+		if err != nil {
+			return errResp(err)
+		}
+		return map[string]any{"Volume": v, "Err": ""}
+	}))
+	mux.HandleFunc("/VolumeDriver.List", p.handle(func(req pluginRequest) map[string]any {
+		return map[string]any{"Volumes": p.list(), "Err": ""}
+	}))
+	return mux
+}
+
+type pluginRequest struct {
+	Name string
+	ID   string
+	Opts map[string]string
+}
+
+type pluginVolumeInfo struct {
+	Name       string
+	Mountpoint string         `json:",omitempty"`
+	CreatedAt  string         `json:",omitempty"`
+	Status     map[string]any `json:",omitempty"`
+}
+
+func (p *VolumePlugin) handle(fn func(pluginRequest) map[string]any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pluginRequest
+		if r.Body != nil {
+			_ = json.NewDecoder(r.Body).Decode(&req)
+		}
+		writePluginJSON(w, fn(req))
+	}
+}
+
+func writePluginJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", volumePluginContentType)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func errResp(err error) map[string]any {
+	if err != nil {
+		return map[string]any{"Err": err.Error()}
+	}
+	return map[string]any{"Err": ""}
+}
+
+func (p *VolumePlugin) create(name string, opts map[string]string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("volume name is required")
+	}
+	cs, err := claimFromVolumeOptions(name, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := p.c.resolveClaim(cs); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.volumes[name]; ok {
+		return nil
+	}
+	p.volumes[name] = &pluginVolume{Name: name, Opts: opts, CreatedAt: time.Now().UTC(), mounts: map[string]struct{}{}}
+	p.save()
+	slog.Info("volume plugin create", "name", name)
+	return nil
+}
+
+// remove reclaims the volume data outside p.mu: a trash may take a while
+// and must not block the other plugin calls.
+func (p *VolumePlugin) remove(name string) error {
+	p.mu.Lock()
+	v, ok := p.volumes[name]
+	switch {
+	case !ok:
+		p.mu.Unlock()
+		return fmt.Errorf("volume %s not found", name)
+	case v.removing:
+		p.mu.Unlock()
+		return fmt.Errorf("volume %s is being removed", name)
+	case len(v.mounts) > 0 || v.pending > 0:
+		p.mu.Unlock()
+		return fmt.Errorf("volume %s is in use", name)
+	}
+	v.removing = true
+	p.mu.Unlock()
+
+	// an unresolvable Delete claim is not reclaimed: its bucket may be wrong
+	cs, err := p.claim(v)
+	if err == nil && isDeleteClaim(cs) && p.c.lazyUnmounted() {
+		// the prefix is only visible through the mount
+		err = p.c.lazyMountFor("reclaim volume " + name)
+	}
+	if err == nil {
+		err = p.c.reclaimClaim(cs)
+	} else if !isDeleteClaim(cs) {
+		err = nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v.removing = false
+	if err != nil {
+		return fmt.Errorf("delete volume data: %w", err)
+	}
+	delete(p.volumes, name)
+	p.save()
+	slog.Info("volume plugin remove", "name", name, "reclaim", cs.reclaim)
+	return nil
+}
+
+func (p *VolumePlugin) mount(name, id string) (string, error) {
+	p.mu.Lock()
+	v, ok := p.volumes[name]
+	if ok && v.removing {
+		p.mu.Unlock()
+		return "", fmt.Errorf("volume %s is being removed", name)
+	}
+	if ok {
+		v.pending++
+	}
+	p.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("volume %s not found", name)
+	}
+	mp, err := p.setupMount(v)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v.pending--
+	if err != nil {
+		return "", err
+	}
+	if p.volumes[name] != v {
+		return "", fmt.Errorf("volume %s was removed", name)
+	}
+	v.mounts[id] = struct{}{}
+	return mp, nil
+}
+
+// setupMount provisions the claim of v and returns its host path. It runs
+// without p.mu; the pending count keeps v from being removed meanwhile.
+func (p *VolumePlugin) setupMount(v *pluginVolume) (string, error) {
+	name := v.Name
+	cs, err := p.claim(v)
+	if err != nil {
+		return "", err
+	}
+	if p.c.claimDraining(cs) {
+		return "", fmt.Errorf("mounter for %s is draining, retry later", claimKey(cs))
+	}
+	if p.c.perClaimMounters() {
+		// the claim's own mounter serves it; the mountpoint is a plain directory
+		if err := p.c.ensureClaimMount(cs); err != nil {
+			return "", fmt.Errorf("claim mounter: %w", err)
+		}
+		mp := p.c.claimPath(cs)
+		if err := p.c.probeMount(mp, !p.c.cfg.ReadOnly && !isReadOnlyClaim(cs)).Err(); err != nil {
+			return "", fmt.Errorf("mount not ready: %w", err)
+		}
+		return mp, nil
+	}
+	if p.c.lazyMount() {
+		if err := p.c.lazyMountFor("volume " + name); err != nil {
+			return "", fmt.Errorf("mount not ready: %w", err)
+		}
+	}
+	if err := p.c.probeMount(p.c.cfg.Mountpoint, !p.c.cfg.ReadOnly).Err(); err != nil {
+		return "", fmt.Errorf("mount not ready: %w", err)
+	}
+	if err := p.c.ensureRemotePaths(cs); err != nil {
+		slog.Warn("volume plugin ensure remote", "name", name, "error", err)
+	}
+	if err := os.MkdirAll(p.c.claimPath(cs), 0o755); err != nil {
+		return "", err
+	}
+	mp := p.c.claimEffectivePath(cs)
+	if isReadOnlyClaim(cs) && p.c.cli != nil {
+		if err := p.c.ensureReadOnlyBind(cs); err != nil {
+			return "", fmt.Errorf("ro bind: %w", err)
+		}
+	}
+	return mp, nil
+}
+
+func (p *VolumePlugin) unmount(name, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.volumes[name]
+	if !ok {
+		return fmt.Errorf("volume %s not found", name)
+	}
+	delete(v.mounts, id)
+	return nil
+}
+
+func (p *VolumePlugin) path(name string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.volumes[name]
+	if !ok {
+		return "", fmt.Errorf("volume %s not found", name)
+	}
+	if len(v.mounts) == 0 {
+		return "", nil
+	}
+	cs, err := p.claim(v)
+	if err != nil {
+		return "", err
+	}
+	return p.c.claimEffectivePath(cs), nil
+}
+
+func (p *VolumePlugin) get(name string) (pluginVolumeInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.volumes[name]
+	if !ok {
+		return pluginVolumeInfo{}, fmt.Errorf("volume %s not found", name)
+	}
+	return p.info(v), nil
+}
+
+func (p *VolumePlugin) list() []pluginVolumeInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]pluginVolumeInfo, 0, len(p.volumes))
+	for _, v := range p.volumes {
+		out = append(out, p.info(v))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// info renders a volume for Get/List; callers must hold p.mu.
+func (p *VolumePlugin) info(v *pluginVolume) pluginVolumeInfo {
+	cs, err := p.claim(v)
+	vi := pluginVolumeInfo{
+		Name:      v.Name,
+		CreatedAt: v.CreatedAt.Format(time.RFC3339),
+		Status: map[string]any{
+			"bucket":  cs.bucket,
+			"prefix":  cs.prefix,
+			"access":  cs.access,
+			"reclaim": cs.reclaim,
+			"mounts":  len(v.mounts),
+		},
+	}
+	if err != nil {
+		vi.Status["error"] = err.Error()
+	} else if len(v.mounts) > 0 {
+		vi.Mountpoint = p.c.claimEffectivePath(cs)
+	}
+	return vi
+}
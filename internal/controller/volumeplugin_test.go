@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func pluginCall(t *testing.T, h http.Handler, path string, body any) map[string]any {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b)))
+	var out map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%s: decode: %v", path, err)
+	}
+	return out
+}
+
+func TestVolumePlugin_Lifecycle(t *testing.T) {
+	dir := t.TempDir()
+	c := &Controller{cfg: Config{Mountpoint: dir}}
+	state := filepath.Join(t.TempDir(), "volumes.json")
+	h := NewVolumePlugin(c, state).Handler()
+
+	if out := pluginCall(t, h, "/VolumeDriver.Create", map[string]any{"Name": "data", "Opts": map[string]string{"prefix": "teams/a", "reclaim": "Delete"}}); out["Err"] != "" {
+		t.Fatalf("create: %#v", out)
+	}
+	if out := pluginCall(t, h, "/VolumeDriver.Create", map[string]any{"Name": "bad", "Opts": map[string]string{"size": "1G"}}); out["Err"] == "" {
+		t.Fatalf("expected unknown option error")
+	}
+	out := pluginCall(t, h, "/VolumeDriver.Mount", map[string]any{"Name": "data", "ID": "c1"})
+	want := filepath.Join(dir, "teams/a")
+	if out["Mountpoint"] != want {
+		t.Fatalf("mount: %#v", out)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Fatalf("prefix dir not created: %v", err)
+	}
+	if out := pluginCall(t, h, "/VolumeDriver.Remove", map[string]any{"Name": "data"}); out["Err"] == "" {
+		t.Fatalf("expected in-use error")
+	}
+
+	pluginCall(t, h, "/VolumeDriver.Unmount", map[string]any{"Name": "data", "ID": "c1"})
+
+	// state survives a plugin restart; the restarted plugin takes over
+	h2 := NewVolumePlugin(c, state).Handler()
+	if out := pluginCall(t, h2, "/VolumeDriver.List", nil); len(out["Volumes"].([]any)) != 1 {
+		t.Fatalf("list after reload: %#v", out)
+	}
+	if out := pluginCall(t, h2, "/VolumeDriver.Remove", map[string]any{"Name": "data"}); out["Err"] != "" {
+		t.Fatalf("remove: %#v", out)
+	}
+	if _, err := os.Stat(want); !os.IsNotExist(err) {
+		t.Fatalf("reclaim=Delete should move data away, stat err=%v", err)
+	}
+	if trashed, _ := filepath.Glob(filepath.Join(dir, ".trash", "*", "teams", "a")); len(trashed) != 1 {
+		t.Fatalf("expected data in trash, got %v", trashed)
+	}
+}
+
+func TestVolumePlugin_MountRemoveRace(t *testing.T) {
+	dir := t.TempDir()
+	c := &Controller{cfg: Config{Mountpoint: dir}}
+	p := NewVolumePlugin(c, "")
+	if err := p.create("data", map[string]string{"prefix": "teams/a", "reclaim": "Delete"}); err != nil {
+		t.Fatal(err)
+	}
+	// a mount being set up keeps the volume from being reclaimed
+	p.volumes["data"].pending++
+	if err := p.remove("data"); err == nil {
+		t.Fatal("volume removed during a pending mount")
+	}
+	p.volumes["data"].pending--
+	// a volume being removed is not mounted
+	p.volumes["data"].removing = true
+	if _, err := p.mount("data", "c1"); err == nil {
+		t.Fatal("mounted a volume being removed")
+	}
+	p.volumes["data"].removing = false
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := p.mount("data", id); err == nil {
+				_ = p.unmount("data", id)
+			}
+		}(fmt.Sprintf("c%d", i))
+		go func() {
+			defer wg.Done()
+			_ = p.remove("data")
+			_ = p.create("data", map[string]string{"prefix": "teams/a", "reclaim": "Delete"})
+		}()
+	}
+	wg.Wait()
+	if v, ok := p.volumes["data"]; ok && (len(v.mounts) != 0 || v.pending != 0 || v.removing) {
+		t.Fatalf("volume left held: %+v", v)
+	}
+}
@@ -0,0 +1,434 @@
+// Package proxy is the in-process S3 reverse proxy (VOLS3_PROXY_ENGINE=native).
+// It mirrors the generated HAProxy setup: backends are discovered from the
+// Swarm DNS names tasks.<service>, actively health-checked on a path, balanced
+// with leastconn or roundrobin, and the remote service only serves as backup
+// when no local backend is healthy.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config configures the proxy. Zero durations and counts use HAProxy-like defaults.
+type Config struct {
+	LocalServices  []string // primary services, resolved as tasks.<service>
+	RemoteService  string   // optional backup service
+	BackendPort    string
+	HealthPath     string
+	Balance        string // leastconn (default) | roundrobin
+	HealthInterval time.Duration
+	Fall, Rise     int
+	// DiscoveryInterval is how often tasks.<service> is re-resolved
+	DiscoveryInterval time.Duration
+	ConnectTimeout    time.Duration
+	ServerTimeout     time.Duration
+	// ClientTimeout bounds client inactivity: request headers, each body
+	// read/write and idle keep-alive connections
+	ClientTimeout time.Duration
+	// Retries on connection errors for requests without a body
+	Retries int
+	// LookupHost resolves service names; defaults to net.DefaultResolver
+	LookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+// BackendStats is the state of one backend for /metrics.
+type BackendStats struct {
+	Service     string
+	Address     string
+	Backup      bool
+	Healthy     bool
+	ActiveConns int64
+	Requests    int64
+	Failures    int64
+	LastError   string `json:",omitempty"`
+	LastCheck   time.Time
+}
+
+type backend struct {
+	service string
+	addr    string // host:port
+	url     *url.URL
+	backup  bool
+
+	active   atomic.Int64
+	requests atomic.Int64
+	failures atomic.Int64
+
+	mu        sync.Mutex
+	healthy   bool
+	checked   bool
+	okRun     int
+	failRun   int
+	lastErr   string
+	lastCheck time.Time
+}
+
+// Proxy is an http.Handler forwarding S3 requests to healthy backends.
+type Proxy struct {
+	cfg       Config
+	transport *http.Transport
+	checker   *http.Client
+
+	mu       sync.RWMutex
+	backends map[string]*backend // key: service|addr
+	rr       atomic.Uint64
+}
+
+// New validates cfg and returns a proxy; call Run to start discovery and checks.
+func New(cfg Config) (*Proxy, error) {
+	if len(cfg.LocalServices) == 0 {
+		return nil, errors.New("proxy: at least one local service is required")
+	}
+	if cfg.BackendPort == "" {
+		return nil, errors.New("proxy: backend port is required")
+	}
+	switch cfg.Balance {
+	case "":
+		cfg.Balance = "leastconn"
+	case "leastconn", "roundrobin":
+	default:
+		return nil, fmt.Errorf("proxy: unsupported balance %q", cfg.Balance)
+	}
+	if cfg.HealthInterval <= 0 {
+		cfg.HealthInterval = 2 * time.Second
+	}
+	if cfg.Fall <= 0 {
+		cfg.Fall = 3
+	}
+	if cfg.Rise <= 0 {
+		cfg.Rise = 2
+	}
+	if cfg.DiscoveryInterval <= 0 {
+		cfg.DiscoveryInterval = 10 * time.Second
+	}
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = 2 * time.Second
+	}
+	if cfg.ServerTimeout <= 0 {
+		cfg.ServerTimeout = 60 * time.Second
+	}
+	if cfg.ClientTimeout <= 0 {
+		cfg.ClientTimeout = 60 * time.Second
+	}
+	if cfg.LookupHost == nil {
+		cfg.LookupHost = net.DefaultResolver.LookupHost
+	}
+	dialer := &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}
+	tr := &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: cfg.ServerTimeout,
+	}
+	return &Proxy{
+		cfg:       cfg,
+		transport: tr,
+		checker:   &http.Client{Transport: tr, Timeout: cfg.ConnectTimeout + time.Second},
+		backends:  map[string]*backend{},
+	}, nil
+}
+
+// Run discovers backends and health-checks them until ctx is done.
+func (p *Proxy) Run(ctx context.Context) {
+	p.discover(ctx)
+	p.checkAll(ctx)
+	disc := time.NewTicker(p.cfg.DiscoveryInterval)
+	defer disc.Stop()
+	hc := time.NewTicker(p.cfg.HealthInterval)
+	defer hc.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.transport.CloseIdleConnections()
+			return
+		case <-disc.C:
+			p.discover(ctx)
+		case <-hc.C:
+			p.checkAll(ctx)
+		}
+	}
+}
+
+// discover resolves tasks.<service> for every service and syncs the backend set.
+// A failed lookup keeps that service's current backends.
+func (p *Proxy) discover(ctx context.Context) {
+	type svc struct {
+		name   string
+		backup bool
+	}
+	var svcs []svc
+	for _, s := range p.cfg.LocalServices {
+		svcs = append(svcs, svc{s, false})
+	}
+	if p.cfg.RemoteService != "" {
+		svcs = append(svcs, svc{p.cfg.RemoteService, true})
+	}
+	found := map[string]*backend{}
+	failed := map[string]bool{}
+	for _, s := range svcs {
+		lctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		ips, err := p.cfg.LookupHost(lctx, "tasks."+s.name)
+		cancel()
+		if err != nil {
+			slog.Warn("proxy discovery", "service", s.name, "error", err)
+			failed[s.name] = true
+			continue
+		}
+		for _, ip := range ips {
+			addr := net.JoinHostPort(ip, p.cfg.BackendPort)
+			found[s.name+"|"+addr] = &backend{service: s.name, addr: addr, backup: s.backup,
+				url: &url.URL{Scheme: "http", Host: addr}}
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, b := range p.backends {
+		if _, ok := found[k]; !ok && !failed[b.service] {
+			slog.Info("proxy backend removed", "service", b.service, "addr", b.addr)
+			delete(p.backends, k)
+		}
+	}
+	for k, b := range found {
+		if _, ok := p.backends[k]; !ok {
+			slog.Info("proxy backend added", "service", b.service, "addr", b.addr, "backup", b.backup)
+			p.backends[k] = b
+		}
+	}
+}
+
+func (p *Proxy) snapshot() []*backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]*backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].service != out[j].service {
+			return out[i].service < out[j].service
+		}
+		return out[i].addr < out[j].addr
+	})
+	return out
+}
+
+func (p *Proxy) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, b := range p.snapshot() {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			p.check(ctx, b)
+		}(b)
+	}
+	wg.Wait()
+}
+
+// check probes one backend; status 200-399 passes. The first probe decides the
+// initial state, afterwards Fall/Rise consecutive results flip it.
+func (p *Proxy) check(ctx context.Context, b *backend) {
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url.String()+p.cfg.HealthPath, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := p.checker.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 399 {
+			return fmt.Errorf("health status %d", resp.StatusCode)
+		}
+		return nil
+	}()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastCheck = time.Now().UTC()
+	was := b.healthy
+	if err != nil {
+		b.lastErr = err.Error()
+		b.okRun, b.failRun = 0, b.failRun+1
+		if !b.checked || b.failRun >= p.cfg.Fall {
+			b.healthy = false
+		}
+	} else {
+		b.lastErr = ""
+		b.okRun, b.failRun = b.okRun+1, 0
+		if !b.checked || b.okRun >= p.cfg.Rise {
+			b.healthy = true
+		}
+	}
+	if b.checked && was != b.healthy {
+		slog.Warn("proxy backend state", "service", b.service, "addr", b.addr, "healthy", b.healthy, "error", b.lastErr)
+	}
+	b.checked = true
+}
+
+func (b *backend) isHealthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthy
+}
+
+// pick chooses a healthy primary, or a healthy backup when no primary is up.
+func (p *Proxy) pick(exclude map[*backend]bool) *backend {
+	all := p.snapshot()
+	for _, backup := range []bool{false, true} {
+		var cands []*backend
+		for _, b := range all {
+			if b.backup == backup && !exclude[b] && b.isHealthy() {
+				cands = append(cands, b)
+			}
+		}
+		if len(cands) == 0 {
+			continue
+		}
+		start := int(p.rr.Add(1) % uint64(len(cands)))
+		if p.cfg.Balance == "roundrobin" {
+			return cands[start]
+		}
+		// leastconn; the rotating start spreads ties
+		best := cands[start]
+		for i := 1; i < len(cands); i++ {
+			b := cands[(start+i)%len(cands)]
+			if b.active.Load() < best.active.Load() {
+				best = b
+			}
+		}
+		return best
+	}
+	return nil
+}
+
+// Server returns the HTTP server for the proxy listening on addr. Its
+// timeouts only cover the start of a request; ServeHTTP then extends the
+// deadlines on every body read and write, so slow or stalled clients are
+// dropped after ClientTimeout while large objects can take as long as they
+// keep moving.
+func (p *Proxy) Server(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           p,
+		ReadHeaderTimeout: p.cfg.ClientTimeout,
+		ReadTimeout:       p.cfg.ClientTimeout,
+		WriteTimeout:      p.writeWindow(),
+		IdleTimeout:       p.cfg.ClientTimeout,
+	}
+}
+
+// writeWindow is how long a response write may be pending: the backend may
+// take ServerTimeout before its headers arrive.
+func (p *Proxy) writeWindow() time.Duration {
+	return p.cfg.ClientTimeout + p.cfg.ServerTimeout
+}
+
+// idleBody extends the connection deadlines on every read of the request body.
+type idleBody struct {
+	io.ReadCloser
+	p  *Proxy
+	rc *http.ResponseController
+}
+
+func (b *idleBody) Read(buf []byte) (int, error) {
+	_ = b.rc.SetReadDeadline(time.Now().Add(b.p.cfg.ClientTimeout))
+	_ = b.rc.SetWriteDeadline(time.Now().Add(b.p.writeWindow()))
+	return b.ReadCloser.Read(buf)
+}
+
+// idleWriter extends the write deadline on every write of the response.
+type idleWriter struct {
+	http.ResponseWriter
+	p  *Proxy
+	rc *http.ResponseController
+}
+
+func (w *idleWriter) Write(buf []byte) (int, error) {
+	_ = w.rc.SetWriteDeadline(time.Now().Add(w.p.cfg.ClientTimeout))
+	return w.ResponseWriter.Write(buf)
+}
+
+func (w *idleWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// ServeHTTP forwards the request unchanged (Host included, so SigV4 signatures
+// stay valid) and retries other backends on connection errors when the
+// request has no body.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	replayable := r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0
+	rc := http.NewResponseController(w)
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &idleBody{ReadCloser: r.Body, p: p, rc: rc}
+	}
+	w = &idleWriter{ResponseWriter: w, p: p, rc: rc}
+	tried := map[*backend]bool{}
+	for attempt := 0; ; attempt++ {
+		b := p.pick(tried)
+		if b == nil {
+			http.Error(w, "no healthy S3 backend", http.StatusServiceUnavailable)
+			return
+		}
+		tried[b] = true
+		var proxyErr error
+		rp := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(b.url)
+				pr.Out.Host = pr.In.Host
+			},
+			Transport:    p.transport,
+			ErrorHandler: func(_ http.ResponseWriter, _ *http.Request, err error) { proxyErr = err },
+		}
+		b.active.Add(1)
+		b.requests.Add(1)
+		rp.ServeHTTP(w, r)
+		b.active.Add(-1)
+		if proxyErr == nil {
+			return
+		}
+		b.failures.Add(1)
+		slog.Warn("proxy upstream error", "service", b.service, "addr", b.addr, "error", proxyErr)
+		if !replayable || attempt >= p.cfg.Retries || r.Context().Err() != nil {
+			http.Error(w, "upstream error", http.StatusBadGateway)
+			return
+		}
+	}
+}
+
+// Stats returns per-backend counters and health.
+func (p *Proxy) Stats() []BackendStats {
+	var out []BackendStats
+	for _, b := range p.snapshot() {
+		b.mu.Lock()
+		st := BackendStats{Service: b.service, Address: b.addr, Backup: b.backup, Healthy: b.healthy,
+			LastError: b.lastErr, LastCheck: b.lastCheck}
+		b.mu.Unlock()
+		st.ActiveConns = b.active.Load()
+		st.Requests = b.requests.Load()
+		st.Failures = b.failures.Load()
+		out = append(out, st)
+	}
+	return out
+}
+
+// ParseServices splits a comma-separated service list.
+func ParseServices(csv string) []string {
+	var out []string
+	for _, s := range strings.Split(csv, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startBackends starts one server per loopback IP on a shared port, matching
+// how tasks.<service> replicas all listen on the backend port.
+func startBackends(t *testing.T, ips []string, h func(ip string) http.Handler) string {
+	t.Helper()
+	first, err := net.Listen("tcp", ips[0]+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(first.Addr().(*net.TCPAddr).Port)
+	for i, ip := range ips {
+		ln := first
+		if i > 0 {
+			if ln, err = net.Listen("tcp", ip+":"+port); err != nil {
+				t.Skipf("cannot listen on %s: %v", ip, err)
+			}
+		}
+		srv := &httptest.Server{Listener: ln, Config: &http.Server{Handler: h(ip)}}
+		srv.Start()
+		t.Cleanup(srv.Close)
+	}
+	return port
+}
+
+func TestProxy_BalanceHealthAndBackup(t *testing.T) {
+	var down [3]atomic.Bool
+	hits := map[string]*atomic.Int64{}
+	ips := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"}
+	for _, ip := range ips {
+		hits[ip] = &atomic.Int64{}
+	}
+	port := startBackends(t, ips, func(ip string) http.Handler {
+		idx := map[string]int{"127.0.0.1": 0, "127.0.0.2": 1, "127.0.0.3": 2}[ip]
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" {
+				if down[idx].Load() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				return
+			}
+			hits[ip].Add(1)
+			_, _ = w.Write([]byte(r.Host))
+		})
+	})
+	p, err := New(Config{
+		LocalServices: []string{"minio-local"}, RemoteService: "minio-remote",
+		BackendPort: port, HealthPath: "/health", Fall: 1, Rise: 1,
+		LookupHost: func(_ context.Context, host string) ([]string, error) {
+			if host == "tasks.minio-remote" {
+				return ips[2:], nil
+			}
+			return ips[:2], nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	p.discover(ctx)
+	p.checkAll(ctx)
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://volume-s3-lb:8081/bucket/key", nil)
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+	for i := 0; i < 10; i++ {
+		if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != "volume-s3-lb:8081" {
+			t.Fatalf("unexpected response %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	if hits["127.0.0.1"].Load() == 0 || hits["127.0.0.2"].Load() == 0 || hits["127.0.0.3"].Load() != 0 {
+		t.Fatalf("expected traffic on both primaries only: %d %d %d", hits["127.0.0.1"].Load(), hits["127.0.0.2"].Load(), hits["127.0.0.3"].Load())
+	}
+
+	down[0].Store(true)
+	down[1].Store(true)
+	p.checkAll(ctx)
+	if rec := get(); rec.Code != http.StatusOK || hits["127.0.0.3"].Load() != 1 {
+		t.Fatalf("expected backup to serve, code=%d", rec.Code)
+	}
+
+	down[2].Store(true)
+	p.checkAll(ctx)
+	if rec := get(); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without healthy backends, got %d", rec.Code)
+	}
+	stats := p.Stats()
+	if len(stats) != 3 || stats[0].Requests == 0 || !stats[2].Backup || stats[2].Healthy {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestProxy_RetriesConnectionErrors(t *testing.T) {
+	ips := []string{"127.0.0.1", "127.0.0.2"}
+	port := startBackends(t, ips, func(ip string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	})
+	p, _ := New(Config{LocalServices: []string{"s"}, BackendPort: port, HealthPath: "/", Retries: 2,
+		LookupHost: func(context.Context, string) ([]string, error) { return ips, nil }})
+	p.discover(context.Background())
+	p.checkAll(context.Background())
+	// a healthy-looking backend that refuses connections
+	p.mu.Lock()
+	for _, b := range p.backends {
+		if b.addr == net.JoinHostPort("127.0.0.1", port) {
+			b.url.Host = "127.0.0.1:1"
+		}
+	}
+	p.mu.Unlock()
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "http://lb/bucket", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected retry to succeed, got %d", i, rec.Code)
+		}
+	}
+}
+
+func TestProxy_ClientTimeouts(t *testing.T) {
+	port := startBackends(t, []string{"127.0.0.1"}, func(string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n, _ := io.Copy(io.Discard, r.Body)
+			_, _ = w.Write([]byte(strconv.FormatInt(n, 10)))
+		})
+	})
+	p, _ := New(Config{LocalServices: []string{"s"}, BackendPort: port, HealthPath: "/", ClientTimeout: 300 * time.Millisecond,
+		LookupHost: func(context.Context, string) ([]string, error) { return []string{"127.0.0.1"}, nil }})
+	p.discover(context.Background())
+	p.checkAll(context.Background())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := p.Server("")
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	// a client that never sends its headers is dropped
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("idle client kept open: %v", err)
+	}
+
+	// an upload longer than the timeout passes as long as data keeps coming
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 6; i++ {
+			time.Sleep(100 * time.Millisecond)
+			_, _ = pw.Write([]byte("0123456789"))
+		}
+		_ = pw.Close()
+	}()
+	resp, err := http.Post("http://"+ln.Addr().String()+"/bucket/key", "application/octet-stream", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "60" {
+		t.Fatalf("slow upload: %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,233 @@
+// Package s3 is a minimal S3 client (SigV4, path-style or virtual-host
+// addressing, session tokens) covering the few calls the controller needs to
+// manage buckets and prefixes without spawning rclone containers.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Credentials are static or temporary (SessionToken) access keys.
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// Client talks to one S3 endpoint.
+type Client struct {
+	endpoint  *url.URL
+	region    string
+	pathStyle bool
+	creds     Credentials
+	http      *http.Client
+	now       func() time.Time
+}
+
+// Options configure a Client.
+type Options struct {
+	Endpoint string // e.g. http://minio:9000 or https://s3.eu-west-1.amazonaws.com
+	Region   string // defaults to us-east-1
+	// PathStyle addresses buckets as <endpoint>/<bucket> instead of <bucket>.<host>
+	PathStyle   bool
+	Credentials Credentials
+	HTTPClient  *http.Client
+}
+
+// New validates the options and returns a client.
+func New(o Options) (*Client, error) {
+	u, err := url.Parse(strings.TrimSpace(o.Endpoint))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", o.Endpoint)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	region := strings.TrimSpace(o.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+	hc := o.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{endpoint: u, region: region, pathStyle: o.PathStyle, creds: o.Credentials, http: hc, now: time.Now}, nil
+}
+
+// Error is a non-2xx S3 response.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("s3: status %d", e.StatusCode)
+}
+
+// IsNotFound reports whether err is a 404 (NoSuchBucket, NoSuchKey, HEAD 404).
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// objectURL builds the request URL for bucket/key in the configured addressing style.
+func (c *Client) objectURL(bucket, key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	p := u.Path
+	if bucket != "" {
+		if c.pathStyle {
+			p += "/" + bucket
+		} else {
+			u.Host = bucket + "." + u.Host
+		}
+	}
+	if key != "" {
+		p += "/" + key
+	}
+	if p == "" {
+		p = "/"
+	}
+	u.Path = p
+	u.RawPath = escapePath(p)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func (c *Client) do(ctx context.Context, method, bucket, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(bucket, key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	signRequest(req, body, c.creds, c.region, c.now().UTC())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+	return resp, nil
+}
+
+func readError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var x struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(b, &x) == nil {
+		e.Code, e.Message = x.Code, x.Message
+	}
+	return e
+}
+
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+// HeadBucket reports whether the bucket exists and is accessible.
+func (c *Client) HeadBucket(ctx context.Context, bucket string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, bucket, "", nil, nil)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	drain(resp)
+	return true, nil
+}
+
+// CreateBucket creates the bucket; an existing bucket owned by the caller is not an error.
+func (c *Client) CreateBucket(ctx context.Context, bucket string) error {
+	var body []byte
+	if c.region != "us-east-1" {
+		body = []byte(`<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LocationConstraint>` +
+			c.region + `</LocationConstraint></CreateBucketConfiguration>`)
+	}
+	resp, err := c.do(ctx, http.MethodPut, bucket, "", nil, body)
+	if e, ok := err.(*Error); ok && e.Code == "BucketAlreadyOwnedByYou" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
+
+// PutObject uploads a small object (e.g. a "prefix/" directory marker).
+func (c *Client) PutObject(ctx context.Context, bucket, key string, body []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, bucket, key, nil, body)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
+
+// ListResult is one page of ListObjectsV2.
+type ListResult struct {
+	Keys                  []string
+	CommonPrefixes        []string
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// ListObjectsV2 lists up to maxKeys keys under prefix (maxKeys <= 0 uses the server default).
+func (c *Client) ListObjectsV2(ctx context.Context, bucket, prefix, delimiter string, maxKeys int, token string) (*ListResult, error) {
+	q := url.Values{"list-type": {"2"}}
+	if prefix != "" {
+		q.Set("prefix", prefix)
+	}
+	if delimiter != "" {
+		q.Set("delimiter", delimiter)
+	}
+	if maxKeys > 0 {
+		q.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	resp, err := c.do(ctx, http.MethodGet, bucket, "", q, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp)
+	var x struct {
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+		CommonPrefixes []struct {
+			Prefix string `xml:"Prefix"`
+		} `xml:"CommonPrefixes"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&x); err != nil {
+		return nil, fmt.Errorf("s3: decode list: %w", err)
+	}
+	out := &ListResult{IsTruncated: x.IsTruncated, NextContinuationToken: x.NextContinuationToken}
+	for _, o := range x.Contents {
+		out.Keys = append(out.Keys, o.Key)
+	}
+	for _, p := range x.CommonPrefixes {
+		out.CommonPrefixes = append(out.CommonPrefixes, p.Prefix)
+	}
+	return out, nil
+}
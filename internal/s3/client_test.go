@@ -0,0 +1,121 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a path-style, in-memory S3 stand-in for the calls the client makes.
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+	token   string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ak/") ||
+		r.Header.Get("X-Amz-Security-Token") != f.token {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>bad auth</Message></Error>`)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	objs, ok := f.buckets[bucket]
+	switch {
+	case r.Method == http.MethodPut && key == "":
+		if ok {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `<Error><Code>BucketAlreadyOwnedByYou</Code></Error>`)
+			return
+		}
+		f.buckets[bucket] = map[string][]byte{}
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != http.MethodHead {
+			fmt.Fprint(w, `<Error><Code>NoSuchBucket</Code></Error>`)
+		}
+	case r.Method == http.MethodHead && key == "":
+	case r.Method == http.MethodPut:
+		objs[key] = nil
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		prefix := r.URL.Query().Get("prefix")
+		type content struct{ Key string }
+		var res struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []content
+		}
+		var keys []string
+		for k := range objs {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			res.Contents = append(res.Contents, content{k})
+		}
+		_ = xml.NewEncoder(w).Encode(res)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestClient_AgainstFake(t *testing.T) {
+	fake := &fakeS3{buckets: map[string]map[string][]byte{}, token: "tok"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c, err := New(Options{Endpoint: srv.URL, PathStyle: true, Credentials: Credentials{AccessKey: "ak", SecretKey: "sk", SessionToken: "tok"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if ok, err := c.HeadBucket(ctx, "data"); err != nil || ok {
+		t.Fatalf("head missing bucket: ok=%v err=%v", ok, err)
+	}
+	if err := c.CreateBucket(ctx, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBucket(ctx, "data"); err != nil {
+		t.Fatalf("existing own bucket should not fail: %v", err)
+	}
+	if ok, err := c.HeadBucket(ctx, "data"); err != nil || !ok {
+		t.Fatalf("head bucket: ok=%v err=%v", ok, err)
+	}
+	if err := c.PutObject(ctx, "data", "teams/a b/", nil); err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.ListObjectsV2(ctx, "data", "teams/", "", 10, "")
+	if err != nil || len(res.Keys) != 1 || res.Keys[0] != "teams/a b/" {
+		t.Fatalf("list: %+v err=%v", res, err)
+	}
+	if err := c.PutObject(ctx, "nope", "x", nil); !IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	bad, _ := New(Options{Endpoint: srv.URL, PathStyle: true, Credentials: Credentials{AccessKey: "other", SecretKey: "sk"}})
+	_, err = bad.HeadBucket(ctx, "data")
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, got %v", err)
+	}
+}
+
+func TestObjectURL(t *testing.T) {
+	vh, _ := New(Options{Endpoint: "https://s3.eu-west-1.amazonaws.com"})
+	if got := vh.objectURL("b", "a/k", nil).String(); got != "https://b.s3.eu-west-1.amazonaws.com/a/k" {
+		t.Fatalf("virtual host: %s", got)
+	}
+	ps, _ := New(Options{Endpoint: "http://minio:9000/", PathStyle: true})
+	if got := ps.objectURL("b", "a/k", map[string][]string{"list-type": {"2"}}).String(); got != "http://minio:9000/b/a/k?list-type=2" {
+		t.Fatalf("path style: %s", got)
+	}
+}
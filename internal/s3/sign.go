@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4 (header auth) as used by S3.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+
+const (
+	sigAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFmt   = "20060102T150405Z"
+)
+
+// signRequest sets x-amz-date, x-amz-content-sha256, x-amz-security-token and
+// Authorization. Anonymous requests (no access key) are left unsigned.
+func signRequest(req *http.Request, body []byte, creds Credentials, region string, now time.Time) {
+	signService(req, body, creds, region, "s3", now)
+}
+
+// signService signs for any SigV4 service (s3, sts).
+func signService(req *http.Request, body []byte, creds Credentials, region, service string, now time.Time) {
+	if creds.AccessKey == "" {
+		return
+	}
+	amzDate := now.Format(amzDateFmt)
+	payloadHash := hashHex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	signed, canonHeaders := canonicalHeaders(req)
+	canonReq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders,
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{amzDate[:8], region, service, "aws4_request"}, "/")
+	toSign := strings.Join([]string{sigAlgorithm, amzDate, scope, hashHex([]byte(canonReq))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), amzDate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", sigAlgorithm+" Credential="+creds.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// canonicalHeaders signs host, content-type/md5, range and every x-amz-* header.
+func canonicalHeaders(req *http.Request) (signed, canonical string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	vals := map[string]string{"host": host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" || lk == "content-md5" || lk == "range" {
+			vals[lk] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(vals))
+	for k := range vals {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + ":" + vals[k] + "\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+// canonicalQuery encodes query parameters sorted by key and value.
+func canonicalQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	var parts []string
+	for k, vs := range q {
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+// escapePath URI-encodes every path segment, keeping the slashes.
+func escapePath(p string) string {
+	return uriEncode(p, false)
+}
+
+// uriEncode implements the SigV4 UriEncode: everything except unreserved
+// characters is percent-encoded; '/' is kept unless encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[ch>>4])
+			b.WriteByte(hexDigits[ch&15])
+		}
+	}
+	return b.String()
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
@@ -0,0 +1,135 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Temporary credentials from an STS endpoint (AWS or MinIO).
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRoleWithWebIdentity.html
+
+// STSOptions configure an AssumeRole / AssumeRoleWithWebIdentity call.
+type STSOptions struct {
+	Endpoint    string // e.g. https://sts.amazonaws.com or the MinIO endpoint
+	Region      string // defaults to us-east-1
+	RoleARN     string // optional for MinIO
+	SessionName string
+	Duration    time.Duration // 0 lets the server pick
+	HTTPClient  *http.Client
+}
+
+// TemporaryCredentials are credentials with an expiry.
+type TemporaryCredentials struct {
+	Credentials
+	Expiration time.Time
+}
+
+type stsCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+type stsResponse struct {
+	Role     stsCredentials `xml:"AssumeRoleResult>Credentials"`
+	Identity stsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+// AssumeRoleWithWebIdentity exchanges a web identity (OIDC/JWT) token for
+// temporary credentials. The request is not signed; the token authenticates.
+func AssumeRoleWithWebIdentity(ctx context.Context, o STSOptions, token string) (TemporaryCredentials, error) {
+	form := o.form("AssumeRoleWithWebIdentity")
+	form.Set("WebIdentityToken", token)
+	return o.call(ctx, form, Credentials{})
+}
+
+// AssumeRole obtains temporary credentials signed with base (long-lived) keys.
+func AssumeRole(ctx context.Context, o STSOptions, base Credentials) (TemporaryCredentials, error) {
+	if base.AccessKey == "" {
+		return TemporaryCredentials{}, fmt.Errorf("sts: AssumeRole needs base credentials")
+	}
+	return o.call(ctx, o.form("AssumeRole"), base)
+}
+
+func (o STSOptions) form(action string) url.Values {
+	form := url.Values{}
+	form.Set("Action", action)
+	form.Set("Version", "2011-06-15")
+	if o.RoleARN != "" {
+		form.Set("RoleArn", o.RoleARN)
+	}
+	if o.SessionName != "" {
+		form.Set("RoleSessionName", o.SessionName)
+	}
+	if o.Duration > 0 {
+		form.Set("DurationSeconds", strconv.Itoa(int(o.Duration.Seconds())))
+	}
+	return form
+}
+
+func (o STSOptions) call(ctx context.Context, form url.Values, base Credentials) (TemporaryCredentials, error) {
+	u, err := url.Parse(strings.TrimSpace(o.Endpoint))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return TemporaryCredentials{}, fmt.Errorf("sts: invalid endpoint %q", o.Endpoint)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	region := strings.TrimSpace(o.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+	body := []byte(form.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return TemporaryCredentials{}, err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signService(req, body, base, region, "sts", time.Now().UTC())
+	hc := o.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return TemporaryCredentials{}, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		e := &Error{StatusCode: resp.StatusCode}
+		var x struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(b, &x) == nil {
+			e.Code, e.Message = x.Code, x.Message
+		}
+		return TemporaryCredentials{}, e
+	}
+	var r stsResponse
+	if err := xml.Unmarshal(b, &r); err != nil {
+		return TemporaryCredentials{}, fmt.Errorf("sts: decode response: %w", err)
+	}
+	sc := r.Identity
+	if sc.AccessKeyID == "" {
+		sc = r.Role
+	}
+	if sc.AccessKeyID == "" || sc.SecretAccessKey == "" {
+		return TemporaryCredentials{}, fmt.Errorf("sts: response without credentials")
+	}
+	return TemporaryCredentials{
+		Credentials: Credentials{AccessKey: sc.AccessKeyID, SecretKey: sc.SecretAccessKey, SessionToken: sc.SessionToken},
+		Expiration:  sc.Expiration,
+	}, nil
+}
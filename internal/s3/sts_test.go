@@ -0,0 +1,89 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSTS answers AssumeRole (signed with "base") and
+// AssumeRoleWithWebIdentity (token "jwt") like MinIO does.
+func fakeSTS(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		action := r.PostForm.Get("Action")
+		switch action {
+		case "AssumeRole":
+			if !strings.Contains(r.Header.Get("Authorization"), "Credential=base/") ||
+				!strings.Contains(r.Header.Get("Authorization"), "/sts/aws4_request") {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>bad signature</Message></Error></ErrorResponse>`)
+				return
+			}
+		case "AssumeRoleWithWebIdentity":
+			if r.PostForm.Get("WebIdentityToken") != "jwt" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `<ErrorResponse><Error><Code>InvalidIdentityToken</Code><Message>bad token</Message></Error></ErrorResponse>`)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.PostForm.Get("DurationSeconds") != "900" {
+			t.Errorf("duration: %q", r.PostForm.Get("DurationSeconds"))
+		}
+		fmt.Fprintf(w, `<%[1]sResponse><%[1]sResult><Credentials><AccessKeyId>tmp-%[1]s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>tok</SessionToken><Expiration>2030-01-02T03:04:05Z</Expiration></Credentials></%[1]sResult></%[1]sResponse>`, action)
+	}))
+}
+
+func TestAssumeRole(t *testing.T) {
+	srv := fakeSTS(t)
+	defer srv.Close()
+	o := STSOptions{Endpoint: srv.URL, Duration: 15 * time.Minute}
+	ctx := context.Background()
+
+	tc, err := AssumeRole(ctx, o, Credentials{AccessKey: "base", SecretKey: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	if tc.AccessKey != "tmp-AssumeRole" || tc.SecretKey != "secret" || tc.SessionToken != "tok" || !tc.Expiration.Equal(want) {
+		t.Fatalf("credentials: %+v", tc)
+	}
+	_, err = AssumeRole(ctx, o, Credentials{AccessKey: "other", SecretKey: "s"})
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "AccessDenied" {
+		t.Fatalf("wrong keys: %v", err)
+	}
+	if _, err := AssumeRole(ctx, o, Credentials{}); err == nil {
+		t.Fatal("AssumeRole without base keys")
+	}
+}
+
+func TestAssumeRoleWithWebIdentity(t *testing.T) {
+	srv := fakeSTS(t)
+	defer srv.Close()
+	o := STSOptions{Endpoint: srv.URL, Duration: 15 * time.Minute}
+
+	tc, err := AssumeRoleWithWebIdentity(context.Background(), o, "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.AccessKey != "tmp-AssumeRoleWithWebIdentity" || tc.Expiration.IsZero() {
+		t.Fatalf("credentials: %+v", tc)
+	}
+	_, err = AssumeRoleWithWebIdentity(context.Background(), o, "forged")
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadRequest || e.Code != "InvalidIdentityToken" {
+		t.Fatalf("bad token: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+#!/bin/sh
+set -eu
+
+# CSI conformance run against a throwaway MinIO: builds volume-ops, serves the
+# CSI endpoint on a local socket and runs csi-sanity (kubernetes-csi/csi-test)
+# over it. Needs a Docker host (mounters and host helpers run as containers),
+# root for FUSE and bind mounts, and csi-sanity on PATH:
+#   go install github.com/kubernetes-csi/csi-test/v5/cmd/csi-sanity@latest
+# Extra arguments go to csi-sanity, e.g. --ginkgo.focus=Node.
+
+WORK=${WORK:-/tmp/volume-s3-csi-sanity}
+MINIO_PORT=${MINIO_PORT:-19000}
+MINIO=volume-s3-sanity-minio
+
+cleanup() {
+  [ -n "${PID:-}" ] && kill "$PID" 2>/dev/null || true
+  docker rm -f "$MINIO" >/dev/null 2>&1 || true
+}
+trap cleanup EXIT INT TERM
+
+mkdir -p "$WORK/mnt"
+go build -o "$WORK/volume-ops" ./cmd/volume-ops
+
+docker rm -f "$MINIO" >/dev/null 2>&1 || true
+docker run -d --name "$MINIO" -p "127.0.0.1:$MINIO_PORT:9000" \
+  -e MINIO_ROOT_USER=sanity -e MINIO_ROOT_PASSWORD=sanity-secret \
+  minio/minio server /data >/dev/null
+
+VOLS3_ENDPOINT="http://127.0.0.1:$MINIO_PORT" \
+VOLS3_ACCESS_KEY=sanity VOLS3_SECRET_KEY=sanity-secret \
+VOLS3_RCLONE_REMOTE=S3:sanity VOLS3_AUTOCREATE_BUCKET=true VOLS3_AUTOCREATE_PREFIX=true \
+VOLS3_MOUNTPOINT="$WORK/mnt" VOLS3_CSI_ENDPOINT="unix://$WORK/csi.sock" \
+  "$WORK/volume-ops" >"$WORK/volume-ops.log" 2>&1 &
+PID=$!
+
+i=0
+until [ -S "$WORK/csi.sock" ]; do
+  i=$((i + 1))
+  if [ "$i" -gt 60 ]; then
+    echo "CSI socket did not appear, see $WORK/volume-ops.log" >&2
+    exit 1
+  fi
+  sleep 1
+done
+
+csi-sanity --csi.endpoint="$WORK/csi.sock" \
+  --csi.stagingdir="$WORK/staging" --csi.mountdir="$WORK/target" "$@"